@@ -0,0 +1,194 @@
+package releases
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveExtraFiles are the files from the current directory that are added
+// to each archive created by Archive, when they exist.
+var ArchiveExtraFiles = []string{"LICENSE", "README.md", "README"}
+
+// Archive packages each binary in the bin directory, named NAME-GOOS-GOARCH,
+// into an archive in the output directory named NAME-VERSION-GOOS-GOARCH.tar.gz,
+// or .zip for windows, e.g. porter-v1.2.3-linux-amd64.tar.gz. The binary is
+// renamed to NAME, or NAME.exe, in the archive and the LICENSE and README are
+// included when they are present. Use the output directory as the artifacts
+// directory when publishing so that the checksums and release use the archives.
+// The bin directory defaults to OutputDir.
+func Archive(binDir string, outDir string) error {
+	return archive(LoadMetadata(), binDir, outDir)
+}
+
+// ArchiveName returns the filename of the archive created by Archive for a
+// binary built for the platform, e.g. porter-v1.2.3-linux-amd64.tar.gz, or porter-v1.2.3-windows-amd64.zip.
+func ArchiveName(name string, version string, platform Platform) string {
+	archiveName := fmt.Sprintf("%s-%s-%s-%s", name, version, platform.OS, platform.binaryArch())
+	if platform.OS == "windows" {
+		return archiveName + ".zip"
+	}
+	return archiveName + ".tar.gz"
+}
+
+func archive(info GitMetadata, binDir string, outDir string) error {
+	if binDir == "" {
+		binDir = OutputDir
+	}
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return fmt.Errorf("error listing binaries in %s: %w", binDir, err)
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("error creating the archive directory %s: %w", outDir, err)
+	}
+
+	var extraFiles []string
+	for _, file := range ArchiveExtraFiles {
+		if _, err := os.Stat(file); err == nil {
+			extraFiles = append(extraFiles, file)
+		}
+	}
+
+	// Use the build date for the files in the archive, so that archiving the same build is reproducible
+	modTime, err := time.Parse(time.RFC3339, info.BuildDate)
+	if err != nil {
+		modTime = time.Unix(0, 0)
+	}
+
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		platform, ok := parseBinaryPlatform(entry.Name())
+		if entry.IsDir() || !ok || (ext != "" && ext != ".exe") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".exe"), "-"+platform.OS+"-"+platform.binaryArch())
+		files := map[string]string{
+			addFileExt(name, platform.OS): filepath.Join(binDir, entry.Name()),
+		}
+		for _, file := range extraFiles {
+			files[filepath.Base(file)] = file
+		}
+
+		archivePath := filepath.Join(outDir, ArchiveName(name, info.Version, platform))
+		if platform.OS == "windows" {
+			err = writeArchive(archivePath, files, func(w io.Writer) archiveWriter {
+				return zipWriter{zw: zip.NewWriter(w), modTime: modTime}
+			})
+		} else {
+			err = writeArchive(archivePath, files, func(w io.Writer) archiveWriter {
+				gz := gzip.NewWriter(w)
+				return tarballWriter{gz: gz, tw: tar.NewWriter(gz), modTime: modTime}
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveWriter adds files to an archive.
+type archiveWriter interface {
+	// Add a file to the archive, returning the writer for its contents.
+	Add(name string, fi os.FileInfo) (io.Writer, error)
+
+	// Close flushes the archive.
+	Close() error
+}
+
+// tarballWriter writes a gzipped tarball. Files keep their permissions, so
+// that binaries are still executable once they are extracted.
+type tarballWriter struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+}
+
+func (w tarballWriter) Add(name string, fi os.FileInfo) (io.Writer, error) {
+	hdr := &tar.Header{Name: name, Mode: int64(fi.Mode().Perm()), Size: fi.Size(), ModTime: w.modTime, Typeflag: tar.TypeReg}
+	return w.tw, w.tw.WriteHeader(hdr)
+}
+
+func (w tarballWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gz.Close()
+}
+
+// zipWriter writes a zip archive.
+type zipWriter struct {
+	zw      *zip.Writer
+	modTime time.Time
+}
+
+func (w zipWriter) Add(name string, fi os.FileInfo) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: w.modTime}
+	hdr.SetMode(fi.Mode())
+	return w.zw.CreateHeader(hdr)
+}
+
+func (w zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// writeArchive creates an archive with the files, which are keyed by their
+// name in the archive, and added in a stable order.
+func writeArchive(path string, files map[string]string, newWriter func(io.Writer) archiveWriter) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating archive %s: %w", path, err)
+	}
+	defer out.Close()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	aw := newWriter(out)
+	for _, name := range names {
+		if err := addArchiveFile(aw, name, files[name]); err != nil {
+			return fmt.Errorf("error adding %s to archive %s: %w", files[name], path, err)
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("error writing archive %s: %w", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing archive %s: %w", path, err)
+	}
+	log.Println("Created archive", path)
+	return nil
+}
+
+func addArchiveFile(aw archiveWriter, name string, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := aw.Add(name, fi)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,81 @@
+package releases
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	origDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(origDir) })
+
+	require.NoError(t, os.WriteFile("LICENSE", []byte("Apache 2.0"), 0644))
+	binDir := filepath.Join(dir, "bin")
+	require.NoError(t, os.Mkdir(binDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "porter-linux-amd64"), []byte("linux"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "porter-windows-amd64.exe"), []byte("windows"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, ChecksumsFile), nil, 0644))
+
+	outDir := filepath.Join(dir, "dist")
+	info := GitMetadata{Version: "v1.2.3", BuildDate: "2023-01-02T03:04:05Z"}
+	captureLogs(t)
+	require.NoError(t, archive(info, binDir, outDir))
+
+	entries, err := os.ReadDir(outDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{"porter-v1.2.3-linux-amd64.tar.gz", "porter-v1.2.3-windows-amd64.zip"}, names)
+
+	t.Run("tarball", func(t *testing.T) {
+		f, err := os.Open(filepath.Join(outDir, "porter-v1.2.3-linux-amd64.tar.gz"))
+		require.NoError(t, err)
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+
+		got := map[string]os.FileMode{}
+		contents := map[string]string{}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			got[hdr.Name] = hdr.FileInfo().Mode().Perm()
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			contents[hdr.Name] = string(data)
+			assert.Equal(t, "2023-01-02T03:04:05Z", hdr.ModTime.UTC().Format("2006-01-02T15:04:05Z"))
+		}
+		assert.Equal(t, map[string]os.FileMode{"LICENSE": 0644, "porter": 0755}, got, "the binary should be executable")
+		assert.Equal(t, "linux", contents["porter"])
+		assert.Equal(t, "Apache 2.0", contents["LICENSE"])
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		zr, err := zip.OpenReader(filepath.Join(outDir, "porter-v1.2.3-windows-amd64.zip"))
+		require.NoError(t, err)
+		defer zr.Close()
+
+		var got []string
+		for _, f := range zr.File {
+			got = append(got, f.Name)
+		}
+		assert.Equal(t, []string{"LICENSE", "porter.exe"}, got)
+	})
+}
@@ -0,0 +1,117 @@
+package releases
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// BucketOptions are the options for publishing artifacts to an S3 compatible bucket.
+type BucketOptions struct {
+	// Bucket is the name of the bucket, e.g. porter-canary.
+	Bucket string
+
+	// EndpointURL of the storage service when it isn't AWS, e.g. https://minio.example.com.
+	EndpointURL string
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// PublishToBucket uploads every file in the artifacts directory to
+// BUCKET/PERMALINK/FILENAME with the aws CLI, e.g. s3://porter-canary/canary/porter-linux-amd64.
+// Only permalinks that should be published are uploaded.
+func PublishToBucket(artifactsDir string, opts BucketOptions) error {
+	return publishToBucket(LoadMetadata(), artifactsDir, opts)
+}
+
+func publishToBucket(info GitMetadata, artifactsDir string, opts BucketOptions) error {
+	if opts.Bucket == "" {
+		return fmt.Errorf("the bucket to publish to is required")
+	}
+
+	if !info.ShouldPublishPermalink() {
+		log.Println("Skipping publish to bucket for permalink", info.Permalink)
+		return nil
+	}
+
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+
+	bucket := strings.TrimPrefix(opts.Bucket, "s3://")
+	if isPullRequestPermalink(info.Permalink) {
+		log.Printf("Publishing pull request artifacts to s3://%s/%s, they are not removed automatically so expire them with a lifecycle rule on the bucket\n", bucket, info.Permalink)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		artifact := filepath.Join(artifactsDir, entry.Name())
+		contentType, err := detectContentType(artifact)
+		if err != nil {
+			return err
+		}
+
+		dest := fmt.Sprintf("s3://%s/%s/%s", bucket, info.Permalink, entry.Name())
+		cmd := shx.Command("aws", "s3", "cp", artifact, dest, "--content-type", contentType)
+		if opts.EndpointURL != "" {
+			cmd = cmd.Args("--endpoint-url", opts.EndpointURL)
+		}
+		if err := runOrLog(cmd, opts.DryRun); err != nil {
+			return ErrUploadFailed{Asset: entry.Name(), Destination: dest, Err: err}
+		}
+	}
+	return nil
+}
+
+// artifactContentTypes are the media types of the files that are commonly
+// released, so that they don't depend on the mime types configured on the
+// machine, e.g. signatures and checksums display in the browser.
+var artifactContentTypes = map[string]string{
+	".asc":       "application/pgp-signature",
+	".exe":       "application/octet-stream",
+	".gz":        "application/gzip",
+	".json":      "application/json",
+	".pem":       "application/x-pem-file",
+	".sha256sum": "text/plain; charset=utf-8",
+	".sig":       "text/plain; charset=utf-8",
+	".txt":       "text/plain; charset=utf-8",
+	".xml":       "application/xml",
+	".zip":       "application/zip",
+}
+
+// detectContentType returns the media type of a file based on its extension,
+// falling back to sniffing its contents, e.g. application/octet-stream for a binary.
+func detectContentType(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if contentType, ok := artifactContentTypes[ext]; ok {
+		return contentType, nil
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	defer f.Close()
+
+	// DetectContentType only considers the first 512 bytes
+	header := make([]byte, 512)
+	n, err := f.Read(header)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return http.DetectContentType(header[:n]), nil
+}
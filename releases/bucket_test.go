@@ -0,0 +1,105 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishToBucket_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	binPath := filepath.Join(dir, "porter-linux-amd64")
+	require.NoError(t, os.WriteFile(binPath, []byte{0x7f, 'E', 'L', 'F', 0x00}, 0755))
+	checksumsPath := filepath.Join(dir, ChecksumsFile)
+	require.NoError(t, os.WriteFile(checksumsPath, []byte("abc123  porter-linux-amd64\n"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), 0755))
+
+	t.Run("canary", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+		opts := BucketOptions{Bucket: "s3://porter-canary", EndpointURL: "https://minio.example.com", DryRun: true}
+		require.NoError(t, publishToBucket(info, dir, opts))
+
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "[dry-run] aws s3 cp "+binPath+" s3://porter-canary/canary/porter-linux-amd64 --content-type application/octet-stream --endpoint-url https://minio.example.com")
+		assert.Contains(t, gotLogs, "[dry-run] aws s3 cp "+checksumsPath+" s3://porter-canary/canary/checksums.txt --content-type text/plain; charset=utf-8")
+		assert.NotContains(t, gotLogs, "nested", "directories should not be uploaded")
+	})
+
+	t.Run("pull request", func(t *testing.T) {
+		t.Setenv(PublishPullRequestArtifacts, "true")
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "pr-123", Version: "v1.2.3-4-g8252b6e"}
+		require.NoError(t, publishToBucket(info, dir, BucketOptions{Bucket: "porter-canary", DryRun: true}))
+
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "s3://porter-canary/pr-123/porter-linux-amd64")
+		assert.Contains(t, gotLogs, "expire them with a lifecycle rule")
+	})
+
+	t.Run("unpublished permalink", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "dev", Version: "v1.2.3-4-g8252b6e"}
+		require.NoError(t, publishToBucket(info, dir, BucketOptions{Bucket: "porter-canary", DryRun: true}))
+
+		assert.NotContains(t, logs.String(), "aws s3 cp")
+	})
+
+	t.Run("missing bucket", func(t *testing.T) {
+		err := publishToBucket(GitMetadata{Permalink: "canary"}, dir, BucketOptions{DryRun: true})
+		require.ErrorContains(t, err, "bucket to publish to is required")
+	})
+}
+
+func TestPublishToBucket_UploadFailed(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "checksums.txt"), []byte("abc123  porter-linux-amd64\n"), 0644))
+	t.Setenv(DryRunMode, "")
+	useFakeCommand(t, "aws", "exit 1")
+
+	info := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+	err := publishToBucket(info, dir, BucketOptions{Bucket: "porter-canary"})
+
+	var uploadErr ErrUploadFailed
+	require.ErrorAs(t, err, &uploadErr)
+	assert.Equal(t, "checksums.txt", uploadErr.Asset)
+	assert.Equal(t, "s3://porter-canary/canary/checksums.txt", uploadErr.Destination)
+}
+
+func TestDetectContentType(t *testing.T) {
+	dir := t.TempDir()
+	testcases := []struct {
+		filename        string
+		contents        []byte
+		wantContentType string
+	}{
+		{filename: "porter-linux-amd64", contents: []byte{0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x01, 0x00}, wantContentType: "application/octet-stream"},
+		{filename: "porter-windows-amd64.exe", contents: []byte("MZ"), wantContentType: "application/octet-stream"},
+		{filename: "LICENSE", contents: []byte("Apache License\nVersion 2.0\n"), wantContentType: "text/plain; charset=utf-8"},
+		{filename: "checksums.txt", contents: []byte("abc123  porter-linux-amd64\n"), wantContentType: "text/plain; charset=utf-8"},
+		{filename: "checksums.txt.asc", contents: []byte("-----BEGIN PGP SIGNATURE-----\n"), wantContentType: "application/pgp-signature"},
+		{filename: "porter-linux-amd64.sig", contents: []byte("MEUCIQ=="), wantContentType: "text/plain; charset=utf-8"},
+		{filename: "porter-linux-amd64.pem", contents: []byte("-----BEGIN CERTIFICATE-----\n"), wantContentType: "application/x-pem-file"},
+		{filename: "porter-linux-amd64.sbom.json", contents: []byte("{}"), wantContentType: "application/json"},
+		{filename: "porter-linux-amd64.sha256sum", contents: []byte("abc123  porter-linux-amd64\n"), wantContentType: "text/plain; charset=utf-8"},
+		{filename: "porter-v1.2.3-linux-amd64.tar.gz", contents: []byte{0x1f, 0x8b}, wantContentType: "application/gzip"},
+		{filename: "porter-v1.2.3-windows-amd64.ZIP", contents: []byte("PK"), wantContentType: "application/zip"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.filename, func(t *testing.T) {
+			path := filepath.Join(dir, tc.filename)
+			require.NoError(t, os.WriteFile(path, tc.contents, 0644))
+
+			gotContentType, err := detectContentType(path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantContentType, gotContentType)
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package releases
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/carolynvs/magex/mgx"
 	"github.com/carolynvs/magex/shx"
@@ -16,18 +21,262 @@ var (
 	runtimePlatform       = "linux"
 	supportedClientGOOS   = []string{"linux", "darwin", "windows"}
 	supportedClientGOARCH = []string{"amd64", "arm64"}
+
+	// OutputDir is the directory where build and release artifacts are written when
+	// a directory isn't specified, e.g. set it to _output to match the CI system.
+	OutputDir = "bin"
+
+	// DefaultPlatforms are the platforms built by XBuildAllWith when none are specified.
+	DefaultPlatforms = []Platform{
+		{OS: "linux", Arch: "amd64"},
+		{OS: "linux", Arch: "arm64"},
+		{OS: "darwin", Arch: "amd64"},
+		{OS: "darwin", Arch: "arm64"},
+		{OS: "windows", Arch: "amd64"},
+	}
 )
 
+// XBuildPlatforms is the environment variable that overrides the default
+// platforms that are cross-compiled, e.g. linux/amd64,darwin/arm64.
+const XBuildPlatforms = "XBUILD_PLATFORMS"
+
+// platformPair matches a platform in the format OS/ARCH[/VARIANT], e.g. linux/amd64 or linux/arm/v7.
+var platformPair = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9]+(/[a-z0-9]+)?$`)
+
+// platformVariants are the supported variants of each architecture, and the
+// environment variable that selects the variant when building, e.g. GOARM=7 for arm/v7.
+var platformVariants = map[string]struct {
+	envVar   string
+	variants map[string]string
+}{
+	"arm": {envVar: "GOARM", variants: map[string]string{"v5": "5", "v6": "6", "v7": "7"}},
+}
+
+// Platform is a target operating system and architecture for a build.
+type Platform struct {
+	// OS is the GOOS of the platform, e.g. linux.
+	OS string
+
+	// Arch is the GOARCH of the platform, e.g. amd64.
+	Arch string
+
+	// Variant of the architecture, e.g. v7 for arm, which sets GOARM. Optional.
+	Variant string
+}
+
+// String returns the platform in the format OS/ARCH, e.g. linux/amd64, or OS/ARCH/VARIANT when it has a variant, e.g. linux/arm/v7.
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Arch + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Arch
+}
+
+// binaryArch is the architecture used in the name of the binary, including the variant, e.g. armv7.
+func (p Platform) binaryArch() string {
+	return p.Arch + p.Variant
+}
+
+// variantEnv returns the environment variable that selects the variant of the architecture when building, e.g. GOARM=7.
+func (p Platform) variantEnv() ([]string, error) {
+	if p.Variant == "" {
+		return nil, nil
+	}
+	supported, ok := platformVariants[p.Arch]
+	if !ok {
+		return nil, fmt.Errorf("unsupported platform %s, %s doesn't have variants", p, p.Arch)
+	}
+	value, ok := supported.variants[p.Variant]
+	if !ok {
+		return nil, fmt.Errorf("unsupported platform %s, %s is not a supported variant of %s", p, p.Variant, p.Arch)
+	}
+	return []string{supported.envVar + "=" + value}, nil
+}
+
+// parsePlatforms parses a comma separated list of platforms in the format OS/ARCH[/VARIANT], e.g. linux/amd64,darwin/arm64,linux/arm/v7.
+func parsePlatforms(value string) ([]Platform, error) {
+	var platforms []Platform
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if !platformPair.MatchString(pair) {
+			return nil, fmt.Errorf("invalid platform %q in %s, it must be in the format OS/ARCH or OS/ARCH/VARIANT, e.g. linux/amd64 or linux/arm/v7", pair, XBuildPlatforms)
+		}
+		parts := strings.Split(pair, "/")
+		platform := Platform{OS: parts[0], Arch: parts[1]}
+		if len(parts) == 3 {
+			platform.Variant = parts[2]
+		}
+		if _, err := platform.variantEnv(); err != nil {
+			return nil, fmt.Errorf("invalid platform %q in %s: %w", pair, XBuildPlatforms, err)
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms, nil
+}
+
+// getPlatforms returns the platforms from XBUILD_PLATFORMS when it's set, otherwise the default platforms.
+func getPlatforms(defaults []Platform) ([]Platform, error) {
+	value := strings.TrimSpace(os.Getenv(XBuildPlatforms))
+	if value == "" {
+		return defaults, nil
+	}
+	return parsePlatforms(value)
+}
+
+// BuildOptions are the options for cross-compiling a binary with XBuildAllWith.
+type BuildOptions struct {
+	// Pkg is the Go package of the project which contains the Version and
+	// Commit variables in its pkg package, e.g. get.porter.sh/porter.
+	Pkg string
+
+	// VersionPackage is the package that contains the Version and Commit
+	// variables, relative to Pkg, e.g. internal/build. Defaults to pkg.
+	VersionPackage string
+
+	// VersionSymbols maps the variables set by the linker flags, Version, Commit,
+	// Permalink and BuildDate, to the names used in VersionPackage when they
+	// are different, e.g. {"Commit": "GitCommit"}.
+	VersionSymbols map[string]string
+
+	// Name of the binary to build, with a main package located at ./cmd/NAME.
+	Name string
+
+	// OutputDir is the directory where the binaries are written. Defaults to the package level OutputDir.
+	OutputDir string
+
+	// Platforms to build. Defaults to the platforms from XBUILD_PLATFORMS, or DefaultPlatforms when it isn't set.
+	Platforms []Platform
+
+	// MaxParallel is the maximum number of concurrent builds. Defaults to the number of CPUs.
+	MaxParallel int
+
+	// LDFlags are additional linker flags, appended to the flags that set the version.
+	LDFlags string
+
+	// Tags are the build tags to use.
+	Tags []string
+
+	// CGO determines if cgo is enabled when building for a platform, e.g. for a
+	// sqlite dependency on linux. Defaults to disabling cgo for every platform.
+	CGO func(Platform) bool
+
+	// CrossCompilers are the C compilers used when cgo is enabled for a
+	// platform other than the host, e.g. aarch64-linux-gnu-gcc for linux/arm64.
+	CrossCompilers map[Platform]string
+
+	// PlatformFlags are additional build tags and flags for specific platforms,
+	// e.g. the windows_service tag for windows, which are merged with Tags and
+	// LDFlags when building for the platform. A platform with only an OS, e.g.
+	// {OS: "windows"}, applies to every architecture of the OS.
+	PlatformFlags map[Platform]PlatformBuildFlags
+}
+
+// PlatformBuildFlags are the additional build tags and flags used when building for a platform.
+type PlatformBuildFlags struct {
+	// Tags are additional build tags, e.g. systemd.
+	Tags []string
+
+	// LDFlags are additional linker flags, appended to the LDFlags of the build.
+	LDFlags string
+
+	// Flags are additional arguments passed to go build, e.g. -trimpath.
+	Flags []string
+}
+
+// getPlatformFlags merges the flags for the OS of the platform with the flags
+// for the platform, so that the most specific flags come last.
+func getPlatformFlags(opts BuildOptions, platform Platform) PlatformBuildFlags {
+	var merged PlatformBuildFlags
+	keys := []Platform{{OS: platform.OS}, {OS: platform.OS, Arch: platform.Arch}}
+	if platform.Variant != "" {
+		keys = append(keys, platform)
+	}
+	for _, key := range keys {
+		flags, ok := opts.PlatformFlags[key]
+		if !ok {
+			continue
+		}
+		for _, tag := range flags.Tags {
+			if !slices.Contains(merged.Tags, tag) {
+				merged.Tags = append(merged.Tags, tag)
+			}
+		}
+		if flags.LDFlags != "" {
+			merged.LDFlags = strings.TrimSpace(merged.LDFlags + " " + flags.LDFlags)
+		}
+		merged.Flags = append(merged.Flags, flags.Flags...)
+	}
+	return merged
+}
+
 func getLDFLAGS(pkg string) string {
 	info := LoadMetadata()
-	return fmt.Sprintf("-w -X %s/pkg.Version=%s -X %s/pkg.Commit=%s", pkg, info.Version, pkg, info.Commit)
+	return "-w " + info.LDFlags(pkg+"/pkg")
+}
+
+func getLDFLAGSWith(opts BuildOptions) string {
+	versionPkg := opts.VersionPackage
+	if versionPkg == "" {
+		versionPkg = "pkg"
+	}
+	info := LoadMetadata()
+	return "-w " + info.LDFlagsWith(opts.Pkg+"/"+strings.Trim(versionPkg, "/"), opts.VersionSymbols)
+}
+
+// LDFlags returns the linker flags that set the Version, Commit and Permalink
+// variables in the specified package, e.g. get.porter.sh/porter/pkg, to the
+// metadata for the build, along with the BuildDate variable when it's known.
+// The result can be passed directly to go build -ldflags.
+func (m GitMetadata) LDFlags(pkgPath string) string {
+	return m.LDFlagsWith(pkgPath, nil)
+}
+
+// LDFlagsWith returns the linker flags like LDFlags, using the variable names
+// from symbols for the metadata that is stored in differently named variables,
+// e.g. {"Commit": "GitCommit"} sets pkg.GitCommit instead of pkg.Commit.
+func (m GitMetadata) LDFlagsWith(pkgPath string, symbols map[string]string) string {
+	type ldflagVar struct {
+		name  string
+		value string
+	}
+	vars := []ldflagVar{
+		{"Version", m.Version},
+		{"Commit", m.Commit},
+		{"Permalink", m.Permalink},
+	}
+	if m.BuildDate != "" {
+		vars = append(vars, ldflagVar{"BuildDate", m.BuildDate})
+	}
+
+	flags := make([]string, 0, len(vars))
+	for _, v := range vars {
+		name := v.name
+		if symbol, ok := symbols[name]; ok && symbol != "" {
+			name = symbol
+		}
+		flags = append(flags, "-X "+quoteLDFlag(fmt.Sprintf("%s.%s=%s", pkgPath, name, v.value)))
+	}
+	return strings.Join(flags, " ")
+}
+
+// quoteLDFlag quotes a flag value containing whitespace or quotes so that go
+// build doesn't split it into separate flags. Go doesn't support escaping
+// quotes, so we use whichever quote isn't in the value.
+func quoteLDFlag(value string) string {
+	if !strings.ContainsAny(value, " \t\n'\"") {
+		return value
+	}
+	if strings.Contains(value, "'") {
+		return `"` + value + `"`
+	}
+	return "'" + value + "'"
 }
 
 func build(pkgName, cmd, outPath, goos, goarch string) error {
 	ldflags := getLDFLAGS(pkgName)
 
 	os.MkdirAll(filepath.Dir(outPath), 0770)
-	outPath += fileExt(goos)
+	outPath = addFileExt(outPath, goos)
 	srcPath := "./cmd/" + cmd
 
 	return shx.Command("go", "build", "-ldflags", ldflags, "-o", outPath, srcPath).
@@ -42,6 +291,22 @@ func fileExt(goos string) string {
 	return ""
 }
 
+// addFileExt adds the executable file extension for the platform, e.g. .exe on
+// windows, to the path when it isn't already present.
+func addFileExt(path string, goos string) string {
+	ext := fileExt(goos)
+	if ext == "" || strings.HasSuffix(path, ext) {
+		return path
+	}
+	return path + ext
+}
+
+// BinaryName returns the filename of a binary built for the platform, e.g.
+// porter-linux-amd64, or porter-windows-amd64.exe on windows.
+func BinaryName(name string, platform Platform) string {
+	return addFileExt(fmt.Sprintf("%s-%s-%s", strings.TrimSuffix(name, ".exe"), platform.OS, platform.binaryArch()), platform.OS)
+}
+
 func BuildRuntime(pkg string, name string, binDir string) error {
 	outPath := filepath.Join(binDir, "runtimes", name+"-runtime")
 	return build(pkg, name, outPath, runtimePlatform, runtimeArch)
@@ -66,27 +331,160 @@ func BuildAll(pkg string, name string, binDir string) error {
 func XBuild(pkg string, name string, binDir string, goos string, goarch string) error {
 	info := LoadMetadata()
 	// file extension is added by the build call
-	outPathPrefix := filepath.Join(binDir, info.Version, fmt.Sprintf("%s-%s-%s", name, goos, goarch))
+	outPathPrefix := filepath.Join(binDir, info.Version, BinaryName(name, Platform{OS: goos, Arch: goarch}))
 	return build(pkg, name, outPathPrefix, goos, goarch)
 }
 
+// XBuildAll cross-compiles the binary for the supported client platforms into
+// BINDIR/VERSION, and copies the build into BINDIR/dev. BINDIR defaults to OutputDir.
 func XBuildAll(pkg string, name string, binDir string) {
-	var g errgroup.Group
+	info := LoadMetadata()
+	if binDir == "" {
+		binDir = OutputDir
+	}
+
+	var platforms []Platform
 	for _, goos := range supportedClientGOOS {
-		goos := goos
 		for _, goarch := range supportedClientGOARCH {
-			goarch := goarch
-			g.Go(func() error {
-				return XBuild(pkg, name, binDir, goos, goarch)
-			})
+			platforms = append(platforms, Platform{OS: goos, Arch: goarch})
 		}
 	}
+	platforms, err := getPlatforms(platforms)
+	mgx.Must(err)
 
-	mgx.Must(g.Wait())
-
-	info := LoadMetadata()
+	mgx.Must(XBuildAllWith(BuildOptions{
+		Pkg:       pkg,
+		Name:      name,
+		OutputDir: filepath.Join(binDir, info.Version),
+		Platforms: platforms,
+		// Preserve building every platform at once
+		MaxParallel: len(platforms),
+	}))
 
 	// Copy most recent build into bin/dev so that subsequent build steps can easily find it, not used for publishing
 	os.RemoveAll(filepath.Join(binDir, "dev"))
 	shx.Copy(filepath.Join(binDir, info.Version), filepath.Join(binDir, "dev"), shx.CopyRecursive)
 }
+
+// XBuildAllWith cross-compiles a binary for each platform, named NAME-GOOS-GOARCH.
+// Builds run concurrently, and the first failed build cancels the remaining builds.
+func XBuildAllWith(opts BuildOptions) error {
+	if opts.OutputDir == "" {
+		opts.OutputDir = OutputDir
+	}
+	if len(opts.Platforms) == 0 {
+		platforms, err := getPlatforms(DefaultPlatforms)
+		if err != nil {
+			return err
+		}
+		opts.Platforms = platforms
+	}
+	if opts.MaxParallel <= 0 {
+		opts.MaxParallel = runtime.NumCPU()
+	}
+
+	if err := os.MkdirAll(opts.OutputDir, 0770); err != nil {
+		return fmt.Errorf("could not create the output directory %s: %w", opts.OutputDir, err)
+	}
+
+	// Check every platform can be built before starting, so a missing cross-compiler doesn't fail part way through
+	for _, platform := range opts.Platforms {
+		if _, err := getCGOEnv(opts, platform); err != nil {
+			return err
+		}
+		if _, err := platform.variantEnv(); err != nil {
+			return err
+		}
+	}
+
+	ldflags := getLDFLAGSWith(opts)
+	if opts.LDFlags != "" {
+		ldflags += " " + opts.LDFlags
+	}
+
+	g, ctx := errgroup.WithContext(context.Background())
+	sem := make(chan struct{}, opts.MaxParallel)
+	for _, platform := range opts.Platforms {
+		platform := platform
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			// Don't start a build after another has already failed
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			cmd, err := buildCommand(ctx, opts, platform, ldflags)
+			if err != nil {
+				return err
+			}
+			if err := cmd.RunV(); err != nil {
+				return fmt.Errorf("error building %s for %s: %w", opts.Name, platform, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// buildCommand prepares the go build command for a platform.
+// The build is killed when the context is cancelled.
+func buildCommand(ctx context.Context, opts BuildOptions, platform Platform, ldflags string) (shx.PreparedCommand, error) {
+	cgoEnv, err := getCGOEnv(opts, platform)
+	if err != nil {
+		return shx.PreparedCommand{}, err
+	}
+	variantEnv, err := platform.variantEnv()
+	if err != nil {
+		return shx.PreparedCommand{}, err
+	}
+
+	platformFlags := getPlatformFlags(opts, platform)
+	if platformFlags.LDFlags != "" {
+		ldflags += " " + platformFlags.LDFlags
+	}
+	tags := append([]string{}, opts.Tags...)
+	for _, tag := range platformFlags.Tags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	outPath := filepath.Join(opts.OutputDir, BinaryName(opts.Name, platform))
+	args := []string{"build", "-ldflags", ldflags}
+	if len(tags) > 0 {
+		args = append(args, "-tags", strings.Join(tags, ","))
+	}
+	args = append(args, platformFlags.Flags...)
+	args = append(args, "-o", outPath, "./cmd/"+opts.Name)
+
+	cmd := shx.PreparedCommand{Cmd: exec.CommandContext(ctx, "go", args...)}
+	return cmd.Stdout(os.Stdout).Stderr(os.Stderr).
+		Env(os.Environ()...).
+		Env(cgoEnv...).
+		Env(variantEnv...).
+		Env("GO111MODULE=on", "GOOS="+platform.OS, "GOARCH="+platform.Arch), nil
+}
+
+// getCGOEnv returns the environment variables that enable or disable cgo for
+// the platform, including the C compiler when cgo is enabled for a platform
+// other than the host.
+func getCGOEnv(opts BuildOptions, platform Platform) ([]string, error) {
+	if opts.CGO == nil || !opts.CGO(platform) {
+		return []string{"CGO_ENABLED=0"}, nil
+	}
+
+	if platform.OS == runtime.GOOS && platform.Arch == runtime.GOARCH {
+		return []string{"CGO_ENABLED=1"}, nil
+	}
+	cc, ok := opts.CrossCompilers[platform]
+	if !ok || cc == "" {
+		return nil, fmt.Errorf("cgo is enabled for %s but no cross-compiler is configured for it in CrossCompilers", platform)
+	}
+	return []string{"CGO_ENABLED=1", "CC=" + cc}, nil
+}
@@ -0,0 +1,358 @@
+package releases
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/carolynvs/magex/shx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestModule creates a Go module with a command named hello that prints its
+// version, and changes into its directory for the duration of the test.
+func useTestModule(t *testing.T) string {
+	tmp := t.TempDir()
+	files := map[string]string{
+		"go.mod":            "module example.com/hello\n\ngo 1.21\n",
+		"pkg/version.go":    "package pkg\n\nvar (\n\tVersion string\n\tCommit  string\n)\n",
+		"cmd/hello/main.go": "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/hello/pkg\"\n)\n\nfunc main() {\n\tfmt.Println(pkg.Version, pkg.Commit)\n}\n",
+	}
+	for path, contents := range files {
+		path = filepath.Join(tmp, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0770))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0660))
+	}
+
+	origDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmp))
+	t.Cleanup(func() { os.Chdir(origDir) })
+	return tmp
+}
+
+func TestXBuildAllWith(t *testing.T) {
+	useMetadata(t, GitMetadata{Version: "v1.2.3", Commit: "8252b6e"})
+
+	t.Run("build host platform", func(t *testing.T) {
+		dir := useTestModule(t)
+
+		host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+		err := XBuildAllWith(BuildOptions{
+			Pkg:       "example.com/hello",
+			Name:      "hello",
+			OutputDir: "dist",
+			Platforms: []Platform{host},
+		})
+		require.NoError(t, err)
+
+		binPath := filepath.Join(dir, "dist", "hello-"+runtime.GOOS+"-"+runtime.GOARCH+fileExt(runtime.GOOS))
+		require.FileExists(t, binPath)
+		output, err := shx.OutputE(binPath)
+		require.NoError(t, err)
+		assert.Equal(t, "v1.2.3 8252b6e", output, "the version was not injected into the binary")
+	})
+
+	t.Run("windows", func(t *testing.T) {
+		dir := useTestModule(t)
+
+		err := XBuildAllWith(BuildOptions{
+			Pkg:       "example.com/hello",
+			Name:      "hello",
+			OutputDir: "dist",
+			Platforms: []Platform{{OS: "windows", Arch: "amd64"}},
+		})
+		require.NoError(t, err)
+
+		checksumsPath := filepath.Join(dir, ChecksumsFile)
+		require.NoError(t, GenerateChecksums(filepath.Join(dir, "dist"), checksumsPath))
+		checksums, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		assert.Regexp(t, `^[0-9a-f]{64}  hello-windows-amd64\.exe\n$`, string(checksums))
+	})
+
+	t.Run("arm variant", func(t *testing.T) {
+		dir := useTestModule(t)
+
+		err := XBuildAllWith(BuildOptions{
+			Pkg:       "example.com/hello",
+			Name:      "hello",
+			OutputDir: "dist",
+			Platforms: []Platform{{OS: "linux", Arch: "arm", Variant: "v7"}},
+		})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "dist", "hello-linux-armv7"))
+	})
+
+	t.Run("custom output directory", func(t *testing.T) {
+		dir := useTestModule(t)
+		origOutputDir := OutputDir
+		OutputDir = "_output"
+		t.Cleanup(func() { OutputDir = origOutputDir })
+
+		host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+		require.NoError(t, XBuildAllWith(BuildOptions{Pkg: "example.com/hello", Name: "hello", Platforms: []Platform{host}}))
+		require.NoError(t, Archive("", filepath.Join(OutputDir, "dist")))
+		require.NoError(t, GenerateChecksums("", ""))
+
+		outputDir := filepath.Join(dir, "_output")
+		archiveExt := ".tar.gz"
+		if runtime.GOOS == "windows" {
+			archiveExt = ".zip"
+		}
+		assert.FileExists(t, filepath.Join(outputDir, BinaryName("hello", host)))
+		assert.FileExists(t, filepath.Join(outputDir, "dist", "hello-v1.2.3-"+runtime.GOOS+"-"+runtime.GOARCH+archiveExt))
+		assert.FileExists(t, filepath.Join(outputDir, ChecksumsFile))
+		assert.NoDirExists(t, filepath.Join(dir, "bin"), "nothing should be written to the default output directory")
+	})
+
+	t.Run("build failure", func(t *testing.T) {
+		useTestModule(t)
+
+		err := XBuildAllWith(BuildOptions{
+			Pkg:       "example.com/hello",
+			Name:      "missing",
+			Platforms: []Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}},
+		})
+		require.ErrorContains(t, err, "error building missing for")
+	})
+}
+
+func TestGetPlatforms(t *testing.T) {
+	t.Run("override", func(t *testing.T) {
+		t.Setenv(XBuildPlatforms, "linux/amd64, darwin/arm64")
+
+		platforms, err := getPlatforms(DefaultPlatforms)
+		require.NoError(t, err)
+		assert.Equal(t, []Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}, platforms)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		t.Setenv(XBuildPlatforms, "")
+
+		platforms, err := getPlatforms(DefaultPlatforms)
+		require.NoError(t, err)
+		assert.Equal(t, DefaultPlatforms, platforms)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Setenv(XBuildPlatforms, "linux/amd64,darwin")
+
+		_, err := getPlatforms(DefaultPlatforms)
+		require.ErrorContains(t, err, `invalid platform "darwin" in XBUILD_PLATFORMS`)
+
+		err = XBuildAllWith(BuildOptions{Pkg: "example.com/hello", Name: "hello"})
+		require.ErrorContains(t, err, `invalid platform "darwin"`)
+	})
+
+	t.Run("variant", func(t *testing.T) {
+		t.Setenv(XBuildPlatforms, "linux/amd64,linux/arm/v7")
+
+		platforms, err := getPlatforms(DefaultPlatforms)
+		require.NoError(t, err)
+		assert.Equal(t, []Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm", Variant: "v7"}}, platforms)
+		assert.Equal(t, "linux/arm/v7", platforms[1].String())
+	})
+
+	t.Run("unsupported variant", func(t *testing.T) {
+		t.Setenv(XBuildPlatforms, "linux/arm/v9")
+		_, err := getPlatforms(DefaultPlatforms)
+		require.ErrorContains(t, err, `invalid platform "linux/arm/v9" in XBUILD_PLATFORMS: unsupported platform linux/arm/v9, v9 is not a supported variant of arm`)
+
+		t.Setenv(XBuildPlatforms, "linux/amd64/v7")
+		_, err = getPlatforms(DefaultPlatforms)
+		require.ErrorContains(t, err, "amd64 doesn't have variants")
+	})
+}
+
+func TestBuildCommand(t *testing.T) {
+	opts := BuildOptions{
+		Name:      "porter",
+		OutputDir: "bin",
+		Tags:      []string{"integration", "experimental"},
+	}
+
+	cmd, err := buildCommand(context.Background(), opts, Platform{OS: "windows", Arch: "arm64"}, "-w -X main.Version=v1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go", "build", "-ldflags", "-w -X main.Version=v1.2.3", "-tags", "integration,experimental",
+		"-o", filepath.Join("bin", "porter-windows-arm64.exe"), "./cmd/porter"}, cmd.Cmd.Args)
+	assert.Subset(t, cmd.Cmd.Env, []string{"CGO_ENABLED=0", "GOOS=windows", "GOARCH=arm64"})
+}
+
+func TestBuildCommand_PlatformFlags(t *testing.T) {
+	opts := BuildOptions{
+		Name:      "porter",
+		OutputDir: "bin",
+		LDFlags:   "-s",
+		Tags:      []string{"integration"},
+		PlatformFlags: map[Platform]PlatformBuildFlags{
+			{OS: "windows"}:                {Tags: []string{"windows_service"}, Flags: []string{"-trimpath"}},
+			{OS: "linux"}:                  {Tags: []string{"systemd"}},
+			{OS: "linux", Arch: "arm64"}:   {Tags: []string{"systemd", "arm64_assembly"}, LDFlags: "-extldflags=-static"},
+			{OS: "darwin", Arch: "arm64"}:  {Tags: []string{"metal"}},
+			{OS: "linux", Arch: "riscv64"}: {Tags: []string{"unused"}},
+		},
+	}
+
+	testcases := []struct {
+		platform    Platform
+		wantLDFlags string
+		wantArgs    []string
+	}{
+		{platform: Platform{OS: "windows", Arch: "amd64"}, wantLDFlags: "-w -s",
+			wantArgs: []string{"-tags", "integration,windows_service", "-trimpath", "-o", filepath.Join("bin", "porter-windows-amd64.exe")}},
+		{platform: Platform{OS: "linux", Arch: "amd64"}, wantLDFlags: "-w -s",
+			wantArgs: []string{"-tags", "integration,systemd", "-o", filepath.Join("bin", "porter-linux-amd64")}},
+		{platform: Platform{OS: "linux", Arch: "arm64"}, wantLDFlags: "-w -s -extldflags=-static",
+			wantArgs: []string{"-tags", "integration,systemd,arm64_assembly", "-o", filepath.Join("bin", "porter-linux-arm64")}},
+		{platform: Platform{OS: "darwin", Arch: "amd64"}, wantLDFlags: "-w -s",
+			wantArgs: []string{"-tags", "integration", "-o", filepath.Join("bin", "porter-darwin-amd64")}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.platform.String(), func(t *testing.T) {
+			ldflags := "-w " + opts.LDFlags
+			cmd, err := buildCommand(context.Background(), opts, tc.platform, ldflags)
+			require.NoError(t, err)
+
+			wantArgs := append([]string{"go", "build", "-ldflags", tc.wantLDFlags}, tc.wantArgs...)
+			assert.Equal(t, append(wantArgs, "./cmd/porter"), cmd.Cmd.Args)
+		})
+	}
+}
+
+func TestBuildCommand_Variant(t *testing.T) {
+	opts := BuildOptions{Name: "porter", OutputDir: "bin"}
+
+	cmd, err := buildCommand(context.Background(), opts, Platform{OS: "linux", Arch: "arm", Variant: "v7"}, "-w")
+	require.NoError(t, err)
+	assert.Contains(t, cmd.Cmd.Args, filepath.Join("bin", "porter-linux-armv7"))
+	assert.Subset(t, cmd.Cmd.Env, []string{"GOOS=linux", "GOARCH=arm", "GOARM=7"})
+
+	_, err = buildCommand(context.Background(), opts, Platform{OS: "linux", Arch: "arm", Variant: "v9"}, "-w")
+	require.ErrorContains(t, err, "v9 is not a supported variant of arm")
+}
+
+func TestBuildCommand_CGO(t *testing.T) {
+	host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	linuxArm64 := Platform{OS: "linux", Arch: "arm64"}
+	if host == linuxArm64 {
+		linuxArm64 = Platform{OS: "linux", Arch: "amd64"}
+	}
+	windows := Platform{OS: "windows", Arch: "amd64"}
+
+	opts := BuildOptions{
+		Name:           "porter",
+		CGO:            func(p Platform) bool { return p.OS == "linux" || p == host },
+		CrossCompilers: map[Platform]string{linuxArm64: "aarch64-linux-gnu-gcc"},
+	}
+
+	// envFor returns the cgo environment variables of the build command for the platform
+	envFor := func(t *testing.T, opts BuildOptions, platform Platform) []string {
+		cmd, err := buildCommand(context.Background(), opts, platform, "")
+		require.NoError(t, err)
+		var env []string
+		for _, v := range cmd.Cmd.Env {
+			if strings.HasPrefix(v, "CGO_ENABLED=") || strings.HasPrefix(v, "CC=") {
+				env = append(env, v)
+			}
+		}
+		return env
+	}
+
+	// Don't inherit the cgo configuration of the machine running the tests
+	for _, name := range []string{"CGO_ENABLED", "CC"} {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+
+	t.Run("host", func(t *testing.T) {
+		assert.Equal(t, []string{"CGO_ENABLED=1"}, envFor(t, opts, host), "the native compiler should be used")
+	})
+
+	t.Run("cross-compiled", func(t *testing.T) {
+		assert.Equal(t, []string{"CGO_ENABLED=1", "CC=aarch64-linux-gnu-gcc"}, envFor(t, opts, linuxArm64))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		if host == windows {
+			t.Skip("cgo is enabled for the host")
+		}
+		assert.Equal(t, []string{"CGO_ENABLED=0"}, envFor(t, opts, windows))
+		assert.Equal(t, []string{"CGO_ENABLED=0"}, envFor(t, BuildOptions{Name: "porter"}, linuxArm64), "cgo should be disabled by default")
+	})
+
+	t.Run("missing cross-compiler", func(t *testing.T) {
+		opts := opts
+		opts.CrossCompilers = nil
+
+		_, err := buildCommand(context.Background(), opts, linuxArm64, "")
+		require.ErrorContains(t, err, "cgo is enabled for "+linuxArm64.String()+" but no cross-compiler is configured")
+
+		err = XBuildAllWith(BuildOptions{Pkg: "example.com/hello", Name: "hello", Platforms: []Platform{linuxArm64}, CGO: opts.CGO})
+		require.ErrorContains(t, err, "no cross-compiler is configured")
+	})
+}
+
+func TestBinaryName(t *testing.T) {
+	windows := Platform{OS: "windows", Arch: "amd64"}
+
+	assert.Equal(t, "porter-linux-amd64", BinaryName("porter", Platform{OS: "linux", Arch: "amd64"}))
+	assert.Equal(t, "porter-windows-amd64.exe", BinaryName("porter", windows))
+	assert.Equal(t, "porter-windows-amd64.exe", BinaryName("porter.exe", windows), "the .exe suffix should only be added once")
+	assert.Equal(t, filepath.Join("bin", "porter.exe"), addFileExt(filepath.Join("bin", "porter.exe"), "windows"))
+	assert.Equal(t, filepath.Join("bin", "porter"), addFileExt(filepath.Join("bin", "porter"), "darwin"))
+}
+
+func TestGitMetadata_LDFlags(t *testing.T) {
+	t.Run("canary", func(t *testing.T) {
+		m := GitMetadata{Permalink: "canary", Version: "v0.30.1-32-gfe72ff73+dirty", Commit: "fe72ff73"}
+
+		ldflags := m.LDFlags("get.porter.sh/porter/pkg")
+		assert.Equal(t, "-X get.porter.sh/porter/pkg.Version=v0.30.1-32-gfe72ff73+dirty -X get.porter.sh/porter/pkg.Commit=fe72ff73 -X get.porter.sh/porter/pkg.Permalink=canary", ldflags)
+	})
+
+	t.Run("build date", func(t *testing.T) {
+		m := GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e", BuildDate: "2023-01-02T03:04:05Z"}
+
+		ldflags := m.LDFlags("get.porter.sh/porter/pkg")
+		assert.Equal(t, "-X get.porter.sh/porter/pkg.Version=v1.2.3 -X get.porter.sh/porter/pkg.Commit=8252b6e -X get.porter.sh/porter/pkg.Permalink=latest -X get.porter.sh/porter/pkg.BuildDate=2023-01-02T03:04:05Z", ldflags)
+	})
+
+	t.Run("renamed symbols", func(t *testing.T) {
+		m := GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e"}
+
+		ldflags := m.LDFlagsWith("get.porter.sh/porter/internal/build", map[string]string{"Version": "BuildVersion", "Commit": "GitCommit"})
+		assert.Equal(t, "-X get.porter.sh/porter/internal/build.BuildVersion=v1.2.3 -X get.porter.sh/porter/internal/build.GitCommit=8252b6e -X get.porter.sh/porter/internal/build.Permalink=latest", ldflags)
+	})
+
+	t.Run("values are quoted", func(t *testing.T) {
+		m := GitMetadata{Permalink: "my channel", Version: "v1.2.3", Commit: "it's"}
+
+		ldflags := m.LDFlags("example.com/pkg")
+		assert.Equal(t, `-X example.com/pkg.Version=v1.2.3 -X "example.com/pkg.Commit=it's" -X 'example.com/pkg.Permalink=my channel'`, ldflags)
+	})
+}
+
+func TestGetLDFLAGSWith(t *testing.T) {
+	useMetadata(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e"})
+
+	t.Run("default", func(t *testing.T) {
+		ldflags := getLDFLAGSWith(BuildOptions{Pkg: "get.porter.sh/porter"})
+		assert.Equal(t, "-w -X get.porter.sh/porter/pkg.Version=v1.2.3 -X get.porter.sh/porter/pkg.Commit=8252b6e -X get.porter.sh/porter/pkg.Permalink=latest", ldflags)
+	})
+
+	t.Run("version package", func(t *testing.T) {
+		opts := BuildOptions{
+			Pkg:            "get.porter.sh/porter",
+			VersionPackage: "pkg/version",
+			VersionSymbols: map[string]string{"Permalink": "Channel"},
+		}
+		ldflags := getLDFLAGSWith(opts)
+		assert.Equal(t, "-w -X get.porter.sh/porter/pkg/version.Version=v1.2.3 -X get.porter.sh/porter/pkg/version.Commit=8252b6e -X get.porter.sh/porter/pkg/version.Channel=latest", ldflags)
+	})
+}
@@ -0,0 +1,266 @@
+package releases
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// gitHubPullRequestRef matches the ref of a pull request build on GitHub Actions, e.g. refs/pull/123/merge
+var gitHubPullRequestRef = regexp.MustCompile(`^refs/pull/(\d+)/`)
+
+// buildEnvironment reads the branch information that a CI build provider
+// exposes through environment variables.
+type buildEnvironment interface {
+	// Name of the build provider, e.g. github.
+	Name() string
+
+	// IsDetected determines if the build is running on this build provider.
+	IsDetected() bool
+
+	// PullRequestBranch returns the source branch of a pull request build.
+	PullRequestBranch() (string, bool)
+
+	// PullRequestNumber returns the number of the pull request for a pull request build, e.g. 123.
+	PullRequestNumber() (string, bool)
+
+	// PullRequestBaseBranch returns the target branch of a pull request build, e.g. main.
+	PullRequestBaseBranch() (string, bool)
+
+	// BranchName returns the branch name of a branch build, e.g. main.
+	// Builds triggered by a tag are not branch builds.
+	BranchName() (string, bool)
+
+	// BuilderID identifies the build that is running, e.g. the URL of a GitHub Actions workflow run.
+	BuilderID() string
+
+	// IsScheduled determines if the build was triggered by a schedule, e.g. a nightly workflow.
+	IsScheduled() bool
+}
+
+// buildEnvironmentVariables are the variables that the build providers read
+// the branch, pull request and trigger of the build from.
+var buildEnvironmentVariables = []string{
+	"TF_BUILD", "BUILD_SOURCEBRANCH", "BUILD_SOURCEBRANCHNAME", "BUILD_REASON",
+	"SYSTEM_PULLREQUEST_SOURCEBRANCH", "SYSTEM_PULLREQUEST_TARGETBRANCH",
+	"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER", "SYSTEM_PULLREQUEST_PULLREQUESTID",
+	"GITHUB_ACTIONS", "GITHUB_HEAD_REF", "GITHUB_BASE_REF", "GITHUB_REF", "GITHUB_REF_NAME", "GITHUB_EVENT_NAME",
+	"GITLAB_CI", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_MERGE_REQUEST_TARGET_BRANCH_NAME",
+	"CI_MERGE_REQUEST_IID", "CI_COMMIT_REF_NAME", "CI_COMMIT_TAG", "CI_PIPELINE_SOURCE",
+}
+
+// buildEnvironments are the supported build providers, in the order that they are detected.
+var buildEnvironments = []buildEnvironment{
+	gitLabEnvironment{},
+	gitHubEnvironment{},
+	azureEnvironment{},
+}
+
+// detectBuildEnvironment returns the build provider that the build is running on,
+// falling back to the local environment when no provider is detected.
+func detectBuildEnvironment() buildEnvironment {
+	for _, env := range buildEnvironments {
+		if env.IsDetected() {
+			return env
+		}
+	}
+	return localEnvironment{}
+}
+
+// azureEnvironment reads the branch from Azure Pipelines.
+type azureEnvironment struct{}
+
+func (azureEnvironment) Name() string {
+	return "azure"
+}
+
+func (azureEnvironment) IsDetected() bool {
+	detected, _ := strconv.ParseBool(os.Getenv("TF_BUILD"))
+	if detected {
+		return true
+	}
+
+	// Support builds that only set the branch variables
+	_, pr := os.LookupEnv("SYSTEM_PULLREQUEST_SOURCEBRANCH")
+	_, branch := os.LookupEnv("BUILD_SOURCEBRANCH")
+	return pr || branch
+}
+
+func (azureEnvironment) PullRequestBranch() (string, bool) {
+	// SYSTEM_PULLREQUEST_SOURCEBRANCH has the full name for Azure Repos, e.g. refs/heads/patch-1, and the short name for GitHub
+	b, ok := os.LookupEnv("SYSTEM_PULLREQUEST_SOURCEBRANCH")
+	return strings.TrimPrefix(b, "refs/heads/"), ok
+}
+
+func (azureEnvironment) PullRequestNumber() (string, bool) {
+	// The number is only set for GitHub pull requests, other repositories only have an id
+	for _, name := range []string{"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER", "SYSTEM_PULLREQUEST_PULLREQUESTID"} {
+		if n := os.Getenv(name); n != "" {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+func (azureEnvironment) PullRequestBaseBranch() (string, bool) {
+	// SYSTEM_PULLREQUEST_TARGETBRANCH has the full name, e.g. refs/heads/main
+	b := strings.TrimPrefix(os.Getenv("SYSTEM_PULLREQUEST_TARGETBRANCH"), "refs/heads/")
+	return b, b != ""
+}
+
+func (azureEnvironment) BranchName() (string, bool) {
+	// BUILD_SOURCEBRANCH has the full name, e.g. refs/heads/release/v1, and is populated for both tags and branches.
+	// BUILD_SOURCEBRANCHNAME only has the last segment of the name, e.g. v1, so it can't identify release branches
+	b, ok := os.LookupEnv("BUILD_SOURCEBRANCH")
+	if !ok || strings.HasPrefix(b, "refs/tags/") {
+		return "", false
+	}
+	if strings.HasPrefix(b, "refs/heads/") {
+		return strings.TrimPrefix(b, "refs/heads/"), true
+	}
+	return os.Getenv("BUILD_SOURCEBRANCHNAME"), true
+}
+
+func (azureEnvironment) BuilderID() string {
+	return fmt.Sprintf("%s%s/_build/results?buildId=%s", os.Getenv("SYSTEM_COLLECTIONURI"), os.Getenv("SYSTEM_TEAMPROJECT"), os.Getenv("BUILD_BUILDID"))
+}
+
+func (azureEnvironment) IsScheduled() bool {
+	return os.Getenv("BUILD_REASON") == "Schedule"
+}
+
+// gitHubEnvironment reads the branch from GitHub Actions.
+type gitHubEnvironment struct{}
+
+func (gitHubEnvironment) Name() string {
+	return "github"
+}
+
+func (gitHubEnvironment) IsDetected() bool {
+	detected, _ := strconv.ParseBool(os.Getenv("GITHUB_ACTIONS"))
+	return detected
+}
+
+func (gitHubEnvironment) PullRequestBranch() (string, bool) {
+	// GITHUB_HEAD_REF is only populated for pull requests
+	b := os.Getenv("GITHUB_HEAD_REF")
+	return b, b != ""
+}
+
+func (gitHubEnvironment) PullRequestNumber() (string, bool) {
+	match := gitHubPullRequestRef.FindStringSubmatch(os.Getenv("GITHUB_REF"))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+func (gitHubEnvironment) PullRequestBaseBranch() (string, bool) {
+	// GITHUB_BASE_REF is only populated for pull requests
+	b := os.Getenv("GITHUB_BASE_REF")
+	return b, b != ""
+}
+
+func (gitHubEnvironment) BranchName() (string, bool) {
+	// GITHUB_REF has the full name, e.g. refs/heads/main. GITHUB_REF_NAME has the short name, e.g. main.
+	// They are populated for both tags and branches
+	ref := os.Getenv("GITHUB_REF")
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return "", false
+	}
+	// GITHUB_REF_NAME may be empty, e.g. in some reusable workflows, so fall back to the short name from GITHUB_REF
+	if b := os.Getenv("GITHUB_REF_NAME"); b != "" {
+		return b, true
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), true
+}
+
+func (gitHubEnvironment) BuilderID() string {
+	return fmt.Sprintf("%s/%s/actions/runs/%s", os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"))
+}
+
+func (gitHubEnvironment) IsScheduled() bool {
+	return os.Getenv("GITHUB_EVENT_NAME") == "schedule"
+}
+
+// gitLabEnvironment reads the branch from GitLab CI.
+type gitLabEnvironment struct{}
+
+func (gitLabEnvironment) Name() string {
+	return "gitlab"
+}
+
+func (gitLabEnvironment) IsDetected() bool {
+	detected, _ := strconv.ParseBool(os.Getenv("GITLAB_CI"))
+	return detected
+}
+
+func (gitLabEnvironment) PullRequestBranch() (string, bool) {
+	b := os.Getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME")
+	return b, b != ""
+}
+
+func (gitLabEnvironment) PullRequestNumber() (string, bool) {
+	n := os.Getenv("CI_MERGE_REQUEST_IID")
+	return n, n != ""
+}
+
+func (gitLabEnvironment) PullRequestBaseBranch() (string, bool) {
+	b := os.Getenv("CI_MERGE_REQUEST_TARGET_BRANCH_NAME")
+	return b, b != ""
+}
+
+func (gitLabEnvironment) BranchName() (string, bool) {
+	// CI_COMMIT_REF_NAME is populated for both tags and branches, CI_COMMIT_TAG is only set for tags
+	if os.Getenv("CI_COMMIT_TAG") != "" {
+		return "", false
+	}
+	b := os.Getenv("CI_COMMIT_REF_NAME")
+	return b, b != ""
+}
+
+func (gitLabEnvironment) BuilderID() string {
+	return os.Getenv("CI_JOB_URL")
+}
+
+func (gitLabEnvironment) IsScheduled() bool {
+	return os.Getenv("CI_PIPELINE_SOURCE") == "schedule"
+}
+
+// localEnvironment is used when the build isn't running on a build provider,
+// and relies entirely upon git to determine the branch.
+type localEnvironment struct{}
+
+func (localEnvironment) Name() string {
+	return "local"
+}
+
+func (localEnvironment) IsDetected() bool {
+	return true
+}
+
+func (localEnvironment) PullRequestBranch() (string, bool) {
+	return "", false
+}
+
+func (localEnvironment) PullRequestNumber() (string, bool) {
+	return "", false
+}
+
+func (localEnvironment) PullRequestBaseBranch() (string, bool) {
+	return "", false
+}
+
+func (localEnvironment) BranchName() (string, bool) {
+	return "", false
+}
+
+func (localEnvironment) BuilderID() string {
+	return "local"
+}
+
+func (localEnvironment) IsScheduled() bool {
+	return false
+}
@@ -0,0 +1,124 @@
+package releases
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// MetadataCache is the environment variable that enables caching the
+// metadata computed by LoadMetadata to a file, so that separate mage
+// processes for the same commit don't have to recompute it.
+const MetadataCache = "PORTER_METADATA_CACHE"
+
+// metadataCacheFile is the contents of the metadata cache.
+type metadataCacheFile struct {
+	// Key identifies the state of the working copy when the metadata was computed.
+	Key string `json:"key"`
+
+	// Metadata computed for the working copy.
+	Metadata GitMetadata `json:"metadata"`
+}
+
+// GetMetadataCachePath returns the path to the metadata cache file in the
+// user's cache directory, e.g. $XDG_CACHE_HOME/porter-magefiles/metadata.json.
+func GetMetadataCachePath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine the cache directory: %w", err)
+	}
+	return filepath.Join(cacheDir, "porter-magefiles", "metadata.json"), nil
+}
+
+// getCachedMetadata returns the metadata from the cache when it was computed for
+// the current commit, otherwise computes it and updates the cache.
+func getCachedMetadata() GitMetadata {
+	if enabled, _ := strconv.ParseBool(os.Getenv(MetadataCache)); !enabled || os.Getenv(VersionOverride) != "" {
+		return getMetadata()
+	}
+
+	cachePath, err := GetMetadataCachePath()
+	if err != nil {
+		log.Println("Skipping the metadata cache:", err)
+		return getMetadata()
+	}
+
+	key, err := getMetadataCacheKey()
+	if err != nil {
+		log.Println("Skipping the metadata cache:", err)
+		return getMetadata()
+	}
+
+	var cache metadataCacheFile
+	if data, err := os.ReadFile(cachePath); err == nil {
+		if err := json.Unmarshal(data, &cache); err == nil && cache.Key == key {
+			log.Println("Using cached metadata from", cachePath)
+			return cache.Metadata
+		}
+	}
+
+	cache = metadataCacheFile{Key: key, Metadata: getMetadata()}
+	if err := writeMetadataCache(cachePath, cache); err != nil {
+		log.Println("Could not update the metadata cache:", err)
+	}
+	return cache.Metadata
+}
+
+// metadataCacheEnv are the environment variables that the metadata is computed
+// from, in addition to the build provider's variables, see buildEnvironmentVariables.
+var metadataCacheEnv = []string{CommitOverride, SourceDateEpoch, PublishPullRequestArtifacts, NightlyBuild}
+
+// getMetadataCacheKey identifies the state of the working copy, so that the
+// cached metadata is invalidated when the commit, its tags, uncommitted changes,
+// or the environment variables and configuration that the metadata depends on differ.
+func getMetadataCacheKey() (string, error) {
+	commit, err := retryGit("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	status, err := retryGit("status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	// Tagging the commit changes its description. It fails when there aren't any tags, which is also part of the state.
+	description, _ := retryGit(describeTagsArgs("--long", "--dirty")...)
+	// Tags on other commits change the latest permalink, see getLatestPermalink
+	tags, err := retryGit("tag", "--list", versionTagPattern())
+	if err != nil {
+		return "", err
+	}
+
+	state := sha256.New()
+	fmt.Fprintf(state, "status=%s\ndescribe=%s\ntags=%s\n", status, description, tags)
+	for _, name := range append(append([]string{}, metadataCacheEnv...), buildEnvironmentVariables...) {
+		fmt.Fprintf(state, "%s=%s\n", name, os.Getenv(name))
+	}
+	fmt.Fprintf(state, "config=%s|%s|%t|%t|%s|%+v\n", TagPrefix, TagPattern, TagHasVPrefix, AnnotatedTagsOnly, RemoteName, Permalinks)
+	// The date is part of the version of nightly builds, so a nightly build on another day isn't cached
+	if isNightlyBuild() {
+		fmt.Fprintf(state, "nightly=%s\n", time.Now().UTC().Format(nightlyDateFormat))
+	}
+
+	return commit + "-" + hex.EncodeToString(state.Sum(nil)), nil
+}
+
+func writeMetadataCache(cachePath string, cache metadataCacheFile) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return fmt.Errorf("error serializing the metadata cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0770); err != nil {
+		return fmt.Errorf("error creating the metadata cache directory: %w", err)
+	}
+	if err := os.WriteFile(cachePath, data, 0660); err != nil {
+		return fmt.Errorf("error writing the metadata cache %s: %w", cachePath, err)
+	}
+	return nil
+}
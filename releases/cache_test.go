@@ -0,0 +1,93 @@
+package releases
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCachedMetadata(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv(MetadataCache, "true")
+	useTestRepo(t)
+	gitCommand(t, "tag", "v1.2.3")
+
+	cachePath, err := GetMetadataCachePath()
+	require.NoError(t, err)
+
+	// editCache modifies the cached version, so we can tell when the cache is used
+	editCache := func(t *testing.T) {
+		data, err := os.ReadFile(cachePath)
+		require.NoError(t, err, "the metadata cache should exist")
+		var cache metadataCacheFile
+		require.NoError(t, json.Unmarshal(data, &cache))
+
+		cache.Metadata.Version = "v9.9.9"
+		require.NoError(t, writeMetadataCache(cachePath, cache))
+	}
+
+	m := getCachedMetadata()
+	assert.Equal(t, "v1.2.3", m.Version)
+
+	t.Run("same commit uses the cache", func(t *testing.T) {
+		editCache(t)
+
+		m := getCachedMetadata()
+		assert.Equal(t, "v9.9.9", m.Version)
+	})
+
+	t.Run("new commit invalidates the cache", func(t *testing.T) {
+		editCache(t)
+		gitCommit(t, "new feature")
+
+		m := getCachedMetadata()
+		assert.Regexp(t, `^v1\.2\.3-1-g[0-9a-f]+$`, m.Version)
+	})
+
+	t.Run("tagging the commit invalidates the cache", func(t *testing.T) {
+		getCachedMetadata()
+		editCache(t)
+		gitCommand(t, "tag", "v1.3.0")
+
+		m := getCachedMetadata()
+		assert.Equal(t, "v1.3.0", m.Version)
+		assert.Equal(t, "latest", m.Permalink)
+		assert.True(t, m.IsTaggedRelease)
+	})
+
+	t.Run("environment invalidates the cache", func(t *testing.T) {
+		editCache(t)
+		t.Setenv(CommitOverride, "8252b6e")
+
+		m := getCachedMetadata()
+		assert.Equal(t, "v1.3.0", m.Version)
+		assert.Equal(t, "8252b6e", m.Commit)
+	})
+
+	t.Run("nightly builds are cached separately", func(t *testing.T) {
+		getCachedMetadata()
+		editCache(t)
+		t.Setenv(NightlyBuild, "true")
+
+		m := getCachedMetadata()
+		assert.Equal(t, "nightly", m.Permalink, "a cached build that isn't nightly should not be used")
+		assert.Regexp(t, `^v1\.3\.0\+nightly\.\d{8}$`, m.Version)
+
+		t.Setenv(NightlyBuild, "false")
+		editCache(t)
+		m = getCachedMetadata()
+		assert.Equal(t, "latest", m.Permalink, "a cached nightly build should not be used when it isn't nightly")
+	})
+
+	t.Run("cache disabled", func(t *testing.T) {
+		t.Setenv(MetadataCache, "false")
+		editCache(t)
+
+		m := getCachedMetadata()
+		assert.NotEqual(t, "v9.9.9", m.Version)
+	})
+}
@@ -0,0 +1,166 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// conventionalCommit matches a changelog entry for a conventional commit, e.g. "- feat(build): add arm64 (8252b6e)".
+var conventionalCommit = regexp.MustCompile(`^- (\w+)(\([^)]*\))?!?: `)
+
+// markdownHeading matches a markdown heading, e.g. "## [v1.2.0] - 2023-01-02", capturing its level and the first word of its title.
+var markdownHeading = regexp.MustCompile(`^(#+)\s+\[?([^\]\s]*)`)
+
+// changelogSections are the headings used to group conventional commits, in the order they are listed.
+var changelogSections = []struct {
+	commitType string
+	heading    string
+}{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"chore", "Chores"},
+}
+
+// GetChangelog returns a markdown list of the commits since the previous tag,
+// suitable for release notes. When the commits use conventional commit
+// prefixes, e.g. feat: or fix:, they are grouped into sections.
+// When there isn't a previous tag, every commit is listed.
+func GetChangelog() (string, error) {
+	args := []string{"log", "--pretty=format:- %s (%h)"}
+	if prevTag, err := retryGit(describeTagsArgs("--abbrev=0", "HEAD^")...); err == nil {
+		args = append(args, prevTag+"..HEAD")
+	}
+
+	commits, err := retryGit(args...)
+	if err != nil {
+		return "", fmt.Errorf("could not list the commits for the changelog: %w", err)
+	}
+	return formatChangelog(commits), nil
+}
+
+// ChangelogSection returns the markdown of the version's section in a
+// hand-curated changelog, such as CHANGELOG.md, for use as release notes. The
+// section starts at the heading for the version, e.g. "## v1.2.0" or
+// "## [1.2.0] - 2023-01-02", and ends at the next heading of the same or a
+// higher level, so that subheadings such as "### Features" are included.
+func ChangelogSection(path string, version string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading the changelog %s: %w", path, err)
+	}
+
+	version = strings.TrimPrefix(version, "v")
+	var section []string
+	level := 0
+	for _, line := range strings.Split(string(contents), "\n") {
+		match := markdownHeading.FindStringSubmatch(line)
+		if level > 0 {
+			if match != nil && len(match[1]) <= level {
+				break
+			}
+			section = append(section, line)
+			continue
+		}
+
+		if match != nil && strings.TrimPrefix(match[2], "v") == version {
+			level = len(match[1])
+		}
+	}
+
+	if level == 0 {
+		return "", fmt.Errorf("the changelog %s does not have a section for %s", path, version)
+	}
+	return strings.TrimSpace(strings.Join(section, "\n")), nil
+}
+
+// tagSignature matches the signature at the end of the message of a signed tag, e.g. -----BEGIN PGP SIGNATURE-----.
+var tagSignature = regexp.MustCompile(`(?m)^-----BEGIN (PGP|SSH) SIGNATURE-----$`)
+
+// TagMessage returns the message of an annotated tag, e.g. v1.2.0, for use as
+// release notes, without the signature of a signed tag. Lightweight tags don't
+// have a message, so they return an error.
+func TagMessage(tag string) (string, error) {
+	output, err := retryGit("tag", "-l", "--format=%(objecttype)\n%(contents)", tag)
+	if err != nil {
+		return "", fmt.Errorf("error reading the message of the tag %s: %w", tag, err)
+	}
+
+	objectType, message, _ := strings.Cut(output, "\n")
+	switch objectType {
+	case "":
+		return "", fmt.Errorf("the tag %s does not exist", tag)
+	case "tag":
+	default:
+		return "", fmt.Errorf("the tag %s is a lightweight tag, so it doesn't have a message", tag)
+	}
+
+	if loc := tagSignature.FindStringIndex(message); loc != nil {
+		message = message[:loc[0]]
+	}
+	return strings.TrimSpace(message), nil
+}
+
+// getReleaseNotes returns the section of the changelog for the version, falling
+// back to the commits since the previous tag when the changelog doesn't have one.
+func getReleaseNotes(changelogPath string, version string) (string, error) {
+	notes, err := ChangelogSection(changelogPath, version)
+	if err == nil {
+		return notes, nil
+	}
+
+	log.Printf("Using the commits since the previous release as the release notes: %s\n", err)
+	return GetChangelog()
+}
+
+// formatChangelog groups the changelog entries by their conventional commit type.
+func formatChangelog(commits string) string {
+	commits = strings.TrimSpace(commits)
+	if commits == "" {
+		return ""
+	}
+
+	grouped := map[string][]string{}
+	var other []string
+	for _, line := range strings.Split(commits, "\n") {
+		match := conventionalCommit.FindStringSubmatch(line)
+		if match == nil {
+			other = append(other, line)
+			continue
+		}
+
+		commitType := strings.ToLower(match[1])
+		if !isChangelogSection(commitType) {
+			other = append(other, line)
+			continue
+		}
+		grouped[commitType] = append(grouped[commitType], line)
+	}
+
+	// Only use sections when the commits follow conventional commits
+	if len(grouped) == 0 {
+		return commits
+	}
+
+	var sections []string
+	for _, section := range changelogSections {
+		if entries, ok := grouped[section.commitType]; ok {
+			sections = append(sections, fmt.Sprintf("## %s\n%s", section.heading, strings.Join(entries, "\n")))
+		}
+	}
+	if len(other) > 0 {
+		sections = append(sections, fmt.Sprintf("## Other Changes\n%s", strings.Join(other, "\n")))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+func isChangelogSection(commitType string) bool {
+	for _, section := range changelogSections {
+		if section.commitType == commitType {
+			return true
+		}
+	}
+	return false
+}
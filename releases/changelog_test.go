@@ -0,0 +1,136 @@
+package releases
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatChangelog(t *testing.T) {
+	t.Run("conventional commits", func(t *testing.T) {
+		commits := `- fix: handle detached HEAD (8252b6e)
+- feat(build): add arm64 binaries (fe72ff7)
+- Update README (a1b2c3d)
+- chore: bump dependencies (d4e5f6a)
+- feat!: remove deprecated flags (b7c8d9e)`
+
+		want := `## Features
+- feat(build): add arm64 binaries (fe72ff7)
+- feat!: remove deprecated flags (b7c8d9e)
+
+## Bug Fixes
+- fix: handle detached HEAD (8252b6e)
+
+## Chores
+- chore: bump dependencies (d4e5f6a)
+
+## Other Changes
+- Update README (a1b2c3d)`
+		assert.Equal(t, want, formatChangelog(commits))
+	})
+
+	t.Run("flat list", func(t *testing.T) {
+		commits := `- Handle detached HEAD (8252b6e)
+- Add arm64 binaries (fe72ff7)`
+
+		assert.Equal(t, commits, formatChangelog(commits))
+	})
+
+	t.Run("no commits", func(t *testing.T) {
+		assert.Empty(t, formatChangelog(""))
+	})
+}
+
+func TestGetChangelog(t *testing.T) {
+	useTestRepo(t)
+	gitCommit(t, "feat: first feature")
+
+	t.Run("first release", func(t *testing.T) {
+		changelog, err := GetChangelog()
+		require.NoError(t, err)
+		assert.Regexp(t, `^## Features\n- feat: first feature \([0-9a-f]+\)\n\n## Other Changes\n- initial commit \([0-9a-f]+\)$`, changelog)
+	})
+
+	t.Run("since previous tag", func(t *testing.T) {
+		gitCommand(t, "tag", "v1.0.0")
+		gitCommit(t, "fix: first fix")
+		gitCommit(t, "Second change")
+		gitCommand(t, "tag", "v1.0.1")
+
+		changelog, err := GetChangelog()
+		require.NoError(t, err)
+		assert.Regexp(t, `^## Bug Fixes\n- fix: first fix \([0-9a-f]+\)\n\n## Other Changes\n- Second change \([0-9a-f]+\)$`, changelog)
+	})
+}
+
+func TestChangelogSection(t *testing.T) {
+	const changelogPath = "testdata/changelog/CHANGELOG.md"
+
+	t.Run("middle section", func(t *testing.T) {
+		section, err := ChangelogSection(changelogPath, "v1.2.0")
+		require.NoError(t, err)
+		assert.Equal(t, "### Features\n- Publish releases to a bucket\n\n### Bug Fixes\n- Handle a detached HEAD", section)
+	})
+
+	t.Run("without a v prefix", func(t *testing.T) {
+		section, err := ChangelogSection(changelogPath, "1.1.0")
+		require.NoError(t, err)
+		assert.Equal(t, "- Initial release", section, "the last section should end at the end of the file")
+	})
+
+	t.Run("missing section", func(t *testing.T) {
+		_, err := ChangelogSection(changelogPath, "v1.2.1")
+		require.ErrorContains(t, err, "does not have a section for 1.2.1")
+	})
+
+	t.Run("fall back to the commits", func(t *testing.T) {
+		captureLogs(t)
+		useTestRepo(t)
+		gitCommit(t, "feat: first feature")
+
+		notes, err := getReleaseNotes("missing/CHANGELOG.md", "v1.0.0")
+		require.NoError(t, err)
+		assert.Contains(t, notes, "- feat: first feature")
+	})
+}
+
+func TestTagMessage(t *testing.T) {
+	useTestRepo(t)
+	gitCommand(t, "tag", "-a", "v1.2.0", "--cleanup=whitespace", "-m", `Release v1.2.0
+
+### Features
+- Add arm64 binaries
+
+### Bug Fixes
+- Fix the install script on macOS
+-----BEGIN PGP SIGNATURE-----
+
+iQEzBAABCAAdFiEEexample
+=abcd
+-----END PGP SIGNATURE-----`)
+
+	t.Run("signed tag", func(t *testing.T) {
+		message, err := TagMessage("v1.2.0")
+		require.NoError(t, err)
+		assert.Equal(t, `Release v1.2.0
+
+### Features
+- Add arm64 binaries
+
+### Bug Fixes
+- Fix the install script on macOS`, message, "the signature should be removed")
+	})
+
+	t.Run("lightweight tag", func(t *testing.T) {
+		gitCommand(t, "tag", "v1.2.1")
+
+		_, err := TagMessage("v1.2.1")
+		require.EqualError(t, err, "the tag v1.2.1 is a lightweight tag, so it doesn't have a message")
+	})
+
+	t.Run("missing tag", func(t *testing.T) {
+		_, err := TagMessage("v9.9.9")
+		require.EqualError(t, err, "the tag v9.9.9 does not exist")
+	})
+}
@@ -0,0 +1,92 @@
+package releases
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ChangedPaths returns the files that changed between the tag, e.g. v1.2.3,
+// and HEAD, which is useful for skipping builds when nothing they depend on
+// changed. When there isn't a previous tag, i.e. sinceTag is empty, every
+// file in the repository is treated as changed.
+//
+// Files matching any of the ignore patterns are left out, e.g. docs or
+// .github/*, so that changes which don't affect the build don't trigger one.
+// The patterns use filepath.Match, and are matched against the path and each
+// of the directories containing it, so docs ignores everything in docs.
+func ChangedPaths(sinceTag string, ignore ...string) ([]string, error) {
+	for _, pattern := range ignore {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+		}
+	}
+
+	args := []string{"ls-files"}
+	if sinceTag != "" {
+		args = []string{"diff", "--name-only", sinceTag + "..HEAD"}
+	}
+
+	output, err := retryGit(args...)
+	if err != nil {
+		if sinceTag == "" {
+			return nil, fmt.Errorf("could not list the files in the repository: %w", err)
+		}
+		return nil, fmt.Errorf("could not list the files changed since %s: %w", sinceTag, err)
+	}
+
+	var paths []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !pathIgnored(line, ignore) {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
+// PathChangedSince returns if any file in the directory, or the file, specified
+// by the prefix changed between the tag and HEAD, e.g. mixins/helm. The paths
+// are relative to the root of the repository. When there isn't a previous tag,
+// i.e. tag is empty, everything has changed. Changed files matching any of the
+// ignore patterns are left out, as with ChangedPaths.
+func PathChangedSince(tag string, prefix string, ignore ...string) (bool, error) {
+	if tag == "" {
+		return true, nil
+	}
+
+	paths, err := ChangedPaths(tag, ignore...)
+	if err != nil {
+		return false, err
+	}
+	return pathChanged(paths, prefix), nil
+}
+
+// pathChanged returns if any of the changed paths is the prefix or is in the
+// prefix directory. The prefix only matches whole path segments, so mixins/helm
+// doesn't match mixins/helm3.
+func pathChanged(paths []string, prefix string) bool {
+	prefix = strings.Trim(path.Clean(strings.ReplaceAll(prefix, "\\", "/")), "/")
+	if prefix == "." || prefix == "" {
+		return len(paths) > 0
+	}
+
+	for _, p := range paths {
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// pathIgnored returns if the path, or any of the directories containing it, matches one of the patterns.
+func pathIgnored(p string, patterns []string) bool {
+	for ; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
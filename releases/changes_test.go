@@ -0,0 +1,97 @@
+package releases
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedPaths(t *testing.T) {
+	t.Setenv(GitRetries, "1")
+
+	t.Run("since tag", func(t *testing.T) {
+		useFakeCommand(t, "git", `if [ "$*" = "diff --name-only v1.2.3..HEAD" ]; then printf 'mixins/helm/helm.go\nmixins/helm3/helm3.go\nREADME.md\n'; else exit 1; fi`)
+
+		paths, err := ChangedPaths("v1.2.3")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"mixins/helm/helm.go", "mixins/helm3/helm3.go", "README.md"}, paths)
+
+		changed, err := PathChangedSince("v1.2.3", "mixins/helm")
+		require.NoError(t, err)
+		assert.True(t, changed, "a file in mixins/helm changed")
+
+		changed, err = PathChangedSince("v1.2.3", "mixins/kubernetes/")
+		require.NoError(t, err)
+		assert.False(t, changed, "nothing in mixins/kubernetes changed")
+	})
+
+	t.Run("nothing changed", func(t *testing.T) {
+		useFakeCommand(t, "git", `exit 0`)
+
+		paths, err := ChangedPaths("v1.2.3")
+		require.NoError(t, err)
+		assert.Empty(t, paths)
+
+		changed, err := PathChangedSince("v1.2.3", "mixins/helm")
+		require.NoError(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("no previous tag", func(t *testing.T) {
+		useFakeCommand(t, "git", `if [ "$*" = "ls-files" ]; then printf 'go.mod\nmixins/helm/helm.go\n'; else exit 1; fi`)
+
+		paths, err := ChangedPaths("")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"go.mod", "mixins/helm/helm.go"}, paths, "every file should be changed")
+
+		changed, err := PathChangedSince("", "mixins/kubernetes")
+		require.NoError(t, err)
+		assert.True(t, changed, "everything has changed when there isn't a previous tag")
+	})
+
+	t.Run("ignored paths", func(t *testing.T) {
+		useFakeCommand(t, "git", `if [ "$*" = "diff --name-only v1.2.3..HEAD" ]; then printf 'docs/content/install.md\n.github/workflows/build.yml\n'; else exit 1; fi`)
+
+		paths, err := ChangedPaths("v1.2.3", "docs", ".github/*")
+		require.NoError(t, err)
+		assert.Empty(t, paths)
+
+		changed, err := PathChangedSince("v1.2.3", ".", "docs", ".github")
+		require.NoError(t, err)
+		assert.False(t, changed, "only ignored files changed")
+
+		changed, err = PathChangedSince("v1.2.3", ".", ".github")
+		require.NoError(t, err)
+		assert.True(t, changed, "the docs changed and aren't ignored")
+
+		_, err = ChangedPaths("v1.2.3", "docs[")
+		require.ErrorContains(t, err, `invalid ignore pattern "docs["`)
+	})
+
+	t.Run("unknown tag", func(t *testing.T) {
+		useFakeCommand(t, "git", `echo "fatal: bad revision 'v9.9.9..HEAD'" >&2; exit 128`)
+
+		_, err := PathChangedSince("v9.9.9", "mixins/helm")
+		require.ErrorContains(t, err, "could not list the files changed since v9.9.9")
+	})
+}
+
+func TestPathChanged(t *testing.T) {
+	paths := []string{"go.mod", "mixins/helm/helm.go"}
+
+	assert.True(t, pathChanged(paths, "go.mod"), "a changed file should match")
+	assert.True(t, pathChanged(paths, "./mixins/"), "the prefix should be cleaned")
+	assert.False(t, pathChanged(paths, "mixins/he"), "the prefix should only match whole path segments")
+	assert.True(t, pathChanged(paths, "."), "the root matches any change")
+	assert.False(t, pathChanged(nil, "."))
+}
+
+func TestPathIgnored(t *testing.T) {
+	assert.True(t, pathIgnored("docs/content/install.md", []string{"docs"}), "files in an ignored directory should be ignored")
+	assert.True(t, pathIgnored(".github/workflows/build.yml", []string{".github/*"}), "a pattern should match the directories containing the file")
+	assert.True(t, pathIgnored("README.md", []string{"*.md"}))
+	assert.False(t, pathIgnored("mixins/helm/README.md", []string{"*.md"}), "patterns should be matched against the whole path")
+	assert.False(t, pathIgnored("documentation/index.md", []string{"docs"}))
+	assert.False(t, pathIgnored("go.mod", nil))
+}
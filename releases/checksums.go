@@ -0,0 +1,290 @@
+package releases
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ChecksumsFile is the name of the file attached to a release that lists the
+// checksum of each release artifact.
+const ChecksumsFile = "checksums.txt"
+
+// ChecksumWorkers is the number of files that are hashed concurrently by
+// GenerateChecksums. Defaults to GOMAXPROCS.
+var ChecksumWorkers = runtime.GOMAXPROCS(0)
+
+// ChecksumAlgorithm is a hash algorithm used to generate checksums, e.g. sha512.
+type ChecksumAlgorithm string
+
+const (
+	// SHA256 checksums, which are used by the checksums file of a release.
+	SHA256 ChecksumAlgorithm = "sha256"
+
+	// SHA512 checksums, for consumers that require a stronger hash.
+	SHA512 ChecksumAlgorithm = "sha512"
+)
+
+// newHash returns a hash for the algorithm.
+func (a ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q, it must be %s or %s", a, SHA256, SHA512)
+	}
+}
+
+// ChecksumsFileFor returns the name of the checksums file for the algorithm, e.g. checksums_sha512.txt.
+func ChecksumsFileFor(algorithm ChecksumAlgorithm) string {
+	return fmt.Sprintf("checksums_%s.txt", algorithm)
+}
+
+// isChecksumsFile determines if the file lists the checksums of the artifacts, e.g. checksums.txt or checksums_sha512.txt.
+func isChecksumsFile(path string) bool {
+	name := filepath.Base(path)
+	return name == ChecksumsFile || (strings.HasPrefix(name, "checksums_") && strings.HasSuffix(name, ".txt"))
+}
+
+// GenerateChecksums writes the SHA256 checksum of every file in the artifacts
+// directory to the output file, sorted by filename, using the same format as
+// sha256sum so that it can be verified using `sha256sum -c`.
+// The checksums files, e.g. checksums.txt and checksums_sha512.txt, are skipped when
+// they are located in the artifacts directory, along with signatures, so that signing
+// the artifacts doesn't change the checksums.
+// The artifacts directory defaults to OutputDir, and the output file defaults to
+// ChecksumsFile in the artifacts directory.
+//
+// When algorithms are specified, the checksums for each algorithm are also
+// written next to the output file, named with ChecksumsFileFor, e.g.
+// checksums_sha256.txt and checksums_sha512.txt. Each file is only read once,
+// no matter how many algorithms are used.
+func GenerateChecksums(artifactsDir string, outputPath string, algorithms ...ChecksumAlgorithm) error {
+	return generateChecksums(artifactsDir, outputPath, ChecksumWorkers, algorithms...)
+}
+
+func generateChecksums(artifactsDir string, outputPath string, workers int, algorithms ...ChecksumAlgorithm) error {
+	if artifactsDir == "" {
+		artifactsDir = OutputDir
+	}
+	if outputPath == "" {
+		outputPath = filepath.Join(artifactsDir, ChecksumsFile)
+	}
+	outputPath, err := filepath.Abs(outputPath)
+	if err != nil {
+		return fmt.Errorf("error resolving the checksums file path %s: %w", outputPath, err)
+	}
+
+	for _, algorithm := range algorithms {
+		if _, err := algorithm.newHash(); err != nil {
+			return err
+		}
+	}
+
+	// The output file always has the SHA256 checksums, followed by a file for each algorithm
+	outputs := []string{outputPath}
+	algorithms = append([]ChecksumAlgorithm{SHA256}, algorithms...)
+	for _, algorithm := range algorithms[1:] {
+		outputs = append(outputs, filepath.Join(filepath.Dir(outputPath), ChecksumsFileFor(algorithm)))
+	}
+
+	var files []string
+	err = filepath.WalkDir(artifactsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip every checksums file, including those left over from a previous run with other algorithms
+		if d.IsDir() || isSignatureFile(path) || isChecksumsFile(d.Name()) {
+			return nil
+		}
+
+		if absPath, _ := filepath.Abs(path); slices.Contains(outputs, absPath) {
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+	sort.Strings(files)
+
+	sums, err := checksumFilesWith(files, workers, algorithms)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("error creating the directory for the checksums file %s: %w", outputPath, err)
+	}
+	for j, output := range outputs {
+		var checksums strings.Builder
+		for i, path := range files {
+			// Use the path relative to the artifacts directory so that sha256sum can find nested files
+			relPath, _ := filepath.Rel(artifactsDir, path)
+			fmt.Fprintf(&checksums, "%s  %s\n", sums[i][j], filepath.ToSlash(relPath))
+		}
+		if err := os.WriteFile(output, []byte(checksums.String()), 0644); err != nil {
+			return fmt.Errorf("error writing checksums file %s: %w", output, err)
+		}
+	}
+	return nil
+}
+
+// VerifyChecksums hashes each file listed in the checksums file, relative to
+// the artifacts directory, and checks that it matches its listed checksum. This
+// catches artifacts that changed after the checksums were generated. Every missing
+// or mismatched file is reported at once.
+func VerifyChecksums(checksumsPath string, artifactsDir string) error {
+	contents, err := os.ReadFile(checksumsPath)
+	if err != nil {
+		return fmt.Errorf("error reading checksums file %s: %w", checksumsPath, err)
+	}
+
+	var problems, files, wantSums []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			problems = append(problems, fmt.Sprintf("invalid line %q", line))
+			continue
+		}
+
+		name := strings.TrimPrefix(fields[1], "*")
+		path := filepath.Join(artifactsDir, filepath.FromSlash(name))
+		if _, err := os.Stat(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+			continue
+		}
+		files = append(files, path)
+		wantSums = append(wantSums, fields[0])
+	}
+
+	gotSums, err := checksumFiles(files, ChecksumWorkers)
+	if err != nil {
+		return err
+	}
+	for i, path := range files {
+		if gotSums[i] != wantSums[i] {
+			relPath, _ := filepath.Rel(artifactsDir, path)
+			problems = append(problems, fmt.Sprintf("checksum mismatch for %s: expected %s but got %s", filepath.ToSlash(relPath), wantSums[i], gotSums[i]))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("the checksums in %s don't match the artifacts:\n  - %s", checksumsPath, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// checksumFiles returns the SHA256 checksum of each file, in the same order as the files,
+// hashing up to the specified number of files concurrently.
+func checksumFiles(files []string, workers int) ([]string, error) {
+	sums, err := checksumFilesWith(files, workers, []ChecksumAlgorithm{SHA256})
+	if err != nil {
+		return nil, err
+	}
+	sha256Sums := make([]string, len(sums))
+	for i, fileSums := range sums {
+		sha256Sums[i] = fileSums[0]
+	}
+	return sha256Sums, nil
+}
+
+// checksumFilesWith returns the checksums of each file for every algorithm,
+// in the same order as the files and algorithms, hashing up to the specified
+// number of files concurrently.
+func checksumFilesWith(files []string, workers int, algorithms []ChecksumAlgorithm) ([][]string, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each file's checksums are stored at the same index as the file, so that the
+	// workers don't share any state and the output order doesn't depend on
+	// which hash finishes first
+	sums := make([][]string, len(files))
+	var g errgroup.Group
+	sem := make(chan struct{}, workers)
+	for i, path := range files {
+		i, path := i, path
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+
+			fileSums, err := checksumFileWith(path, algorithms)
+			if err != nil {
+				return err
+			}
+			sums[i] = fileSums
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return sums, nil
+}
+
+// checksumFile returns the hex encoded SHA256 checksum of a file. The file is
+// streamed through the hash so that large artifacts are not read into memory.
+func checksumFile(path string) (string, error) {
+	sums, err := checksumFileWith(path, []ChecksumAlgorithm{SHA256})
+	if err != nil {
+		return "", err
+	}
+	return sums[0], nil
+}
+
+// checksumFileWith returns the hex encoded checksum of a file for each algorithm.
+func checksumFileWith(path string, algorithms []ChecksumAlgorithm) ([]string, error) {
+	data, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading release asset %s: %w", path, err)
+	}
+	defer data.Close()
+
+	sums, err := checksumReader(data, algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("error generating checksum for %s: %w", path, err)
+	}
+	return sums, nil
+}
+
+// checksumReader reads the data once, writing it to a hash for every algorithm
+// at the same time, and returns the hex encoded checksum for each algorithm.
+func checksumReader(data io.Reader, algorithms []ChecksumAlgorithm) ([]string, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		h, err := algorithm.newHash()
+		if err != nil {
+			return nil, err
+		}
+		hashes[i], writers[i] = h, h
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), data); err != nil {
+		return nil, err
+	}
+	sums := make([]string, len(hashes))
+	for i, h := range hashes {
+		sums[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
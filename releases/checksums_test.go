@@ -0,0 +1,170 @@
+package releases
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carolynvs/magex/shx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateChecksums(t *testing.T) {
+	const wantChecksums = `4c195a933ee1d20b78eab93e151ca2a19bf0974e313c089f88ae831a6a13fe00  porter-linux-amd64
+971ff770ac95725d0d25ef992ef0feca589a44cc1b8c6d96468adff396069907  porter-windows-amd64.exe
+`
+
+	t.Run("output outside artifacts directory", func(t *testing.T) {
+		checksumsPath := filepath.Join(t.TempDir(), ChecksumsFile)
+		require.NoError(t, GenerateChecksums("testdata/checksums", checksumsPath))
+
+		gotChecksums, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		// The stale checksums.txt in testdata is an artifact here, so it's included
+		assert.Contains(t, string(gotChecksums), wantChecksums)
+	})
+
+	t.Run("existing checksums file is skipped", func(t *testing.T) {
+		tmp := t.TempDir()
+		require.NoError(t, shx.Copy("testdata/checksums/*", tmp))
+
+		checksumsPath := filepath.Join(tmp, ChecksumsFile)
+		require.NoError(t, GenerateChecksums(tmp, checksumsPath))
+
+		gotChecksums, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		assert.Equal(t, wantChecksums, string(gotChecksums))
+	})
+
+	t.Run("signatures are skipped", func(t *testing.T) {
+		tmp := t.TempDir()
+		require.NoError(t, shx.Copy("testdata/checksums/*", tmp))
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, "porter-linux-amd64.sig"), []byte("signature"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, "porter-linux-amd64.pem"), []byte("certificate"), 0644))
+
+		checksumsPath := filepath.Join(tmp, ChecksumsFile)
+		require.NoError(t, GenerateChecksums(tmp, checksumsPath))
+
+		gotChecksums, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		assert.Equal(t, wantChecksums, string(gotChecksums), "signing the artifacts should not change the checksums")
+	})
+
+	t.Run("output is identical regardless of workers", func(t *testing.T) {
+		tmp := t.TempDir()
+		for i := 0; i < 25; i++ {
+			name := filepath.Join(tmp, "artifacts", fmt.Sprintf("porter-%02d", i))
+			require.NoError(t, os.MkdirAll(filepath.Dir(name), 0770))
+			require.NoError(t, os.WriteFile(name, []byte(strings.Repeat("porter", i)), 0660))
+		}
+
+		serialPath := filepath.Join(tmp, "serial.txt")
+		require.NoError(t, generateChecksums(filepath.Join(tmp, "artifacts"), serialPath, 1))
+		parallelPath := filepath.Join(tmp, "parallel.txt")
+		require.NoError(t, generateChecksums(filepath.Join(tmp, "artifacts"), parallelPath, 8))
+
+		serial, err := os.ReadFile(serialPath)
+		require.NoError(t, err)
+		parallel, err := os.ReadFile(parallelPath)
+		require.NoError(t, err)
+		assert.Equal(t, string(serial), string(parallel))
+		assert.Len(t, strings.Split(strings.TrimSpace(string(parallel)), "\n"), 25)
+	})
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, shx.Copy("testdata/checksums/*", tmp))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "nested"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, "nested", "porter.sbom.json"), []byte("{}"), 0644))
+	checksumsPath := filepath.Join(tmp, ChecksumsFile)
+	require.NoError(t, GenerateChecksums(tmp, checksumsPath))
+
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, VerifyChecksums(checksumsPath, tmp))
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, "porter-linux-amd64"), []byte("tampered"), 0755))
+		require.NoError(t, os.Remove(filepath.Join(tmp, "nested", "porter.sbom.json")))
+
+		err := VerifyChecksums(checksumsPath, tmp)
+		require.ErrorContains(t, err, "checksum mismatch for porter-linux-amd64: expected 4c195a933ee1d20b78eab93e151ca2a19bf0974e313c089f88ae831a6a13fe00")
+		require.ErrorContains(t, err, "nested/porter.sbom.json is missing", "every problem should be reported")
+		assert.NotContains(t, err.Error(), "porter-windows-amd64.exe", "files that match should not be reported")
+	})
+}
+
+func TestGenerateChecksums_Algorithms(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, shx.Copy("testdata/checksums/*", tmp))
+
+	checksumsPath := filepath.Join(tmp, ChecksumsFile)
+	require.NoError(t, GenerateChecksums(tmp, checksumsPath, SHA256, SHA512))
+
+	sha256Sums, err := os.ReadFile(filepath.Join(tmp, "checksums_sha256.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, `4c195a933ee1d20b78eab93e151ca2a19bf0974e313c089f88ae831a6a13fe00  porter-linux-amd64
+971ff770ac95725d0d25ef992ef0feca589a44cc1b8c6d96468adff396069907  porter-windows-amd64.exe
+`, string(sha256Sums))
+
+	checksums, err := os.ReadFile(checksumsPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(sha256Sums), string(checksums), "checksums.txt should keep the SHA256 checksums")
+
+	sha512Sums, err := os.ReadFile(filepath.Join(tmp, "checksums_sha512.txt"))
+	require.NoError(t, err)
+	for _, name := range []string{"porter-linux-amd64", "porter-windows-amd64.exe"} {
+		data, err := os.ReadFile(filepath.Join(tmp, name))
+		require.NoError(t, err)
+		assert.Contains(t, string(sha512Sums), fmt.Sprintf("%x  %s\n", sha512.Sum512(data), name))
+	}
+	assert.Len(t, strings.Split(strings.TrimSpace(string(sha512Sums)), "\n"), 2, "the other checksums files should not be checksummed")
+
+	t.Run("single read", func(t *testing.T) {
+		data := &readCounter{r: strings.NewReader("porter")}
+		sums, err := checksumReader(data, []ChecksumAlgorithm{SHA256, SHA512})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("porter"))), sums[0])
+		assert.Equal(t, fmt.Sprintf("%x", sha512.Sum512([]byte("porter"))), sums[1])
+		assert.Equal(t, len("porter"), data.n, "the data should only be read once for every algorithm")
+	})
+
+	t.Run("leftover checksums files", func(t *testing.T) {
+		tmp := t.TempDir()
+		require.NoError(t, shx.Copy("testdata/checksums/*", tmp))
+		// A previous run generated the SHA512 checksums, which aren't requested this time
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, "checksums_sha512.txt"), []byte("stale"), 0644))
+
+		checksumsPath := filepath.Join(tmp, ChecksumsFile)
+		require.NoError(t, GenerateChecksums(tmp, checksumsPath))
+
+		checksums, err := os.ReadFile(checksumsPath)
+		require.NoError(t, err)
+		assert.Equal(t, string(sha256Sums), string(checksums))
+		assert.NotContains(t, string(checksums), "checksums_sha512.txt", "a leftover checksums file should not be checksummed")
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		err := GenerateChecksums(tmp, checksumsPath, "md5")
+		require.EqualError(t, err, `unsupported checksum algorithm "md5", it must be sha256 or sha512`)
+	})
+}
+
+// readCounter counts the bytes read from the reader.
+type readCounter struct {
+	r io.Reader
+	n int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
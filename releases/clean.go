@@ -0,0 +1,74 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// DefaultCleanPaths are the directories removed by Clean, along with OutputDir.
+var DefaultCleanPaths = []string{"bin", "dist"}
+
+// CleanOptions are the options for removing build and release artifacts.
+type CleanOptions struct {
+	// Paths to remove. Defaults to DefaultCleanPaths.
+	Paths []string
+
+	// KeepCache preserves the metadata cache, see MetadataCache.
+	KeepCache bool
+
+	// DryRun logs the paths that would be removed, without removing them.
+	DryRun bool
+}
+
+// Clean removes the build and release artifacts, such as the bin and dist
+// directories, generated checksums and SBOMs, and the metadata cache.
+func Clean() error {
+	return CleanWith(CleanOptions{})
+}
+
+// CleanWith removes the specified build and release artifacts, along with
+// generated checksums and SBOMs in the current directory. Paths that don't
+// exist are ignored.
+func CleanWith(opts CleanOptions) error {
+	paths := opts.Paths
+	if len(paths) == 0 {
+		paths = append([]string{}, DefaultCleanPaths...)
+		if !slices.Contains(paths, OutputDir) {
+			paths = append(paths, OutputDir)
+		}
+	}
+
+	generated, err := filepath.Glob("*" + SBOMExt)
+	if err != nil {
+		return fmt.Errorf("error listing generated SBOMs: %w", err)
+	}
+	paths = append(append(paths, ChecksumsFile), generated...)
+
+	if !opts.KeepCache {
+		cachePath, err := GetMetadataCachePath()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, cachePath)
+	}
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		if isDryRun(opts.DryRun) {
+			log.Println("[dry-run] rm -r", path)
+			continue
+		}
+
+		log.Println("rm -r", path)
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("error removing %s: %w", path, err)
+		}
+	}
+	return nil
+}
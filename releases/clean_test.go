@@ -0,0 +1,61 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClean(t *testing.T) {
+	// useArtifacts creates build artifacts in a temporary directory, and changes into it
+	useArtifacts := func(t *testing.T) string {
+		tmp := t.TempDir()
+		t.Setenv("XDG_CACHE_HOME", filepath.Join(tmp, "cache"))
+
+		origDir, err := os.Getwd()
+		require.NoError(t, err)
+		require.NoError(t, os.Chdir(tmp))
+		t.Cleanup(func() { os.Chdir(origDir) })
+
+		for _, path := range []string{"bin/porter", "dist/porter-linux-amd64", "_output/porter", ChecksumsFile, "porter.sbom.json", "main.go"} {
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0770))
+			require.NoError(t, os.WriteFile(path, nil, 0660))
+		}
+		require.NoError(t, writeMetadataCache(filepath.Join(tmp, "cache", "porter-magefiles", "metadata.json"), metadataCacheFile{}))
+		return tmp
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		tmp := useArtifacts(t)
+
+		require.NoError(t, Clean())
+
+		assert.NoDirExists(t, "bin")
+		assert.NoDirExists(t, "dist")
+		assert.NoFileExists(t, ChecksumsFile)
+		assert.NoFileExists(t, "porter.sbom.json")
+		assert.NoFileExists(t, filepath.Join(tmp, "cache", "porter-magefiles", "metadata.json"))
+		assert.DirExists(t, "_output", "only the configured paths should be removed")
+		assert.FileExists(t, "main.go", "only the configured paths should be removed")
+	})
+
+	t.Run("custom paths and keep cache", func(t *testing.T) {
+		tmp := useArtifacts(t)
+
+		require.NoError(t, CleanWith(CleanOptions{Paths: []string{"_output"}, KeepCache: true}))
+
+		assert.NoDirExists(t, "_output")
+		assert.DirExists(t, "bin", "only the configured paths should be removed")
+		assert.FileExists(t, filepath.Join(tmp, "cache", "porter-magefiles", "metadata.json"), "the metadata cache should be kept")
+	})
+
+	t.Run("nothing to clean", func(t *testing.T) {
+		useArtifacts(t)
+		require.NoError(t, Clean())
+
+		require.NoError(t, Clean())
+	})
+}
@@ -0,0 +1,77 @@
+package releases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// CommandTimeout is how long a git or gh command may run before it's killed
+// and returns an error, so that a command stuck waiting on the network or
+// for credentials doesn't hang the build. Set it to 0 to disable the timeout.
+// It may also be set with the PORTER_COMMAND_TIMEOUT environment variable. Defaults to 60s.
+var CommandTimeout = 60 * time.Second
+
+// CommandTimeoutOverride is the environment variable that overrides the
+// CommandTimeout, as a duration such as 2m, or 0 to disable the timeout.
+const CommandTimeoutOverride = "PORTER_COMMAND_TIMEOUT"
+
+// noPromptEnv stops git and gh from prompting for credentials, so that they
+// fail instead of waiting for input that never comes on CI.
+var noPromptEnv = []string{"GIT_TERMINAL_PROMPT=0", "GH_PROMPT_DISABLED=1"}
+
+// getCommandTimeout returns the CommandTimeout, from the environment variable when it's set.
+func getCommandTimeout() (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(CommandTimeoutOverride))
+	if value == "" {
+		return CommandTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s value %q, it must be a duration such as 2m, or 0 to disable the timeout", CommandTimeoutOverride, value)
+	}
+	return timeout, nil
+}
+
+// disablePrompts configures the command to fail instead of prompting for credentials.
+func disablePrompts(cmd *exec.Cmd) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	// Copy the environment, so that commands sharing it aren't changed
+	cmd.Env = append(append([]string{}, env...), noPromptEnv...)
+}
+
+// commandWithTimeout returns a copy of the command that's killed when it runs
+// longer than the timeout, and doesn't prompt for credentials. Call cancel once
+// the command has finished, and check its error with timeoutError.
+func commandWithTimeout(cmd *exec.Cmd, timeout time.Duration) (*exec.Cmd, context.Context, context.CancelFunc) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	timed := exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
+	timed.Args[0] = cmd.Args[0]
+	timed.Dir = cmd.Dir
+	timed.Env = cmd.Env
+	disablePrompts(timed)
+
+	// Don't wait for processes started by the command that hold its output open after it's killed
+	timed.WaitDelay = time.Second
+	return timed, ctx, cancel
+}
+
+// timeoutError returns an error that wraps context.DeadlineExceeded when the
+// command failed because it ran longer than the timeout, otherwise the error is returned unchanged.
+func timeoutError(ctx context.Context, cmd *exec.Cmd, timeout time.Duration, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s was killed after %s, set %s to allow more time: %w", strings.Join(cmd.Args, " "), timeout, CommandTimeoutOverride, context.DeadlineExceeded)
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package releases
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/magex/shx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	origTimeout := CommandTimeout
+	CommandTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { CommandTimeout = origTimeout })
+
+	t.Run("git", func(t *testing.T) {
+		useFakeCommand(t, "git", "exec sleep 10")
+
+		start := time.Now()
+		_, err := retryGit("fetch", "--tags")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "git fetch --tags was killed after 100ms, set PORTER_COMMAND_TIMEOUT to allow more time")
+		assert.Less(t, time.Since(start), 5*time.Second, "the command should be killed when it times out")
+	})
+
+	t.Run("gh", func(t *testing.T) {
+		useFakeCommand(t, "gh", "exec sleep 10")
+
+		err := runGitHub(shx.Command("gh", "release", "view", "v1.2.3"), false)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("override", func(t *testing.T) {
+		t.Setenv(CommandTimeoutOverride, "0")
+		useFakeCommand(t, "git", "sleep 0.3; echo done")
+
+		output, err := retryGit("status")
+		require.NoError(t, err, "the timeout should be disabled")
+		assert.Equal(t, "done", output)
+
+		t.Setenv(CommandTimeoutOverride, "soon")
+		_, err = retryGit("status")
+		require.ErrorContains(t, err, `invalid PORTER_COMMAND_TIMEOUT value "soon"`)
+	})
+}
+
+func TestDisablePrompts(t *testing.T) {
+	t.Setenv("GIT_TERMINAL_PROMPT", "")
+	useFakeCommand(t, "git", `echo "$GIT_TERMINAL_PROMPT $GH_PROMPT_DISABLED"`)
+
+	output, err := retryGit("push")
+	require.NoError(t, err)
+	assert.Equal(t, "0 1", output, "git should not prompt for credentials")
+
+	cmd := exec.Command("gh", "auth", "status")
+	cmd.Env = []string{"GH_TOKEN=abc123"}
+	disablePrompts(cmd)
+	assert.Equal(t, "GH_TOKEN=abc123 GIT_TERMINAL_PROMPT=0 GH_PROMPT_DISABLED=1", strings.Join(cmd.Env, " "), "the environment of the command should be kept")
+}
@@ -0,0 +1,103 @@
+package releases
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// ContainerGoVersion is the version of Go used by BuildInContainer when a Go
+// version or image isn't specified, so that every maintainer builds the same binaries.
+var ContainerGoVersion = "1.21.13"
+
+// ContainerBuildOptions are the options for cross-compiling inside a container with BuildInContainer.
+type ContainerBuildOptions struct {
+	// GoVersion is the tag of the golang image to build with, e.g. 1.21.13.
+	// Defaults to ContainerGoVersion, and is ignored when Image is set.
+	GoVersion string
+
+	// Image to build with, e.g. golang:1.21.13@sha256:... to pin the image by digest.
+	// Defaults to golang:GOVERSION.
+	Image string
+
+	// Target is the mage target that is run in the container with the magefile's
+	// zero install mage.go, e.g. XBuildAll. Defaults to XBuildAll.
+	Target string
+
+	// Dir is the root of the repository, which is mounted into the container.
+	// The binaries are written to the OutputDir of the repository on the host. Defaults to the current directory.
+	Dir string
+
+	// DryRun logs the docker command that would be run, without executing it.
+	DryRun bool
+}
+
+// containerSourceDir is where the repository is mounted in the container.
+const containerSourceDir = "/src"
+
+// BuildInContainer runs the XBuildAll mage target, or the Target from the
+// options, inside a pinned golang image with docker, so that the binaries
+// don't depend on the version of Go installed by each maintainer. The
+// repository is mounted into the container, so the binaries are written to
+// the OutputDir on the host. The version, commit and build date of the
+// current build are passed to the container with PORTER_VERSION and
+// PORTER_COMMIT, so that they match a build on the host. The branch isn't known
+// in the container, so untagged builds use the dev permalink.
+func BuildInContainer(opts ContainerBuildOptions) error {
+	cmd, err := buildInContainerCommand(LoadMetadata(), opts)
+	if err != nil {
+		return err
+	}
+	if err := runOrLog(cmd, opts.DryRun); err != nil {
+		return fmt.Errorf("error building in the container: %w", err)
+	}
+	return nil
+}
+
+// buildInContainerCommand prepares the docker run command that builds the repository in a container.
+func buildInContainerCommand(info GitMetadata, opts ContainerBuildOptions) (shx.PreparedCommand, error) {
+	if opts.GoVersion == "" {
+		opts.GoVersion = ContainerGoVersion
+	}
+	if opts.Image == "" {
+		opts.Image = "golang:" + opts.GoVersion
+	}
+	if opts.Target == "" {
+		opts.Target = "XBuildAll"
+	}
+	if opts.Dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return shx.PreparedCommand{}, fmt.Errorf("error getting the current directory: %w", err)
+		}
+		opts.Dir = wd
+	}
+
+	cmd := shx.Command("docker", "run", "--rm",
+		"-v", opts.Dir+":"+containerSourceDir, "-w", containerSourceDir)
+
+	// Write the binaries as the current user, instead of root, with a go cache that the user can write to
+	if runtime.GOOS != "windows" {
+		cmd = cmd.Args("--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+			"-e", "HOME=/tmp", "-e", "GOCACHE=/tmp/.cache/go-build", "-e", "GOPATH=/tmp/go")
+	}
+
+	// Use the metadata from the host, so the build doesn't depend on git in the container
+	cmd = cmd.Args("-e", VersionOverride+"="+TagPrefix+info.Version, "-e", CommitOverride+"="+info.Commit)
+	if info.BuildDate != "" {
+		buildDate, err := time.Parse(time.RFC3339, info.BuildDate)
+		if err != nil {
+			return shx.PreparedCommand{}, fmt.Errorf("could not parse the build date %q: %w", info.BuildDate, err)
+		}
+		cmd = cmd.Args("-e", SourceDateEpoch+"="+strconv.FormatInt(buildDate.Unix(), 10))
+	}
+	if platforms := os.Getenv(XBuildPlatforms); platforms != "" {
+		cmd = cmd.Args("-e", XBuildPlatforms+"="+platforms)
+	}
+
+	return cmd.Args(opts.Image, "go", "run", "mage.go", opts.Target), nil
+}
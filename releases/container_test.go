@@ -0,0 +1,43 @@
+package releases
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildInContainer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the container runs as the current user on linux and macos")
+	}
+	t.Setenv(XBuildPlatforms, "linux/amd64,linux/arm/v7")
+	useMetadata(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e", BuildDate: "2023-01-02T03:04:05Z", IsTaggedRelease: true})
+
+	t.Run("pinned image", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		err := BuildInContainer(ContainerBuildOptions{Dir: "/home/me/porter", DryRun: true})
+		require.NoError(t, err)
+		assert.Contains(t, logs.String(), "[dry-run] docker run --rm -v /home/me/porter:/src -w /src --user ")
+		assert.Contains(t, logs.String(), "-e PORTER_VERSION=v1.2.3 -e PORTER_COMMIT=8252b6e -e SOURCE_DATE_EPOCH=1672628645 -e XBUILD_PLATFORMS=linux/amd64,linux/arm/v7 golang:"+ContainerGoVersion+" go run mage.go XBuildAll")
+	})
+
+	t.Run("custom image and target", func(t *testing.T) {
+		cmd, err := buildInContainerCommand(LoadMetadata(), ContainerBuildOptions{Image: "golang:1.22@sha256:abc123", Target: "XBuildMixin"})
+		require.NoError(t, err)
+
+		wd, err := os.Getwd()
+		require.NoError(t, err)
+		assert.Contains(t, cmd.Cmd.Args, wd+":/src", "the current directory should be mounted by default")
+		assert.Equal(t, []string{"golang:1.22@sha256:abc123", "go", "run", "mage.go", "XBuildMixin"}, cmd.Cmd.Args[len(cmd.Cmd.Args)-5:])
+	})
+
+	t.Run("go version", func(t *testing.T) {
+		cmd, err := buildInContainerCommand(LoadMetadata(), ContainerBuildOptions{GoVersion: "1.22.1"})
+		require.NoError(t, err)
+		assert.Contains(t, cmd.Cmd.Args, "golang:1.22.1")
+	})
+}
@@ -0,0 +1,145 @@
+package releases
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// DoctorOptions are the options for reporting the release plan with DoctorWith.
+type DoctorOptions struct {
+	// Repository is the GitHub repository that the release is published to, e.g. github.com/getporter/porter.
+	// Defaults to the value of PORTER_RELEASE_REPOSITORY, and then the repository
+	// of the RemoteName remote, see DetectRepo.
+	Repository string
+
+	// Name of the binary that is released, e.g. porter. When set, the expected
+	// binaries for the Platforms are included in the plan.
+	Name string
+
+	// Platforms that the binary is built for. Defaults to DefaultPlatforms, or XBUILD_PLATFORMS when it's set.
+	Platforms []Platform
+
+	// Images that are published with PublishImages, used to report the images that are pushed.
+	Images []ImageOptions
+}
+
+// ReleasePlan is what a release of the current build would do, as reported by Doctor.
+type ReleasePlan struct {
+	// Metadata of the build.
+	Metadata GitMetadata `json:"metadata"`
+
+	// ShouldPublishPermalink indicates if the permalink, e.g. canary, is published.
+	ShouldPublishPermalink bool `json:"shouldPublishPermalink"`
+
+	// MovedTags are the git tags that are moved to the current commit, e.g. canary.
+	MovedTags []string `json:"movedTags"`
+
+	// Releases are the GitHub releases that are created or updated, e.g. latest and v1.2.3.
+	Releases []string `json:"releases"`
+
+	// Repository that the release is published to, e.g. github.com/getporter/porter.
+	Repository string `json:"repository"`
+
+	// ReleaseURL is the URL of the release for the build, e.g. https://github.com/getporter/porter/releases/tag/v1.2.3.
+	ReleaseURL string `json:"releaseURL"`
+
+	// Artifacts are the binaries that are expected to be released, e.g. porter-linux-amd64.
+	Artifacts []string `json:"artifacts"`
+
+	// Images are the images that are pushed, including each tag, e.g. ghcr.io/getporter/porter:v1.2.3.
+	Images []string `json:"images"`
+
+	// Problems that would stop the release, e.g. the repository couldn't be detected.
+	Problems []string `json:"problems"`
+}
+
+// Doctor prints the release plan for the current build as JSON to stdout: the
+// resolved metadata, and what would be published, such as the permalink, the
+// tags that are moved and the release URL. Nothing is published, and tools
+// such as gh don't need to be installed.
+func Doctor() error {
+	return DoctorWith(DoctorOptions{})
+}
+
+// DoctorWith prints the release plan for the current build, using the specified options.
+func DoctorWith(opts DoctorOptions) error {
+	plan, err := getReleasePlan(LoadMetadataReadOnly(), opts)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error formatting the release plan: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// getReleasePlan works out what a release of the build would do, using the same decisions as the publish functions.
+func getReleasePlan(info GitMetadata, opts DoctorOptions) (ReleasePlan, error) {
+	plan := ReleasePlan{
+		Metadata:               info,
+		ShouldPublishPermalink: info.ShouldPublishPermalink(),
+		Repository:             opts.Repository,
+	}
+
+	if plan.Repository == "" {
+		plan.Repository = os.Getenv(ReleaseRepository)
+	}
+	if plan.Repository == "" {
+		if host, owner, repo, err := detectRepo(); err == nil {
+			plan.Repository = path.Join(host, owner, repo)
+		} else {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("no release repository specified, set %s to github.com/USERNAME/REPO: %s", ReleaseRepository, err))
+		}
+	}
+	plan.ReleaseURL = releaseURL(info, plan.Repository)
+
+	// Pull request artifacts are only published to a bucket, see publishRelease
+	if plan.ShouldPublishPermalink && !isPullRequestPermalink(info.Permalink) {
+		plan.MovedTags = append(plan.MovedTags, info.Permalink)
+		plan.Releases = append(plan.Releases, info.Permalink)
+	}
+	if minorPermalink := info.MinorPermalink(); minorPermalink != "" {
+		plan.MovedTags = append(plan.MovedTags, minorPermalink)
+		plan.Releases = append(plan.Releases, minorPermalink)
+	}
+	if info.IsTaggedRelease {
+		plan.Releases = append(plan.Releases, info.Version)
+	}
+	if info.IsDirty {
+		plan.Problems = append(plan.Problems, "the working tree has uncommitted changes, so nothing is published")
+	}
+
+	if opts.Name != "" {
+		platforms := opts.Platforms
+		if len(platforms) == 0 {
+			var err error
+			if platforms, err = getPlatforms(DefaultPlatforms); err != nil {
+				return ReleasePlan{}, err
+			}
+		}
+		for _, platform := range platforms {
+			plan.Artifacts = append(plan.Artifacts, BinaryName(opts.Name, platform))
+		}
+	}
+
+	tags := getImageTags(info)
+	for _, image := range opts.Images {
+		registries := []string{image.Registry}
+		for _, registry := range image.AdditionalRegistries {
+			registries = append(registries, registry.Registry)
+		}
+		for _, registry := range registries {
+			for _, tag := range tags {
+				plan.Images = append(plan.Images, fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(registry, "/"), image.Repository, tag))
+			}
+		}
+	}
+
+	return plan, nil
+}
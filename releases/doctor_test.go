@@ -0,0 +1,70 @@
+package releases
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetReleasePlan(t *testing.T) {
+	t.Setenv(ReleaseRepository, "github.com/example/porter")
+	t.Setenv(XBuildPlatforms, "")
+	images := []ImageOptions{{
+		Registry:             "ghcr.io/example",
+		Repository:           "porter",
+		AdditionalRegistries: []ImageRegistry{{Registry: "docker.io/example"}},
+	}}
+
+	t.Run("tagged release", func(t *testing.T) {
+		info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+		opts := DoctorOptions{Name: "porter", Platforms: []Platform{{OS: "linux", Arch: "amd64"}, {OS: "windows", Arch: "amd64"}}, Images: images}
+
+		plan, err := getReleasePlan(info, opts)
+		require.NoError(t, err)
+		assert.Equal(t, info, plan.Metadata)
+		assert.True(t, plan.ShouldPublishPermalink)
+		assert.Equal(t, []string{"latest"}, plan.MovedTags)
+		assert.Equal(t, []string{"latest", "v1.2.3"}, plan.Releases)
+		assert.Equal(t, "https://github.com/example/porter/releases/tag/v1.2.3", plan.ReleaseURL)
+		assert.Equal(t, []string{"porter-linux-amd64", "porter-windows-amd64.exe"}, plan.Artifacts)
+		assert.Equal(t, []string{
+			"ghcr.io/example/porter:v1.2.3", "ghcr.io/example/porter:v1", "ghcr.io/example/porter:latest",
+			"docker.io/example/porter:v1.2.3", "docker.io/example/porter:v1", "docker.io/example/porter:latest",
+		}, plan.Images)
+		assert.Empty(t, plan.Problems)
+	})
+
+	t.Run("canary", func(t *testing.T) {
+		info := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+
+		plan, err := getReleasePlan(info, DoctorOptions{Name: "porter"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"canary"}, plan.Releases, "only the permalink should be released")
+		assert.Equal(t, "https://github.com/example/porter/releases/tag/canary", plan.ReleaseURL)
+		assert.Len(t, plan.Artifacts, len(DefaultPlatforms))
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		info := GitMetadata{Permalink: "dev", Version: "v1.2.3-4-g8252b6e+dirty", IsDirty: true}
+
+		plan, err := getReleasePlan(info, DoctorOptions{Images: images})
+		require.NoError(t, err)
+		assert.False(t, plan.ShouldPublishPermalink)
+		assert.Empty(t, plan.MovedTags)
+		assert.Empty(t, plan.Releases)
+		assert.Empty(t, plan.Images)
+		assert.Contains(t, plan.Problems, "the working tree has uncommitted changes, so nothing is published")
+	})
+
+	t.Run("unknown repository", func(t *testing.T) {
+		t.Setenv(ReleaseRepository, "")
+		useTestRepo(t)
+
+		plan, err := getReleasePlan(GitMetadata{Permalink: "canary"}, DoctorOptions{})
+		require.NoError(t, err, "the plan should be reported even when the repository can't be detected")
+		assert.Empty(t, plan.ReleaseURL)
+		require.Len(t, plan.Problems, 1)
+		assert.Contains(t, plan.Problems[0], "no release repository specified")
+	})
+}
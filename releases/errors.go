@@ -0,0 +1,45 @@
+package releases
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotTagged is returned when an operation requires a tagged release, e.g. v1.2.3,
+	// but the build isn't tagged, e.g. a canary build.
+	ErrNotTagged = errors.New("the build is not a tagged release")
+
+	// ErrPermalinkNotPublishable is returned when an operation requires a permalink
+	// that should be published, see GitMetadata.ShouldPublishPermalink.
+	ErrPermalinkNotPublishable = errors.New("the permalink should not be published")
+
+	// ErrAssetNotFound is returned when a release asset doesn't exist at its download URL.
+	ErrAssetNotFound = errors.New("the release asset was not found")
+
+	// ErrChecksumMismatch is returned when a downloaded asset still doesn't match
+	// its published checksum after it has been downloaded again.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// ErrUploadFailed is returned when an asset couldn't be uploaded, so that
+// callers can retry the upload without retrying the rest of the release.
+type ErrUploadFailed struct {
+	// Asset is the name of the file that failed to upload. When several files
+	// are uploaded at once, their names are separated by commas.
+	Asset string
+
+	// Destination that the asset was uploaded to, e.g. the v1.2.3 release or s3://porter-canary/canary.
+	Destination string
+
+	// Err is the error from the upload.
+	Err error
+}
+
+func (e ErrUploadFailed) Error() string {
+	return fmt.Sprintf("error uploading %s to %s: %s", e.Asset, e.Destination, e.Err)
+}
+
+func (e ErrUploadFailed) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,17 @@
+package releases
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrUploadFailed(t *testing.T) {
+	cause := errors.New("connection reset")
+	var err error = ErrUploadFailed{Asset: "porter-linux-amd64", Destination: "the v1.2.3 release", Err: cause}
+
+	assert.EqualError(t, err, "error uploading porter-linux-amd64 to the v1.2.3 release: connection reset")
+	require.ErrorIs(t, err, cause, "the upload error should unwrap to its cause")
+}
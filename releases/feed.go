@@ -0,0 +1,162 @@
+package releases
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FeedOptions are the options for adding a release to an atom feed.
+type FeedOptions struct {
+	// FeedPath is the path to the atom feed. Defaults to atom.xml.
+	FeedPath string
+
+	// Name of the mixin or plugin, e.g. helm.
+	Name string
+
+	// Version that was published, e.g. v1.2.3.
+	Version string
+
+	// Permalink that was published, e.g. canary. Builds that are not a tagged
+	// release are added to the feed under their permalink instead of their version.
+	Permalink string
+
+	// ArtifactURLs are the download links for each platform binary.
+	ArtifactURLs []string
+
+	// Updated is when the release was published. Defaults to now.
+	Updated time.Time
+}
+
+type atomFeed struct {
+	XMLName    xml.Name       `xml:"http://www.w3.org/2005/Atom feed"`
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Author     *atomAuthor    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Entries    []atomEntry    `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Categories []atomCategory `xml:"category"`
+	Content    string         `xml:"content"`
+	Links      []atomLink     `xml:"link"`
+}
+
+// GenerateAtomFeed adds an entry for the release to the atom feed used by
+// `porter mixin install`, creating the feed when it doesn't exist. Entries are
+// identified by their version, so running it again for the same version
+// updates the existing entry. Entries are sorted newest first.
+func GenerateAtomFeed(opts FeedOptions) error {
+	if opts.FeedPath == "" {
+		opts.FeedPath = "atom.xml"
+	}
+	if opts.Updated.IsZero() {
+		opts.Updated = time.Now()
+	}
+	if opts.Name == "" || opts.Version == "" {
+		return fmt.Errorf("the name and version of the release are required to generate the atom feed")
+	}
+	if len(opts.ArtifactURLs) == 0 {
+		return fmt.Errorf("no artifact URLs were specified for %s@%s", opts.Name, opts.Version)
+	}
+
+	feed := atomFeed{
+		ID:     "https://porter.sh",
+		Title:  "Porter Mixins",
+		Author: &atomAuthor{Name: "Porter Authors", URI: "https://porter.sh/mixins"},
+	}
+	if data, err := os.ReadFile(opts.FeedPath); err == nil {
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return fmt.Errorf("error parsing the atom feed %s: %w", opts.FeedPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading the atom feed %s: %w", opts.FeedPath, err)
+	}
+
+	addFeedEntry(&feed, opts)
+
+	data, err := xml.MarshalIndent(feed, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error serializing the atom feed: %w", err)
+	}
+	data = append([]byte(xml.Header), append(data, '\n')...)
+	if err := os.WriteFile(opts.FeedPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing the atom feed %s: %w", opts.FeedPath, err)
+	}
+	return nil
+}
+
+// addFeedEntry adds or replaces the entry for the release in the feed.
+func addFeedEntry(feed *atomFeed, opts FeedOptions) {
+	// Untagged builds replace the previous build for their permalink, e.g. canary
+	version := opts.Version
+	if opts.Permalink != "" && !releaseVersion.MatchString(version) {
+		version = opts.Permalink
+	}
+
+	// Use the download location of the version as the id, e.g. https://cdn.porter.sh/mixins/helm/v1.2.3
+	id := opts.ArtifactURLs[0]
+	if i := strings.LastIndex(id, "/"); i > 0 {
+		id = id[:i]
+	}
+
+	updated := opts.Updated.UTC().Format(time.RFC3339)
+	entry := atomEntry{
+		ID:         id,
+		Title:      fmt.Sprintf("%s @ %s", opts.Name, version),
+		Updated:    updated,
+		Categories: []atomCategory{{Term: opts.Name}},
+		Content:    version,
+	}
+	for _, url := range opts.ArtifactURLs {
+		entry.Links = append(entry.Links, atomLink{Rel: "download", Href: url})
+	}
+
+	entries := []atomEntry{entry}
+	for _, existing := range feed.Entries {
+		if existing.ID != entry.ID {
+			entries = append(entries, existing)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		iUpdated, _ := time.Parse(time.RFC3339, entries[i].Updated)
+		jUpdated, _ := time.Parse(time.RFC3339, entries[j].Updated)
+		return iUpdated.After(jUpdated)
+	})
+	feed.Entries = entries
+	feed.Updated = entries[0].Updated
+
+	// Keep a category for each package in the feed
+	for _, category := range feed.Categories {
+		if category.Term == opts.Name {
+			return
+		}
+	}
+	feed.Categories = append(feed.Categories, atomCategory{Term: opts.Name})
+	sort.Slice(feed.Categories, func(i, j int) bool {
+		return feed.Categories[i].Term < feed.Categories[j].Term
+	})
+}
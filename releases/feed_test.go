@@ -0,0 +1,86 @@
+package releases
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAtomFeed(t *testing.T) {
+	feedPath := filepath.Join(t.TempDir(), "atom.xml")
+	published := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	readFeed := func(t *testing.T) atomFeed {
+		data, err := os.ReadFile(feedPath)
+		require.NoError(t, err)
+		var feed atomFeed
+		require.NoError(t, xml.Unmarshal(data, &feed))
+		return feed
+	}
+	release := func(name string, version string, permalink string, updated time.Time) FeedOptions {
+		return FeedOptions{
+			FeedPath:  feedPath,
+			Name:      name,
+			Version:   version,
+			Permalink: permalink,
+			ArtifactURLs: []string{
+				"https://cdn.porter.sh/mixins/" + name + "/" + permalink + "/" + name + "-linux-amd64",
+				"https://cdn.porter.sh/mixins/" + name + "/" + permalink + "/" + name + "-windows-amd64.exe",
+			},
+			Updated: updated,
+		}
+	}
+
+	t.Run("new feed", func(t *testing.T) {
+		opts := release("helm", "v1.2.3", "v1.2.3", published)
+		require.NoError(t, GenerateAtomFeed(opts))
+
+		feed := readFeed(t)
+		assert.Equal(t, "https://porter.sh", feed.ID)
+		assert.Equal(t, "2022-03-01T10:00:00Z", feed.Updated)
+		assert.Equal(t, []atomCategory{{Term: "helm"}}, feed.Categories)
+		require.Len(t, feed.Entries, 1)
+		assert.Equal(t, atomEntry{
+			ID:         "https://cdn.porter.sh/mixins/helm/v1.2.3",
+			Title:      "helm @ v1.2.3",
+			Updated:    "2022-03-01T10:00:00Z",
+			Categories: []atomCategory{{Term: "helm"}},
+			Content:    "v1.2.3",
+			Links: []atomLink{
+				{Rel: "download", Href: "https://cdn.porter.sh/mixins/helm/v1.2.3/helm-linux-amd64"},
+				{Rel: "download", Href: "https://cdn.porter.sh/mixins/helm/v1.2.3/helm-windows-amd64.exe"},
+			},
+		}, feed.Entries[0])
+	})
+
+	t.Run("add entries newest first", func(t *testing.T) {
+		require.NoError(t, GenerateAtomFeed(release("helm", "v1.2.4-2-g8252b6e", "canary", published.Add(2*time.Hour))))
+		require.NoError(t, GenerateAtomFeed(release("exec", "v0.1.0", "v0.1.0", published.Add(time.Hour))))
+
+		feed := readFeed(t)
+		require.Len(t, feed.Entries, 3)
+		assert.Equal(t, "helm @ canary", feed.Entries[0].Title)
+		assert.Equal(t, "exec @ v0.1.0", feed.Entries[1].Title)
+		assert.Equal(t, "helm @ v1.2.3", feed.Entries[2].Title, "existing entries should be preserved")
+		assert.Equal(t, []atomCategory{{Term: "exec"}, {Term: "helm"}}, feed.Categories)
+	})
+
+	t.Run("rerun is idempotent", func(t *testing.T) {
+		require.NoError(t, GenerateAtomFeed(release("helm", "v1.2.3", "v1.2.3", published.Add(3*time.Hour))))
+
+		feed := readFeed(t)
+		require.Len(t, feed.Entries, 3, "the existing entry should be updated")
+		assert.Equal(t, "helm @ v1.2.3", feed.Entries[0].Title)
+		assert.Equal(t, "2022-03-01T13:00:00Z", feed.Updated)
+	})
+
+	t.Run("missing artifacts", func(t *testing.T) {
+		err := GenerateAtomFeed(FeedOptions{FeedPath: feedPath, Name: "helm", Version: "v1.2.3"})
+		require.ErrorContains(t, err, "no artifact URLs")
+	})
+}
@@ -1,107 +1,749 @@
 package releases
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/carolynvs/magex/ci"
 	"github.com/carolynvs/magex/mgx"
 	"github.com/carolynvs/magex/shx"
 )
 
+const (
+	// VersionOverride is the environment variable that overrides the version
+	// detected by git, e.g. when building from a source tarball.
+	VersionOverride = "PORTER_VERSION"
+
+	// CommitOverride is the environment variable that overrides the commit
+	// detected by git, e.g. when building from a source tarball.
+	CommitOverride = "PORTER_COMMIT"
+
+	// SourceDateEpoch is the environment variable for reproducible builds that
+	// overrides the build date with a unix timestamp, see https://reproducible-builds.org/specs/source-date-epoch/.
+	SourceDateEpoch = "SOURCE_DATE_EPOCH"
+)
+
+// TagPrefix scopes version detection to tags with the prefix, e.g. mixin-foo/ for
+// tags such as mixin-foo/v1.2.0 in a monorepo. The prefix is removed from the version.
+// Defaults to empty, which uses every tag.
+var TagPrefix string
+
+// TagPattern is the glob that version tags match, after the TagPrefix, and is
+// used by every git describe call, e.g. [0-9]* for projects that tag 1.2.3
+// without a v prefix. Defaults to v*.
+var TagPattern = "v*"
+
+// TagHasVPrefix determines if versions start with a v, e.g. v1.2.3, and is used
+// when forming versions such as the major and minor docker tags, e.g. v1 and v1.2.
+// Set it to false along with TagPattern for projects that tag 1.2.3. Defaults to true.
+var TagHasVPrefix = true
+
+// versionPrefix returns the prefix of versions, v when TagHasVPrefix is set.
+func versionPrefix() string {
+	if TagHasVPrefix {
+		return "v"
+	}
+	return ""
+}
+
+// RemoteName is the name of the git remote used to resolve remote branches and
+// push tags, e.g. upstream. Defaults to origin.
+var RemoteName = "origin"
+
+// AnnotatedTagsOnly restricts version detection to annotated tags, ignoring
+// lightweight tags such as those pushed by accident. Defaults to false, which
+// uses both annotated and lightweight tags.
+var AnnotatedTagsOnly bool
+
+// GitRetries is the environment variable that sets how many times a git
+// command is attempted when it fails with a transient error. Defaults to 3.
+const GitRetries = "PORTER_GIT_RETRIES"
+
 var (
 	gitMetadata  GitMetadata
 	loadMetadata sync.Once
+
+	// gitRetryBackoff is how long to wait before the first retry of a git command,
+	// subsequent retries wait proportionally longer.
+	gitRetryBackoff = 500 * time.Millisecond
+
+	// releaseVersion matches the version of a tagged release, e.g. v1.2.3, 1.2.3 or v1.2.3-rc.1
+	releaseVersion = regexp.MustCompile(`^v?\d+\.\d+\.\d+(-[0-9A-Za-z.]+)?$`)
+
+	// transientGitErrors are messages from git that indicate the command may succeed when retried.
+	transientGitErrors = []string{
+		".lock",
+		"unable to read tree",
+		"another git process",
+		"cannot lock ref",
+	}
+
+	// Permalinks configures the permalink aliases used when publishing.
+	// Change it before calling LoadMetadata to publish additional channels.
+	Permalinks = PermalinkConfig{
+		TaggedAlias:        "latest",
+		UntaggedAlias:      "canary",
+		PrereleaseAlias:    "preview",
+		NightlyAlias:       "nightly",
+		PublishableAliases: []string{"canary", "latest", "preview"},
+	}
 )
 
+// PermalinkConfig defines the permalink aliases assigned to builds.
+type PermalinkConfig struct {
+	// TaggedAlias is the permalink prefix for tagged releases, e.g. latest
+	TaggedAlias string
+
+	// UntaggedAlias is the permalink prefix for builds of untagged commits, e.g. canary
+	UntaggedAlias string
+
+	// PrereleaseAlias is the permalink prefix for tagged prereleases, e.g. preview for v1.2.0-rc.1
+	PrereleaseAlias string
+
+	// NightlyAlias is the permalink for nightly builds, e.g. nightly, see NightlyBuild.
+	// Nightly builds are always published, even when it isn't a PublishableAlias.
+	NightlyAlias string
+
+	// PublishableAliases are the permalinks that are published, e.g. canary and latest
+	PublishableAliases []string
+
+	// PublishVersioned enables publishing permalinks for release branches,
+	// e.g. canary-v1 and latest-v1. It may also be enabled with the
+	// PUBLISH_VERSIONED_PERMALINKS environment variable.
+	PublishVersioned bool
+
+	// PublishMinor enables publishing a permalink for the minor version of
+	// stable tagged releases, e.g. latest-v1.2 for v1.2.3, that always points
+	// at the latest patch of the minor version, see GitMetadata.MinorPermalink.
+	// It may also be enabled with the PUBLISH_MINOR_PERMALINKS environment variable.
+	PublishMinor bool
+}
+
+// PublishVersionedPermalinks is the environment variable that enables
+// publishing permalinks for release branches, e.g. latest-v1.
+const PublishVersionedPermalinks = "PUBLISH_VERSIONED_PERMALINKS"
+
+// PublishMinorPermalinks is the environment variable that enables publishing
+// permalinks for the minor version of tagged releases, e.g. latest-v1.2.
+const PublishMinorPermalinks = "PUBLISH_MINOR_PERMALINKS"
+
+// minorPermalinkSuffix matches the suffix of a permalink for a minor version, e.g. the -v1.2 in latest-v1.2.
+var minorPermalinkSuffix = regexp.MustCompile(`-v?\d+\.\d+$`)
+
+// majorPermalinkSuffix matches the suffix of a permalink for a major version
+// without the v prefix, e.g. the -1 in latest-1, see TagHasVPrefix.
+var majorPermalinkSuffix = regexp.MustCompile(`-\d+$`)
+
+// PublishPullRequestArtifacts is the environment variable that enables publishing
+// the artifacts of a pull request build to a pr-NUMBER permalink, e.g. pr-123, so
+// that reviewers can download them. They are only published to a bucket with
+// PublishToBucket, and are not cleaned up automatically, so configure the bucket
+// to expire them, e.g. with a lifecycle rule on the pr-* prefix.
+const PublishPullRequestArtifacts = "PUBLISH_PR_ARTIFACTS"
+
+// NightlyBuild is the environment variable that enables nightly mode, which
+// publishes the build to the nightly permalink regardless of the branch or tag,
+// with the date in its version, e.g. v1.2.3-4-g8252b6e+nightly.20240102.
+// Builds triggered by a schedule, e.g. a GitHub Actions schedule event, are
+// nightly builds unless it's set to false.
+const NightlyBuild = "PORTER_NIGHTLY"
+
+// pullRequestPermalinkPrefix is the prefix of the permalinks for pull request builds, e.g. pr-123.
+const pullRequestPermalinkPrefix = "pr-"
+
 type GitMetadata struct {
 	// Permalink is the version alias, e.g. latest, or canary
-	Permalink string
+	Permalink string `json:"permalink"`
 
 	// Version is the tag or tag+commit hash
-	Version string
+	Version string `json:"version"`
 
 	// Commit is the hash of the current commit
-	Commit string
+	Commit string `json:"commit"`
+
+	// Branch is the branch that was built: main, the release branch, e.g. v1, or dev.
+	// It is empty when the version is overridden, since the branch isn't known.
+	Branch string `json:"branch"`
 
 	// IsTaggedRelease indicates if the build is for a versioned tag
-	IsTaggedRelease bool
+	IsTaggedRelease bool `json:"isTaggedRelease"`
+
+	// IsPrerelease indicates if the tagged release is a prerelease, e.g. v1.2.0-rc.1
+	IsPrerelease bool `json:"isPrerelease"`
+
+	// IsDirty indicates if the working copy has uncommitted changes
+	IsDirty bool `json:"isDirty"`
+
+	// BuildDate is when the commit was made, in RFC3339 format, so that rebuilding
+	// the same commit produces the same binary. SOURCE_DATE_EPOCH takes precedence when it's set.
+	BuildDate string `json:"buildDate"`
+
+	// IsPullRequest indicates if the build is for a pull request, or a GitLab merge request
+	IsPullRequest bool `json:"isPullRequest"`
+
+	// BaseBranch is the branch that the pull request targets, e.g. main.
+	// It is empty when the build isn't for a pull request, or the build provider doesn't expose it.
+	BaseBranch string `json:"baseBranch"`
+}
+
+// MarshalJSON dumps the metadata using stable lowercase keys, along with when
+// the dump was generated and the CI build provider that was detected.
+func (m GitMetadata) MarshalJSON() ([]byte, error) {
+	// Use an alias so that we don't recursively call MarshalJSON
+	type metadata GitMetadata
+	return json.Marshal(struct {
+		metadata
+		Generated time.Time `json:"generated"`
+		Provider  string    `json:"provider"`
+	}{
+		metadata:  metadata(m),
+		Generated: time.Now().UTC(),
+		Provider:  getBuildProviderName(),
+	})
 }
 
 func (m GitMetadata) ShouldPublishPermalink() bool {
-	// For now don't publish canary-v1 or latest-v1 to keep things simpler
-	return m.Permalink == "canary" || m.Permalink == "latest"
+	// Only publish canary-v1 or latest-v1 when requested to keep things simpler
+	publishVersioned := Permalinks.PublishVersioned
+	if v, err := strconv.ParseBool(os.Getenv(PublishVersionedPermalinks)); err == nil {
+		publishVersioned = v
+	}
+
+	// Never publish uncommitted changes
+	if m.IsDirty {
+		return false
+	}
+
+	if isPullRequestPermalink(m.Permalink) {
+		publishPR, _ := strconv.ParseBool(os.Getenv(PublishPullRequestArtifacts))
+		return publishPR
+	}
+
+	// Pull requests may only publish to their own permalink, e.g. pr-123, and never to a shared one such as canary
+	if m.IsPullRequest {
+		return false
+	}
+
+	if Permalinks.NightlyAlias != "" && m.Permalink == Permalinks.NightlyAlias {
+		return true
+	}
+
+	for _, alias := range Permalinks.PublishableAliases {
+		if m.Permalink == alias {
+			return true
+		}
+		if !strings.HasPrefix(m.Permalink, alias+"-") {
+			continue
+		}
+		// Minor permalinks, e.g. latest-v1.2, are enabled separately from the major permalinks, e.g. latest-v1
+		if minorPermalinkSuffix.MatchString(m.Permalink) {
+			if shouldPublishMinorPermalinks() {
+				return true
+			}
+			continue
+		}
+		if publishVersioned && (strings.HasPrefix(m.Permalink, alias+"-v") || majorPermalinkSuffix.MatchString(m.Permalink)) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldPublishMinorPermalinks determines if permalinks for minor versions, e.g. latest-v1.2, are published.
+func shouldPublishMinorPermalinks() bool {
+	if v, err := strconv.ParseBool(os.Getenv(PublishMinorPermalinks)); err == nil {
+		return v
+	}
+	return Permalinks.PublishMinor
+}
+
+// MinorPermalink returns the permalink for the minor version of a stable
+// tagged release, e.g. latest-v1.2 for v1.2.3, which always points at the
+// latest patch of the minor version. It's published along with the release's
+// permalink when PermalinkConfig.PublishMinor is enabled. An empty string is
+// returned when it isn't enabled, for builds that aren't a stable tagged
+// release, and for releases tagged on the release branch of another major version.
+func (m GitMetadata) MinorPermalink() string {
+	if !shouldPublishMinorPermalinks() || !m.IsTaggedRelease || m.IsPrerelease {
+		return ""
+	}
+	if err := validateRelease(m, m.Branch); err != nil {
+		return ""
+	}
+
+	minor := m.MajorMinorTag()
+	if minor == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s", Permalinks.TaggedAlias, minor)
 }
 
-// LoadMetadata populates the status of the current working copy: current version, tag and permalink
+func isPullRequestPermalink(permalink string) bool {
+	return strings.HasPrefix(permalink, pullRequestPermalinkPrefix)
+}
+
+// LoadMetadata populates the status of the current working copy: current version, tag and permalink.
+// On CI, the permalink and version are exported to the later steps of the pipeline
+// as the PERMALINK and VERSION environment variables.
 func LoadMetadata() GitMetadata {
+	m := LoadMetadataReadOnly()
+	exportMetadata(m)
+	return m
+}
+
+// LoadMetadataReadOnly populates the status of the current working copy, like
+// LoadMetadata, without exporting any environment variables to the CI pipeline,
+// for callers that only read the metadata.
+func LoadMetadataReadOnly() GitMetadata {
 	loadMetadata.Do(func() {
-		gitMetadata = GitMetadata{
-			Version: getVersion(),
-			Commit:  getCommit(),
+		gitMetadata = getCachedMetadata()
+
+		if IsVerbose() {
+			getLogger().Info("Loaded git metadata",
+				"taggedRelease", gitMetadata.IsTaggedRelease,
+				"prerelease", gitMetadata.IsPrerelease,
+				"permalink", gitMetadata.Permalink,
+				"version", gitMetadata.Version,
+				"commit", gitMetadata.Commit,
+				"branch", gitMetadata.Branch,
+				"dirty", gitMetadata.IsDirty)
 		}
+	})
 
-		gitMetadata.Permalink, gitMetadata.IsTaggedRelease = getPermalink()
+	return gitMetadata
+}
 
-		log.Println("Tagged Release:", gitMetadata.IsTaggedRelease)
-		log.Println("Permalink:", gitMetadata.Permalink)
-		log.Println("Version:", gitMetadata.Version)
-		log.Println("Commit:", gitMetadata.Commit)
-	})
+// Save the metadata as environment variables to use later in the CI pipeline.
+// There is nowhere to save them when running locally, and failing to save them
+// only affects later steps in the pipeline, so it isn't fatal.
+func exportMetadata(m GitMetadata) {
+	p, detected := ci.DetectBuildProvider()
+	if !detected {
+		return
+	}
 
-	// Save the metadata as environment variables to use later in the CI pipeline
-	p, _ := ci.DetectBuildProvider()
-	mgx.Must(p.SetEnv("PERMALINK", gitMetadata.Permalink))
-	mgx.Must(p.SetEnv("VERSION", gitMetadata.Version))
+	setEnv := func(name string, value string) {
+		if err := p.SetEnv(name, value); err != nil {
+			log.Printf("WARNING: could not export %s to the CI pipeline: %s\n", name, err)
+		}
+	}
+	setEnv(PermalinkEnv, m.Permalink)
+	setEnv(VersionEnv, m.Version)
+	setEnv(CommitEnv, m.Commit)
+}
 
-	return gitMetadata
+const (
+	// PermalinkEnv is the environment variable that LoadMetadata exports the permalink to, e.g. canary.
+	PermalinkEnv = "PERMALINK"
+
+	// VersionEnv is the environment variable that LoadMetadata exports the version to, e.g. v1.2.3.
+	VersionEnv = "VERSION"
+
+	// CommitEnv is the environment variable that LoadMetadata exports the commit to, e.g. 8252b6e.
+	CommitEnv = "COMMIT"
+)
+
+// MetadataFromEnv reconstructs the metadata from the environment variables
+// exported to the CI pipeline by LoadMetadata, PERMALINK, VERSION and COMMIT,
+// so that a later step of the pipeline, such as a separate publish job, doesn't
+// need git. The branch, dirty status and build date aren't exported, so they
+// aren't set. The flag is false when PERMALINK or VERSION isn't set.
+func MetadataFromEnv() (GitMetadata, bool) {
+	permalink := os.Getenv(PermalinkEnv)
+	version := os.Getenv(VersionEnv)
+	if permalink == "" || version == "" {
+		return GitMetadata{}, false
+	}
+
+	m := GitMetadata{
+		Permalink:       permalink,
+		Version:         version,
+		Commit:          os.Getenv(CommitEnv),
+		IsTaggedRelease: releaseVersion.MatchString(version),
+	}
+	m.IsPrerelease = m.IsTaggedRelease && isPrerelease(version)
+	m.IsPullRequest, m.BaseBranch = getPullRequest()
+	return m, true
+}
+
+// DumpMetadata prints the metadata for the current working copy as JSON to stdout.
+func DumpMetadata() {
+	info := LoadMetadata()
+	data, err := json.Marshal(info)
+	mgx.Must(err)
+	fmt.Println(string(data))
+}
+
+// Determine the metadata for the current working copy, using the version
+// and commit overrides from the environment when they are set.
+func getMetadata() GitMetadata {
+	if version := strings.TrimPrefix(os.Getenv(VersionOverride), TagPrefix); version != "" {
+		// The source may not be a git repository, e.g. an exported tarball, so avoid git
+		m := GitMetadata{
+			Version:         version,
+			Commit:          os.Getenv(CommitOverride),
+			IsTaggedRelease: releaseVersion.MatchString(version),
+		}
+		m.IsPrerelease = m.IsTaggedRelease && isPrerelease(version)
+		m.IsPullRequest, m.BaseBranch = getPullRequest()
+		if m.Commit == "" {
+			m.Commit, _ = retryGit("rev-parse", "--short", "HEAD")
+		}
+		m.BuildDate, _ = getBuildDate()
+
+		// The branch isn't known, so only use the tagged permalink, e.g. latest, for release versions
+		m.Permalink = "dev"
+		if m.IsPrerelease {
+			m.Permalink = Permalinks.PrereleaseAlias
+		} else if m.IsTaggedRelease {
+			m.Permalink = Permalinks.TaggedAlias
+		}
+		return applyNightlyMode(m, time.Now())
+	}
+
+	// Describe the commit once, and derive the version, commit and tagged status from it
+	description, err := describeHead()
+	mgx.Must(err)
+
+	buildDate, err := getBuildDate()
+	mgx.Must(err)
+
+	m := GitMetadata{
+		Version:   description.Version(),
+		Commit:    description.Hash,
+		Branch:    GetBranchName(),
+		BuildDate: buildDate,
+	}
+	if commit := os.Getenv(CommitOverride); commit != "" {
+		m.Commit = commit
+	} else if m.Commit == "" {
+		m.Commit = getCommit()
+	}
+
+	m.Permalink, m.IsTaggedRelease = getPermalink(m.Branch, m.Version, description.IsTagged())
+	m.Permalink = getLatestPermalink(m.Permalink, m.Version)
+	m.IsPrerelease = m.IsTaggedRelease && isPrerelease(m.Version)
+	m.IsPullRequest, m.BaseBranch = getPullRequest()
+	m = applyNightlyMode(m, time.Now())
+
+	// git describe --dirty doesn't report untracked files, so check the status when it's clean
+	if description.IsDirty {
+		return markDirty(m)
+	}
+	return applyDirtyStatus(m, getStatus())
+}
+
+// getLatestPermalink only keeps the latest permalink for the highest stable
+// release, so that a hotfix of an older release tagged on main, e.g. v1.5.1
+// after v2.0.0, uses the permalink of its major version instead, e.g. latest-v1.
+func getLatestPermalink(permalink string, version string) string {
+	if permalink != Permalinks.TaggedAlias {
+		return permalink
+	}
+
+	tags, err := ListVersionTags(versionTagPattern())
+	if err != nil {
+		return permalink
+	}
+	return pickLatestPermalink(version, tags)
+}
+
+func pickLatestPermalink(version string, tags []semver.Version) string {
+	current, err := semver.NewVersion(version)
+	if err != nil {
+		return Permalinks.TaggedAlias
+	}
+
+	if versions := sortStableVersions(tags); len(versions) > 0 && versions[0].GreaterThan(current) {
+		return fmt.Sprintf("%s-%s%d", Permalinks.TaggedAlias, versionPrefix(), current.Major())
+	}
+	return Permalinks.TaggedAlias
+}
+
+// getPullRequest determines if the build is for a pull request, and the branch that it targets when it's known.
+func getPullRequest() (bool, string) {
+	env := detectBuildEnvironment()
+	if _, pr := env.PullRequestBranch(); !pr {
+		return false, ""
+	}
+	baseBranch, _ := env.PullRequestBaseBranch()
+	return true, baseBranch
+}
+
+// Get the name of the CI build provider, or local when the build isn't running on CI
+func getBuildProviderName() string {
+	return detectBuildEnvironment().Name()
 }
 
 // Get the hash of the current commit
 func getCommit() string {
-	commit, _ := must.OutputS("git", "rev-parse", "--short", "HEAD")
+	commit, err := retryGit("rev-parse", "--short", "HEAD")
+	mgx.Must(err)
 	return commit
 }
 
+// Get the build date from SOURCE_DATE_EPOCH, falling back to the date of the current commit
+func getBuildDate() (string, error) {
+	if epoch := os.Getenv(SourceDateEpoch); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s value %q, it must be a unix timestamp", SourceDateEpoch, epoch)
+		}
+		return time.Unix(seconds, 0).UTC().Format(time.RFC3339), nil
+	}
+
+	commitDate, err := retryGit("show", "-s", "--format=%cI", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("could not determine the date of the current commit: %w", err)
+	}
+	date, err := time.Parse(time.RFC3339, commitDate)
+	if err != nil {
+		return "", fmt.Errorf("could not parse the date of the current commit %q: %w", commitDate, err)
+	}
+	return date.UTC().Format(time.RFC3339), nil
+}
+
+// Get the status of the working copy in a machine-readable format, one line per changed file
+func getStatus() string {
+	status, err := retryGit("status", "--porcelain")
+	mgx.Must(err)
+	return status
+}
+
+// isNightlyBuild determines if nightly mode is enabled with NightlyBuild, or
+// the build was triggered by a schedule when it isn't set.
+func isNightlyBuild() bool {
+	if nightly, err := strconv.ParseBool(os.Getenv(NightlyBuild)); err == nil {
+		return nightly
+	}
+	return detectBuildEnvironment().IsScheduled()
+}
+
+// nightlyDateFormat is the format of the date in the version of nightly builds, e.g. 20240102.
+const nightlyDateFormat = "20060102"
+
+// applyNightlyMode uses the nightly permalink for nightly builds, instead of
+// the permalink for the branch or tag, and adds the date to the version so that
+// the artifacts of each night are named differently. Pull requests are never nightly builds.
+func applyNightlyMode(m GitMetadata, now time.Time) GitMetadata {
+	if Permalinks.NightlyAlias == "" || m.IsPullRequest || !isNightlyBuild() {
+		return m
+	}
+
+	m.Permalink = Permalinks.NightlyAlias
+	m.Version = fmt.Sprintf("%s+nightly.%s", m.Version, now.UTC().Format(nightlyDateFormat))
+	m.IsTaggedRelease = false
+	m.IsPrerelease = false
+	return m
+}
+
+// Flag the metadata as dirty when the working copy has uncommitted changes.
+// A dirty build is labeled with a +dirty version suffix and always uses the dev
+// permalink so that it is never published as a canary or tagged release.
+func applyDirtyStatus(m GitMetadata, porcelainStatus string) GitMetadata {
+	if strings.TrimSpace(porcelainStatus) == "" {
+		return m
+	}
+	return markDirty(m)
+}
+
+// markDirty flags the metadata as dirty, see applyDirtyStatus.
+func markDirty(m GitMetadata) GitMetadata {
+	m.IsDirty = true
+	// Join the build metadata of nightly builds, e.g. +nightly.20240102.dirty, so the version is still valid semver
+	if strings.Contains(m.Version, "+") {
+		m.Version += ".dirty"
+	} else {
+		m.Version += "+dirty"
+	}
+	m.Permalink = "dev"
+	m.IsTaggedRelease = false
+	m.IsPrerelease = false
+	return m
+}
+
 // Get a description of the commit, e.g. v0.30.1 (latest) or v0.30.1-32-gfe72ff73 (canary)
-func getVersion() string {
-	version, _ := shx.OutputS("git", "describe", "--tags")
-	if version != "" {
-		return version
+func getVersion() (string, error) {
+	description, err := describeHead()
+	if err != nil {
+		return "", err
+	}
+	return description.Version(), nil
+}
+
+// gitDescription is the parsed output of git describe --long --dirty, e.g. v0.30.1-32-gfe72ff73-dirty.
+type gitDescription struct {
+	// Tag that the commit is described from, e.g. v0.30.1, including the TagPrefix.
+	// It's empty when the repository doesn't have any version tags.
+	Tag string
+
+	// CommitsSinceTag is the number of commits since the tag, or every commit when there isn't a tag.
+	CommitsSinceTag int
+
+	// Hash is the abbreviated hash of the commit. It's empty when there isn't a tag.
+	Hash string
+
+	// IsDirty indicates that tracked files have uncommitted changes.
+	IsDirty bool
+}
+
+// describeOutput matches the output of git describe --long --dirty. The tag
+// may contain dashes, e.g. v1.2.3-rc.1, so the suffix is matched from the end.
+var describeOutput = regexp.MustCompile(`^(.+)-(\d+)-g([0-9a-f]+)(-dirty)?$`)
+
+// IsTagged returns if the commit is the tagged commit.
+func (d gitDescription) IsTagged() bool {
+	return d.Tag != "" && d.CommitsSinceTag == 0
+}
+
+// Version returns the version of the commit without the TagPrefix, e.g. v0.30.1
+// for the tagged commit, v0.30.1-32-gfe72ff73 for later commits, or v0.0.0 when there isn't a tag.
+func (d gitDescription) Version() string {
+	if d.Tag == "" {
+		return versionPrefix() + "0.0.0"
+	}
+	tag := strings.TrimPrefix(d.Tag, TagPrefix)
+	if d.CommitsSinceTag == 0 {
+		return tag
+	}
+	return fmt.Sprintf("%s-%d-g%s", tag, d.CommitsSinceTag, d.Hash)
+}
+
+// describeHead describes the current commit with a single git describe call,
+// which every field of the description is parsed from.
+func describeHead() (gitDescription, error) {
+	output, err := retryGit(describeTagsArgs("--long", "--dirty")...)
+	if err == nil {
+		return parseDescription(output)
+	}
+
+	// Only fall back to v0.0.0 when describe failed because the repository doesn't have any tags,
+	// and not when git is missing or the current directory isn't a repository
+	count, revErr := retryGit("rev-list", "--count", "HEAD")
+	if revErr != nil {
+		return gitDescription{}, fmt.Errorf("could not determine the version of the current commit: %w", err)
 	}
 
 	// repo without any tags in it
-	return "v0.0.0"
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return gitDescription{}, fmt.Errorf("could not parse the number of commits, %q: %w", count, err)
+	}
+	return gitDescription{CommitsSinceTag: n}, nil
 }
 
-// Return either "main", "v*", or "dev" for all other branches.
-func getBranchName() string {
-	gitOutput, _ := must.OutputS("git", "for-each-ref", "--contains", "HEAD", "--format=%(refname)")
+// parseDescription parses the output of git describe --long --dirty.
+func parseDescription(output string) (gitDescription, error) {
+	match := describeOutput.FindStringSubmatch(strings.TrimSpace(output))
+	if match == nil {
+		return gitDescription{}, fmt.Errorf("could not parse the output of git describe, %q", output)
+	}
+	n, err := strconv.Atoi(match[2])
+	if err != nil {
+		return gitDescription{}, fmt.Errorf("could not parse the number of commits in the output of git describe, %q: %w", output, err)
+	}
+	return gitDescription{Tag: match[1], CommitsSinceTag: n, Hash: match[3], IsDirty: match[4] != ""}, nil
+}
+
+// describeTagsArgs returns the arguments for git describe, along with the
+// specified arguments, that only match version tags, see TagPrefix and TagPattern.
+func describeTagsArgs(args ...string) []string {
+	describeArgs := append(describeCommand(), "--match="+versionTagPattern())
+	return append(describeArgs, args...)
+}
+
+// versionTagPattern returns the glob that matches the version tags, including the TagPrefix.
+func versionTagPattern() string {
+	return TagPrefix + TagPattern
+}
+
+// describeCommand returns the git describe arguments that select which kinds of tags are used.
+// Without --tags, git describe only uses annotated tags.
+func describeCommand() []string {
+	if AnnotatedTagsOnly {
+		return []string{"describe"}
+	}
+	return []string{"describe", "--tags"}
+}
+
+// GetBranchName returns the name of the branch being built, or the branch that
+// the current tag was created from: either "main", "v*" for release branches,
+// or "dev" for all other branches.
+func GetBranchName() string {
+	gitOutput, err := retryGit("for-each-ref", "--contains", "HEAD", "--format=%(refname)")
+	mgx.Must(err)
 	refs := strings.Split(gitOutput, "\n")
 
+	// CI may check out a specific commit (detached HEAD) without creating any branch refs,
+	// so look for the remote branches that contain the commit instead
+	if !containsBuildBranch(refs) {
+		refs = append(refs, getRemoteBranchRefs()...)
+	}
+
 	return pickBranchName(refs)
 }
 
+// Get the refs of the remote tracking branches that contain the current commit, e.g. refs/remotes/origin/main
+func getRemoteBranchRefs() []string {
+	gitOutput, err := retryGit("branch", "-r", "--contains", "HEAD")
+	if err != nil {
+		return nil
+	}
+
+	var refs []string
+	for _, line := range strings.Split(gitOutput, "\n") {
+		branch := strings.TrimSpace(line)
+		// Skip empty lines and symbolic refs, e.g. origin/HEAD -> origin/main
+		if branch == "" || strings.Contains(branch, " -> ") {
+			continue
+		}
+		refs = append(refs, "refs/remotes/"+branch)
+	}
+	return refs
+}
+
+// Determine if any of the refs is for a branch that we build: main or release/v*
+func containsBuildBranch(refs []string) bool {
+	for _, ref := range refs {
+		if isBuildBranch(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBuildBranch(ref string) bool {
+	return strings.HasSuffix(ref, "/main") || strings.Contains(ref, "/release/v")
+}
+
 // Return either "main", "v*", or "dev" for all other branches.
 func pickBranchName(refs []string) string {
 	var branch string
 
-	if b, ok := os.LookupEnv("SYSTEM_PULLREQUEST_SOURCEBRANCH"); ok {
+	env := detectBuildEnvironment()
+	if b, ok := env.PullRequestBranch(); ok {
 		// pull request
 		branch = b
-	} else if b, ok := os.LookupEnv("BUILD_SOURCEBRANCH"); ok && !strings.HasPrefix(b, "refs/tags/") {
+	} else if b, ok := env.BranchName(); ok {
 		// branch build
-		// BUILD_SOURCEBRANCHNAME has the short name, e.g. main. BUILD_SOURCEBRANCH has the full name, e.g. refs/heads/main
-		// They are populated for both tags and branches
-		branch = os.Getenv("BUILD_SOURCEBRANCHNAME")
+		branch = b
 	} else {
 		// tag build
 		// Detect if this was a tag on main or a release
-		sort.Strings(refs) // put main ahead of release/v*
+		sortRefs(refs) // put main ahead of release/v*
 		for _, ref := range refs {
 			// Ignore tags
 			if strings.HasSuffix(ref, "refs/tags") {
@@ -109,7 +751,7 @@ func pickBranchName(refs []string) string {
 			}
 
 			// Only match main and release/v* branches
-			if strings.HasSuffix(ref, "/main") || strings.Contains(ref, "/release/v") {
+			if isBuildBranch(ref) {
 				branch = ref
 				break
 			}
@@ -117,7 +759,7 @@ func pickBranchName(refs []string) string {
 	}
 
 	// Convert the ref name into a branch name, e.g. refs/heads/main -> main
-	branch = strings.NewReplacer("refs/heads/", "", "refs/remotes/origin/", "").Replace(branch)
+	branch = strings.NewReplacer("refs/heads/", "", "refs/remotes/"+RemoteName+"/", "").Replace(branch)
 
 	// Only use the following branch names "main", "release/v*", and "dev" for everything else
 	if branch != "main" && !strings.HasPrefix(branch, "release/v") {
@@ -129,24 +771,65 @@ func pickBranchName(refs []string) string {
 	return branch
 }
 
-func getPermalink() (string, bool) {
-	// Use dev for pull requests
-	if _, pr := os.LookupEnv("SYSTEM_PULLREQUEST_SOURCEBRANCH"); pr {
+// sortRefs sorts the refs so that main comes first, followed by the release
+// branches ordered by their version, e.g. release/v2 before release/v10, and
+// then every other ref.
+func sortRefs(refs []string) {
+	// rank orders main before release branches before everything else
+	rank := func(ref string) int {
+		switch {
+		case strings.HasSuffix(ref, "/main"):
+			return 0
+		case strings.Contains(ref, "/release/v"):
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		if rankA, rankB := rank(a), rank(b); rankA != rankB {
+			return rankA < rankB
+		}
+
+		if rank(a) == 1 {
+			versionA, errA := semver.NewVersion(a[strings.LastIndex(a, "/release/")+len("/release/"):])
+			versionB, errB := semver.NewVersion(b[strings.LastIndex(b, "/release/")+len("/release/"):])
+			if errA == nil && errB == nil && !versionA.Equal(versionB) {
+				return versionA.LessThan(versionB)
+			}
+		}
+		return a < b
+	})
+}
+
+// Get the permalink for the specified branch, returned by GetBranchName,
+// and whether the current commit is a tagged release, i.e. tagged is set.
+// Tagged prereleases use the prerelease permalink so that latest only points to stable releases.
+func getPermalink(branch string, version string, tagged bool) (string, bool) {
+	// Use dev for pull requests, unless their artifacts are published, e.g. pr-123
+	env := detectBuildEnvironment()
+	if _, pr := env.PullRequestBranch(); pr {
+		if publishPR, _ := strconv.ParseBool(os.Getenv(PublishPullRequestArtifacts)); publishPR {
+			if n, ok := env.PullRequestNumber(); ok {
+				return pullRequestPermalinkPrefix + n, false
+			}
+		}
 		return "dev", false
 	}
 
 	// Use latest for tagged commits
 	taggedRelease := false
-	permalinkPrefix := "canary"
-	err := shx.RunS("git", "describe", "--tags", "--match=v*", "--exact")
-	if err == nil {
-		permalinkPrefix = "latest"
+	permalinkPrefix := Permalinks.UntaggedAlias
+	if tagged {
+		permalinkPrefix = Permalinks.TaggedAlias
+		if isPrerelease(version) {
+			permalinkPrefix = Permalinks.PrereleaseAlias
+		}
 		taggedRelease = true
 	}
 
-	// Get the current branch name, or the name of the branch we tagged from
-	branch := getBranchName()
-
 	// Build a permalink such as "canary", "latest", "latest-v1", or "dev-canary"
 	switch branch {
 	case "main":
@@ -155,3 +838,64 @@ func getPermalink() (string, bool) {
 		return fmt.Sprintf("%s-%s", permalinkPrefix, strings.TrimPrefix(branch, "release/")), taggedRelease
 	}
 }
+
+// retryGit runs git with the specified arguments and returns its output.
+// Commands that fail with a transient error, such as lock contention when CI
+// is under heavy load, are retried before giving up. Each attempt is killed
+// when it runs longer than the CommandTimeout, and git never prompts for credentials.
+func retryGit(args ...string) (string, error) {
+	attempts, err := getRetryAttempts(GitRetries, 3)
+	if err != nil {
+		return "", err
+	}
+	timeout, err := getCommandTimeout()
+	if err != nil {
+		return "", err
+	}
+
+	for i := 1; ; i++ {
+		var stdout, stderr bytes.Buffer
+		cmd, ctx, cancel := commandWithTimeout(exec.Command("git", args...), timeout)
+		_, _, err := shx.PreparedCommand{Cmd: cmd}.Stdout(&stdout).Stderr(&stderr).Exec()
+		cancel()
+		if err == nil {
+			return strings.TrimSuffix(stdout.String(), "\n"), nil
+		}
+
+		msg := strings.TrimSpace(stderr.String())
+		if err := timeoutError(ctx, cmd, timeout, err); errors.Is(err, context.DeadlineExceeded) {
+			return "", err
+		}
+		if i >= attempts || !isTransientGitError(msg) {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+
+		log.Printf("git %s failed, retrying (%d/%d): %s\n", strings.Join(args, " "), i, attempts-1, msg)
+		time.Sleep(time.Duration(i) * gitRetryBackoff)
+	}
+}
+
+// getRetryAttempts returns how many times a command is attempted, from the
+// environment variable when it's set, otherwise the default.
+func getRetryAttempts(envVar string, defaultAttempts int) (int, error) {
+	value, ok := os.LookupEnv(envVar)
+	if !ok {
+		return defaultAttempts, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s value %q, it must be a positive integer", envVar, value)
+	}
+	return n, nil
+}
+
+func isTransientGitError(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, transientErr := range transientGitErrors {
+		if strings.Contains(msg, transientErr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,290 @@
 package releases
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/carolynvs/magex/shx"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// useMetadata sets the metadata returned by LoadMetadata for the duration of the test.
+func useMetadata(t *testing.T, m GitMetadata) {
+	loadMetadata = sync.Once{}
+	loadMetadata.Do(func() { gitMetadata = m })
+	t.Cleanup(func() {
+		loadMetadata = sync.Once{}
+		gitMetadata = GitMetadata{}
+	})
+}
+
+// useTestRepo creates a git repository with a single commit, and changes into
+// its directory for the duration of the test.
+func useTestRepo(t *testing.T) string {
+	dir := t.TempDir()
+
+	// Isolate the repository from the user's git configuration, e.g. commit signing
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Porter Bot")
+	t.Setenv("GIT_AUTHOR_EMAIL", "bot@porter.sh")
+	t.Setenv("GIT_COMMITTER_NAME", "Porter Bot")
+	t.Setenv("GIT_COMMITTER_EMAIL", "bot@porter.sh")
+
+	origDir, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(origDir) })
+
+	gitCommand(t, "init", "--initial-branch=main")
+	gitCommit(t, "initial commit")
+	return dir
+}
+
+// gitCommand runs git in the current directory, failing the test when it fails.
+func gitCommand(t *testing.T, args ...string) string {
+	output, err := shx.OutputE("git", args...)
+	require.NoError(t, err)
+	return output
+}
+
+// gitCommit creates an empty commit with the specified message.
+func gitCommit(t *testing.T, msg string) {
+	gitCommand(t, "commit", "--allow-empty", "-m", msg)
+}
+
+func TestGitMetadata_MarshalJSON(t *testing.T) {
+	t.Setenv("GITLAB_CI", "")
+	t.Setenv("GITHUB_ACTIONS", "true")
+
+	m := GitMetadata{
+		Permalink:       "canary",
+		Version:         "v0.30.1-32-gfe72ff73",
+		Commit:          "fe72ff73",
+		Branch:          "main",
+		IsTaggedRelease: false,
+	}
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, "canary", got["permalink"])
+	assert.Equal(t, "v0.30.1-32-gfe72ff73", got["version"])
+	assert.Equal(t, "fe72ff73", got["commit"])
+	assert.Equal(t, "main", got["branch"])
+	assert.Equal(t, false, got["isTaggedRelease"])
+	assert.Equal(t, "github", got["provider"])
+
+	generated, err := time.Parse(time.RFC3339, got["generated"].(string))
+	require.NoError(t, err, "generated should be a timestamp")
+	assert.WithinDuration(t, time.Now(), generated, time.Minute)
+}
+
+func TestGitMetadata_ShouldPublishPermalink(t *testing.T) {
+	t.Run("default aliases", func(t *testing.T) {
+		t.Setenv(PublishVersionedPermalinks, "")
+
+		assert.True(t, GitMetadata{Permalink: "canary"}.ShouldPublishPermalink())
+		assert.True(t, GitMetadata{Permalink: "latest"}.ShouldPublishPermalink())
+		assert.False(t, GitMetadata{Permalink: "dev"}.ShouldPublishPermalink())
+		assert.False(t, GitMetadata{Permalink: "latest-v1"}.ShouldPublishPermalink())
+		assert.True(t, GitMetadata{Permalink: "nightly"}.ShouldPublishPermalink(), "nightly builds are always published")
+		assert.False(t, GitMetadata{Permalink: "nightly", IsDirty: true}.ShouldPublishPermalink())
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		t.Setenv(PublishPullRequestArtifacts, "true")
+
+		assert.False(t, GitMetadata{Permalink: "canary", IsDirty: true}.ShouldPublishPermalink(), "uncommitted changes should never be published")
+		assert.False(t, GitMetadata{Permalink: "pr-123", IsPullRequest: true, IsDirty: true}.ShouldPublishPermalink())
+	})
+
+	t.Run("pull request", func(t *testing.T) {
+		t.Setenv(PublishPullRequestArtifacts, "true")
+
+		assert.False(t, GitMetadata{Permalink: "canary", IsPullRequest: true}.ShouldPublishPermalink(), "pull requests should not publish shared permalinks")
+		assert.True(t, GitMetadata{Permalink: "pr-123", IsPullRequest: true}.ShouldPublishPermalink())
+	})
+
+	t.Run("versioned permalinks enabled", func(t *testing.T) {
+		t.Setenv(PublishVersionedPermalinks, "true")
+
+		assert.True(t, GitMetadata{Permalink: "latest-v1"}.ShouldPublishPermalink())
+		assert.True(t, GitMetadata{Permalink: "canary-v1"}.ShouldPublishPermalink())
+		assert.True(t, GitMetadata{Permalink: "latest"}.ShouldPublishPermalink())
+		assert.False(t, GitMetadata{Permalink: "dev"}.ShouldPublishPermalink())
+	})
+
+	t.Run("minor permalinks", func(t *testing.T) {
+		t.Setenv(PublishVersionedPermalinks, "true")
+		t.Setenv(PublishMinorPermalinks, "")
+
+		assert.False(t, GitMetadata{Permalink: "latest-v1.2"}.ShouldPublishPermalink(), "minor permalinks should be enabled separately")
+
+		t.Setenv(PublishVersionedPermalinks, "")
+		t.Setenv(PublishMinorPermalinks, "true")
+		assert.True(t, GitMetadata{Permalink: "latest-v1.2"}.ShouldPublishPermalink())
+		assert.False(t, GitMetadata{Permalink: "latest-v1"}.ShouldPublishPermalink())
+		assert.False(t, GitMetadata{Permalink: "dev-v1.2"}.ShouldPublishPermalink())
+	})
+
+	t.Run("versioned permalinks enabled in config", func(t *testing.T) {
+		orig := Permalinks
+		defer func() { Permalinks = orig }()
+		Permalinks.PublishVersioned = true
+
+		assert.True(t, GitMetadata{Permalink: "latest-v1"}.ShouldPublishPermalink())
+	})
+
+	t.Run("custom aliases", func(t *testing.T) {
+		orig := Permalinks
+		defer func() { Permalinks = orig }()
+		Permalinks = PermalinkConfig{
+			TaggedAlias:        "stable",
+			UntaggedAlias:      "nightly",
+			PublishableAliases: []string{"nightly", "stable"},
+		}
+
+		assert.True(t, GitMetadata{Permalink: "nightly"}.ShouldPublishPermalink())
+		assert.True(t, GitMetadata{Permalink: "stable"}.ShouldPublishPermalink())
+		assert.False(t, GitMetadata{Permalink: "canary"}.ShouldPublishPermalink())
+	})
+}
+
+func TestApplyNightlyMode(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	canary := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e", Commit: "8252b6e", Branch: "main"}
+	tagged := GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e", Branch: "main", IsTaggedRelease: true}
+
+	t.Run("env flag", func(t *testing.T) {
+		unsetBuildEnvironment(t)
+		t.Setenv(NightlyBuild, "true")
+
+		got := applyNightlyMode(canary, now)
+		assert.Equal(t, GitMetadata{Permalink: "nightly", Version: "v1.2.3-4-g8252b6e+nightly.20240102", Commit: "8252b6e", Branch: "main"}, got)
+	})
+
+	t.Run("overrides the tagged permalink", func(t *testing.T) {
+		unsetBuildEnvironment(t)
+		t.Setenv(NightlyBuild, "true")
+
+		got := applyNightlyMode(tagged, now)
+		assert.Equal(t, "nightly", got.Permalink)
+		assert.Equal(t, "v1.2.3+nightly.20240102", got.Version)
+		assert.False(t, got.IsTaggedRelease, "a nightly build of a tagged commit should not be released as the tag")
+	})
+
+	t.Run("scheduled build", func(t *testing.T) {
+		for name, env := range map[string]map[string]string{
+			"github": {"GITHUB_ACTIONS": "true", "GITHUB_EVENT_NAME": "schedule"},
+			"azure":  {"TF_BUILD": "True", "BUILD_REASON": "Schedule"},
+			"gitlab": {"GITLAB_CI": "true", "CI_PIPELINE_SOURCE": "schedule"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				unsetBuildEnvironment(t)
+				for k, v := range env {
+					t.Setenv(k, v)
+				}
+
+				assert.Equal(t, "nightly", applyNightlyMode(canary, now).Permalink)
+			})
+		}
+	})
+
+	t.Run("disabled for a scheduled build", func(t *testing.T) {
+		unsetBuildEnvironment(t)
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_EVENT_NAME", "schedule")
+		t.Setenv(NightlyBuild, "false")
+
+		assert.Equal(t, canary, applyNightlyMode(canary, now))
+	})
+
+	t.Run("not nightly", func(t *testing.T) {
+		unsetBuildEnvironment(t)
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_EVENT_NAME", "push")
+
+		assert.Equal(t, canary, applyNightlyMode(canary, now))
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		unsetBuildEnvironment(t)
+		t.Setenv(NightlyBuild, "true")
+
+		got := applyDirtyStatus(applyNightlyMode(canary, now), " M go.mod")
+		assert.Equal(t, "v1.2.3-4-g8252b6e+nightly.20240102.dirty", got.Version)
+		assert.Equal(t, "dev", got.Permalink, "dirty builds should never be published as a nightly")
+		v, err := got.Semver()
+		require.NoError(t, err, "the version of a dirty nightly build should be valid semver")
+		assert.Equal(t, "nightly.20240102.dirty", v.Metadata())
+		assert.Equal(t, "v1", got.MajorTag())
+	})
+
+	t.Run("pull request", func(t *testing.T) {
+		unsetBuildEnvironment(t)
+		t.Setenv(NightlyBuild, "true")
+		pr := GitMetadata{Permalink: "dev", Version: "v1.2.3-4-g8252b6e", IsPullRequest: true}
+
+		assert.Equal(t, pr, applyNightlyMode(pr, now))
+	})
+}
+
+func TestGetMetadata_Nightly(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	gitCommand(t, "tag", "v1.2.3")
+	t.Setenv(NightlyBuild, "true")
+
+	m := getMetadata()
+	assert.Equal(t, "nightly", m.Permalink, "nightly mode should override the latest permalink")
+	assert.Regexp(t, `^v1\.2\.3\+nightly\.\d{8}$`, m.Version)
+	assert.False(t, m.IsTaggedRelease)
+	assert.True(t, m.ShouldPublishPermalink())
+}
+
+func TestApplyDirtyStatus(t *testing.T) {
+	clean := GitMetadata{
+		Permalink:       "canary",
+		Version:         "v0.30.1-32-gfe72ff73",
+		Commit:          "fe72ff73",
+		IsTaggedRelease: false,
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		got := applyDirtyStatus(clean, "")
+		assert.Equal(t, clean, got)
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		status := " M releases/git.go\n?? releases/new.go"
+		got := applyDirtyStatus(clean, status)
+		assert.True(t, got.IsDirty)
+		assert.Equal(t, "v0.30.1-32-gfe72ff73+dirty", got.Version)
+		assert.Equal(t, "dev", got.Permalink)
+		assert.False(t, got.ShouldPublishPermalink())
+	})
+
+	t.Run("dirty tagged release", func(t *testing.T) {
+		tagged := GitMetadata{Permalink: "latest", Version: "v0.30.1", IsTaggedRelease: true}
+		got := applyDirtyStatus(tagged, " M go.mod")
+		assert.Equal(t, "v0.30.1+dirty", got.Version)
+		assert.Equal(t, "dev", got.Permalink)
+		assert.False(t, got.IsTaggedRelease, "a dirty build should never be a tagged release")
+	})
+}
+
 func TestPickBranchName(t *testing.T) {
 	// These aren't set locally but are set on a CI run
-	os.Unsetenv("SYSTEM_PULLREQUEST_SOURCEBRANCH")
-	os.Unsetenv("BUILD_SOURCEBRANCHNAME")
-	os.Unsetenv("BUILD_SOURCEBRANCH")
+	unsetBuildEnvironment(t)
 
 	t.Run("origin/main", func(t *testing.T) {
 		refs := []string{
@@ -35,6 +308,24 @@ func TestPickBranchName(t *testing.T) {
 		assert.Equal(t, "main", branch)
 	})
 
+	t.Run("custom remote", func(t *testing.T) {
+		defer func() { RemoteName = "origin" }()
+		RemoteName = "upstream"
+
+		refs := []string{
+			"refs/heads/foo",
+			"refs/remotes/upstream/main",
+			"refs/tags/v0.38.3",
+		}
+		assert.Equal(t, "main", pickBranchName(refs))
+
+		refs = []string{
+			"refs/remotes/upstream/release/v1",
+			"refs/tags/v1.2.3",
+		}
+		assert.Equal(t, "v1", pickBranchName(refs))
+	})
+
 	t.Run("pull request", func(t *testing.T) {
 		os.Setenv("SYSTEM_PULLREQUEST_SOURCEBRANCH", "patch-1")
 		defer os.Unsetenv("SYSTEM_PULLREQUEST_SOURCEBRANCH")
@@ -99,4 +390,838 @@ func TestPickBranchName(t *testing.T) {
 		branch := pickBranchName(refs)
 		assert.Equal(t, "dev", branch)
 	})
+
+	t.Run("github branch build", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_REF", "refs/heads/main")
+		t.Setenv("GITHUB_REF_NAME", "main")
+
+		refs := []string{
+			"refs/remotes/origin/8252b6e4b1983702c7387ece7f971ef74047b746",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "main", branch)
+	})
+
+	t.Run("github empty ref name", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_REF", "refs/heads/main")
+		t.Setenv("GITHUB_REF_NAME", "")
+
+		refs := []string{
+			"refs/remotes/origin/8252b6e4b1983702c7387ece7f971ef74047b746",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "main", branch, "the branch should be read from GITHUB_REF")
+	})
+
+	t.Run("github pull request", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_HEAD_REF", "patch-1")
+		t.Setenv("GITHUB_REF", "refs/pull/12/merge")
+		t.Setenv("GITHUB_REF_NAME", "12/merge")
+
+		refs := []string{
+			"refs/remotes/origin/main",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "dev", branch)
+	})
+
+	t.Run("github tagged release on v1", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_REF", "refs/tags/v1.0.0-alpha.1")
+		t.Setenv("GITHUB_REF_NAME", "v1.0.0-alpha.1")
+
+		refs := []string{
+			"refs/remotes/origin/release/v1",
+			"refs/tags/v1.0.0-alpha.1",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "v1", branch)
+	})
+
+	t.Run("gitlab branch build", func(t *testing.T) {
+		t.Setenv("GITLAB_CI", "true")
+		t.Setenv("CI_COMMIT_REF_NAME", "release/v1")
+
+		refs := []string{
+			"refs/remotes/origin/8252b6e4b1983702c7387ece7f971ef74047b746",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "v1", branch)
+	})
+
+	t.Run("gitlab merge request", func(t *testing.T) {
+		t.Setenv("GITLAB_CI", "true")
+		t.Setenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "patch-1")
+		t.Setenv("CI_COMMIT_REF_NAME", "patch-1")
+
+		refs := []string{
+			"refs/remotes/origin/main",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "dev", branch)
+	})
+
+	t.Run("gitlab tagged release on main", func(t *testing.T) {
+		t.Setenv("GITLAB_CI", "true")
+		t.Setenv("CI_COMMIT_TAG", "v0.38.3")
+		t.Setenv("CI_COMMIT_REF_NAME", "v0.38.3")
+
+		refs := []string{
+			"refs/remotes/origin/release/v1",
+			"refs/remotes/origin/main",
+			"refs/tags/v0.38.3",
+		}
+		branch := pickBranchName(refs)
+		assert.Equal(t, "main", branch)
+	})
+}
+
+func TestPickBranchName_Azure(t *testing.T) {
+	testcases := []struct {
+		name          string
+		env           map[string]string
+		refs          []string
+		version       string
+		wantBranch    string
+		wantPermalink string
+	}{
+		{name: "main", env: map[string]string{"BUILD_SOURCEBRANCH": "refs/heads/main", "BUILD_SOURCEBRANCHNAME": "main"},
+			version: "v1.2.3-4-g8252b6e", wantBranch: "main", wantPermalink: "canary"},
+		{name: "release branch", env: map[string]string{"BUILD_SOURCEBRANCH": "refs/heads/release/v1", "BUILD_SOURCEBRANCHNAME": "v1"},
+			version: "v1.2.3-4-g8252b6e", wantBranch: "v1", wantPermalink: "canary-v1"},
+		{name: "feature branch", env: map[string]string{"BUILD_SOURCEBRANCH": "refs/heads/feature/arm64", "BUILD_SOURCEBRANCHNAME": "arm64"},
+			version: "v1.2.3-4-g8252b6e", wantBranch: "dev", wantPermalink: "canary-dev"},
+		{name: "tag on main", env: map[string]string{"BUILD_SOURCEBRANCH": "refs/tags/v1.3.0", "BUILD_SOURCEBRANCHNAME": "v1.3.0"},
+			refs: []string{"refs/remotes/origin/release/v1", "refs/remotes/origin/main", "refs/tags/v1.3.0"}, wantBranch: "main"},
+		{name: "tag on release branch", env: map[string]string{"BUILD_SOURCEBRANCH": "refs/tags/v1.2.4", "BUILD_SOURCEBRANCHNAME": "v1.2.4"},
+			refs: []string{"refs/remotes/origin/release/v1", "refs/tags/v1.2.4"}, wantBranch: "v1"},
+		{name: "pull request", env: map[string]string{"SYSTEM_PULLREQUEST_SOURCEBRANCH": "refs/heads/main", "BUILD_SOURCEBRANCH": "refs/pull/123/merge"},
+			version: "v1.2.3-4-g8252b6e", wantBranch: "main", wantPermalink: "dev"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unsetBuildEnvironment(t)
+			t.Setenv("TF_BUILD", "True")
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			branch := pickBranchName(tc.refs)
+			assert.Equal(t, tc.wantBranch, branch)
+
+			// Tagged releases are detected with git, the same as every other build provider
+			if tc.wantPermalink != "" {
+				permalink, _ := getPermalink(branch, tc.version, false)
+				assert.Equal(t, tc.wantPermalink, permalink)
+			}
+		})
+	}
+}
+
+// unsetBuildEnvironment clears the environment variables set by build providers
+// so that tests behave the same locally and on CI.
+func unsetBuildEnvironment(t *testing.T) {
+	for _, name := range []string{
+		"TF_BUILD", "SYSTEM_PULLREQUEST_SOURCEBRANCH", "BUILD_SOURCEBRANCHNAME", "BUILD_SOURCEBRANCH",
+		"GITHUB_ACTIONS", "GITHUB_HEAD_REF", "GITHUB_REF", "GITHUB_REF_NAME",
+		"GITLAB_CI", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_COMMIT_REF_NAME", "CI_COMMIT_TAG",
+		"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER", "SYSTEM_PULLREQUEST_PULLREQUESTID", "CI_MERGE_REQUEST_IID",
+		"GITHUB_BASE_REF", "SYSTEM_PULLREQUEST_TARGETBRANCH", "CI_MERGE_REQUEST_TARGET_BRANCH_NAME",
+		"GITHUB_EVENT_NAME", "BUILD_REASON", "CI_PIPELINE_SOURCE",
+		PublishPullRequestArtifacts, NightlyBuild,
+	} {
+		// Register the original value to be restored when the test completes
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func TestSortRefs(t *testing.T) {
+	refs := []string{
+		"refs/tags/v10.0.0",
+		"refs/remotes/origin/release/v10",
+		"refs/heads/foo",
+		"refs/remotes/origin/release/v2",
+		"refs/remotes/origin/main",
+	}
+	sortRefs(refs)
+
+	wantRefs := []string{
+		"refs/remotes/origin/main",
+		"refs/remotes/origin/release/v2",
+		"refs/remotes/origin/release/v10",
+		"refs/heads/foo",
+		"refs/tags/v10.0.0",
+	}
+	assert.Equal(t, wantRefs, refs, "release/v10 should sort after release/v2")
+}
+
+func TestGetBranchName(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+
+	t.Run("main", func(t *testing.T) {
+		assert.Equal(t, "main", GetBranchName())
+	})
+
+	t.Run("release branch", func(t *testing.T) {
+		gitCommand(t, "checkout", "-b", "release/v1")
+		gitCommit(t, "fix: backport")
+
+		assert.Equal(t, "v1", GetBranchName())
+	})
+
+	t.Run("feature branch", func(t *testing.T) {
+		gitCommand(t, "checkout", "-b", "patch-1")
+		gitCommit(t, "feat: try something")
+
+		assert.Equal(t, "dev", GetBranchName())
+	})
+}
+
+func TestGetBranchName_DetachedHead(t *testing.T) {
+	unsetBuildEnvironment(t)
+
+	// Only the remote tracking branch contains the checked out commit
+	useFakeCommand(t, "git", `case "$1" in
+for-each-ref) exit 0 ;;
+branch) printf "  origin/HEAD -> origin/main\n  origin/main\n" ;;
+*) exit 1 ;;
+esac`)
+
+	assert.Equal(t, "main", GetBranchName())
+}
+
+func TestGetPermalink_PullRequest(t *testing.T) {
+	unsetBuildEnvironment(t)
+
+	t.Run("gitlab merge request", func(t *testing.T) {
+		t.Setenv("GITLAB_CI", "true")
+		t.Setenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "patch-1")
+
+		permalink, tagged := getPermalink("dev", "v1.2.3", false)
+		assert.Equal(t, "dev", permalink)
+		assert.False(t, tagged)
+	})
+
+	t.Run("github pull request", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_HEAD_REF", "patch-1")
+
+		permalink, tagged := getPermalink("dev", "v1.2.3", false)
+		assert.Equal(t, "dev", permalink)
+		assert.False(t, tagged)
+	})
+}
+
+func TestGetMetadata_PullRequest(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	gitCommand(t, "tag", "v1.2.3")
+
+	testcases := []struct {
+		name           string
+		env            map[string]string
+		wantPR         bool
+		wantBaseBranch string
+	}{
+		{name: "github", env: map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_HEAD_REF": "patch-1", "GITHUB_BASE_REF": "release/v1"}, wantPR: true, wantBaseBranch: "release/v1"},
+		{name: "azure", env: map[string]string{"TF_BUILD": "True", "SYSTEM_PULLREQUEST_SOURCEBRANCH": "patch-1", "SYSTEM_PULLREQUEST_TARGETBRANCH": "refs/heads/main"}, wantPR: true, wantBaseBranch: "main"},
+		{name: "gitlab", env: map[string]string{"GITLAB_CI": "true", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME": "patch-1", "CI_MERGE_REQUEST_TARGET_BRANCH_NAME": "main"}, wantPR: true, wantBaseBranch: "main"},
+		{name: "unknown base branch", env: map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_HEAD_REF": "patch-1"}, wantPR: true},
+		{name: "branch build", env: map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/heads/main", "GITHUB_REF_NAME": "main"}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			m := getMetadata()
+			assert.Equal(t, tc.wantPR, m.IsPullRequest)
+			assert.Equal(t, tc.wantBaseBranch, m.BaseBranch)
+		})
+	}
+}
+
+func TestGetPermalink_PublishPullRequestArtifacts(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv(PublishPullRequestArtifacts, "true")
+
+	testcases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{name: "github", env: map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_HEAD_REF": "patch-1", "GITHUB_REF": "refs/pull/123/merge"}},
+		{name: "azure", env: map[string]string{"TF_BUILD": "True", "SYSTEM_PULLREQUEST_SOURCEBRANCH": "patch-1", "SYSTEM_PULLREQUEST_PULLREQUESTNUMBER": "123"}},
+		{name: "gitlab", env: map[string]string{"GITLAB_CI": "true", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME": "patch-1", "CI_MERGE_REQUEST_IID": "123"}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			permalink, tagged := getPermalink("dev", "v1.2.3", false)
+			assert.Equal(t, "pr-123", permalink)
+			assert.False(t, tagged)
+			assert.True(t, GitMetadata{Permalink: permalink}.ShouldPublishPermalink())
+		})
+	}
+
+	t.Run("unknown pull request number", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_HEAD_REF", "patch-1")
+		t.Setenv("GITHUB_REF", "refs/heads/patch-1")
+
+		permalink, _ := getPermalink("dev", "v1.2.3", false)
+		assert.Equal(t, "dev", permalink)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv(PublishPullRequestArtifacts, "false")
+		assert.False(t, GitMetadata{Permalink: "pr-123"}.ShouldPublishPermalink())
+	})
+}
+
+func TestRetryGit(t *testing.T) {
+	origBackoff := gitRetryBackoff
+	defer func() { gitRetryBackoff = origBackoff }()
+	gitRetryBackoff = 0
+
+	// useFlakyGit fakes a git command that fails with the specified message
+	// until it has been called the specified number of times
+	useFlakyGit := func(t *testing.T, failures int, msg string) string {
+		countFile := filepath.Join(t.TempDir(), "count")
+		useFakeCommand(t, "git", fmt.Sprintf(`count=$(cat %[1]s 2>/dev/null || echo 0)
+count=$((count+1))
+echo $count > %[1]s
+if [ $count -le %[2]d ]; then echo "%[3]s" >&2; exit 128; fi
+echo v1.2.3`, countFile, failures, msg))
+		return countFile
+	}
+	gotAttempts := func(t *testing.T, countFile string) string {
+		count, err := os.ReadFile(countFile)
+		require.NoError(t, err)
+		return strings.TrimSpace(string(count))
+	}
+
+	t.Run("transient failure is retried", func(t *testing.T) {
+		countFile := useFlakyGit(t, 2, "fatal: Unable to create '/src/.git/index.lock': File exists.")
+
+		version, err := retryGit("describe", "--tags")
+		require.NoError(t, err)
+		assert.Equal(t, "v1.2.3", version)
+		assert.Equal(t, "3", gotAttempts(t, countFile))
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		t.Setenv(GitRetries, "2")
+		countFile := useFlakyGit(t, 5, "fatal: unable to read tree 8252b6e4")
+
+		_, err := retryGit("describe", "--tags")
+		require.ErrorContains(t, err, "unable to read tree")
+		assert.Equal(t, "2", gotAttempts(t, countFile))
+	})
+
+	t.Run("no tags is not retried", func(t *testing.T) {
+		countFile := useFlakyGit(t, 5, "fatal: No names found, cannot describe anything.")
+
+		_, err := retryGit("describe", "--tags")
+		require.ErrorContains(t, err, "No names found")
+		assert.Equal(t, "1", gotAttempts(t, countFile))
+	})
+
+	t.Run("invalid retry count", func(t *testing.T) {
+		t.Setenv(GitRetries, "lots")
+
+		_, err := retryGit("describe", "--tags")
+		require.ErrorContains(t, err, "invalid PORTER_GIT_RETRIES")
+	})
+}
+
+func TestGetVersion(t *testing.T) {
+	t.Run("tagged", func(t *testing.T) {
+		useFakeCommand(t, "git", "echo v1.2.3-4-gabc123")
+
+		version, err := getVersion()
+		require.NoError(t, err)
+		assert.Equal(t, "v1.2.3-4-gabc123", version)
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		useFakeCommand(t, "git", `if [ "$1" = "describe" ]; then echo "fatal: No names found, cannot describe anything." >&2; exit 128; fi
+echo 12`)
+
+		version, err := getVersion()
+		require.NoError(t, err)
+		assert.Equal(t, "v0.0.0", version)
+	})
+
+	t.Run("not a repository", func(t *testing.T) {
+		useFakeCommand(t, "git", `echo "fatal: not a git repository (or any of the parent directories): .git" >&2; exit 128`)
+
+		_, err := getVersion()
+		require.ErrorContains(t, err, "not a git repository")
+	})
+
+	t.Run("git not installed", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+
+		_, err := getVersion()
+		require.ErrorContains(t, err, "could not determine the version")
+	})
+}
+
+func TestParseDescription(t *testing.T) {
+	testcases := []struct {
+		name        string
+		output      string
+		want        gitDescription
+		wantVersion string
+		wantTagged  bool
+	}{
+		{name: "tagged", output: "v1.2.3-0-g8252b6e",
+			want: gitDescription{Tag: "v1.2.3", Hash: "8252b6e"}, wantVersion: "v1.2.3", wantTagged: true},
+		{name: "untagged", output: "v0.30.1-32-gfe72ff73",
+			want: gitDescription{Tag: "v0.30.1", CommitsSinceTag: 32, Hash: "fe72ff73"}, wantVersion: "v0.30.1-32-gfe72ff73"},
+		{name: "dirty", output: "v0.30.1-32-gfe72ff73-dirty",
+			want: gitDescription{Tag: "v0.30.1", CommitsSinceTag: 32, Hash: "fe72ff73", IsDirty: true}, wantVersion: "v0.30.1-32-gfe72ff73"},
+		{name: "dirty tag", output: "v1.2.3-0-g8252b6e-dirty",
+			want: gitDescription{Tag: "v1.2.3", Hash: "8252b6e", IsDirty: true}, wantVersion: "v1.2.3", wantTagged: true},
+		{name: "prerelease", output: "v1.2.3-rc.1-4-g8252b6e",
+			want: gitDescription{Tag: "v1.2.3-rc.1", CommitsSinceTag: 4, Hash: "8252b6e"}, wantVersion: "v1.2.3-rc.1-4-g8252b6e"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseDescription(tc.output)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantVersion, got.Version())
+			assert.Equal(t, tc.wantTagged, got.IsTagged())
+		})
+	}
+
+	t.Run("tag prefix", func(t *testing.T) {
+		origPrefix := TagPrefix
+		defer func() { TagPrefix = origPrefix }()
+		TagPrefix = "mixin-helm/"
+
+		got, err := parseDescription("mixin-helm/v1.2.3-4-g8252b6e")
+		require.NoError(t, err)
+		assert.Equal(t, "mixin-helm/v1.2.3", got.Tag)
+		assert.Equal(t, "v1.2.3-4-g8252b6e", got.Version())
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		got := gitDescription{CommitsSinceTag: 12}
+		assert.Equal(t, "v0.0.0", got.Version())
+		assert.False(t, got.IsTagged())
+	})
+
+	t.Run("not long output", func(t *testing.T) {
+		_, err := parseDescription("v1.2.3")
+		require.ErrorContains(t, err, `could not parse the output of git describe, "v1.2.3"`)
+	})
+}
+
+func TestDescribeHead(t *testing.T) {
+	useTestRepo(t)
+
+	t.Run("no tags", func(t *testing.T) {
+		gitCommit(t, "second commit")
+
+		got, err := describeHead()
+		require.NoError(t, err)
+		assert.Equal(t, gitDescription{CommitsSinceTag: 2}, got)
+	})
+
+	t.Run("tagged", func(t *testing.T) {
+		gitCommand(t, "tag", "v1.2.3")
+		head := gitCommand(t, "rev-parse", "--short", "HEAD")
+
+		got, err := describeHead()
+		require.NoError(t, err)
+		assert.Equal(t, gitDescription{Tag: "v1.2.3", Hash: head}, got)
+	})
+
+	t.Run("dirty", func(t *testing.T) {
+		gitCommit(t, "another commit")
+		require.NoError(t, os.WriteFile("go.mod", []byte("module example.com/test\n"), 0644))
+		gitCommand(t, "add", "go.mod")
+		head := gitCommand(t, "rev-parse", "--short", "HEAD")
+
+		got, err := describeHead()
+		require.NoError(t, err)
+		assert.Equal(t, gitDescription{Tag: "v1.2.3", CommitsSinceTag: 1, Hash: head, IsDirty: true}, got)
+	})
+}
+
+func TestLoadMetadata_ExportEnv(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv("GITHUB_ENV", "")
+	useMetadata(t, GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"})
+
+	t.Run("local", func(t *testing.T) {
+		assert.NotPanics(t, func() { LoadMetadata() })
+	})
+
+	t.Run("ci environment file missing", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		logs := captureLogs(t)
+
+		assert.NotPanics(t, func() { LoadMetadata() })
+		assert.Contains(t, logs.String(), "WARNING: could not export PERMALINK")
+	})
+}
+
+func TestLoadMetadataReadOnly(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv("GITHUB_ACTIONS", "true")
+	envFile := filepath.Join(t.TempDir(), "github.env")
+	require.NoError(t, os.WriteFile(envFile, nil, 0644))
+	t.Setenv("GITHUB_ENV", envFile)
+	useMetadata(t, GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"})
+
+	m := LoadMetadataReadOnly()
+	assert.Equal(t, "canary", m.Permalink)
+	exported, err := os.ReadFile(envFile)
+	require.NoError(t, err)
+	assert.Empty(t, string(exported), "the metadata should not be exported in read-only mode")
+
+	LoadMetadata()
+	exported, err = os.ReadFile(envFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(exported), "PERMALINK", "LoadMetadata should still export the metadata")
+}
+
+func TestMetadataFromEnv(t *testing.T) {
+	unsetBuildEnvironment(t)
+
+	t.Run("tagged release", func(t *testing.T) {
+		t.Setenv(PermalinkEnv, "latest")
+		t.Setenv(VersionEnv, "v1.2.3")
+		t.Setenv(CommitEnv, "8252b6e")
+
+		m, ok := MetadataFromEnv()
+		require.True(t, ok)
+		assert.Equal(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e", IsTaggedRelease: true}, m)
+	})
+
+	t.Run("prerelease", func(t *testing.T) {
+		t.Setenv(PermalinkEnv, "preview")
+		t.Setenv(VersionEnv, "v1.3.0-rc.1")
+		t.Setenv(CommitEnv, "")
+
+		m, ok := MetadataFromEnv()
+		require.True(t, ok)
+		assert.Equal(t, GitMetadata{Permalink: "preview", Version: "v1.3.0-rc.1", IsTaggedRelease: true, IsPrerelease: true}, m)
+	})
+
+	t.Run("canary", func(t *testing.T) {
+		t.Setenv(PermalinkEnv, "canary")
+		t.Setenv(VersionEnv, "v1.2.3-4-g8252b6e")
+		t.Setenv(CommitEnv, "8252b6e")
+
+		m, ok := MetadataFromEnv()
+		require.True(t, ok)
+		assert.Equal(t, GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e", Commit: "8252b6e"}, m)
+	})
+
+	t.Run("missing version", func(t *testing.T) {
+		t.Setenv(PermalinkEnv, "canary")
+		t.Setenv(VersionEnv, "")
+
+		_, ok := MetadataFromEnv()
+		assert.False(t, ok)
+	})
+
+	t.Run("exported by LoadMetadata", func(t *testing.T) {
+		t.Setenv("GITHUB_ACTIONS", "true")
+		envFile := filepath.Join(t.TempDir(), "github.env")
+		require.NoError(t, os.WriteFile(envFile, nil, 0644))
+		t.Setenv("GITHUB_ENV", envFile)
+		useMetadata(t, GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e", Commit: "8252b6e"})
+
+		LoadMetadata()
+		exported, err := os.ReadFile(envFile)
+		require.NoError(t, err)
+		for _, name := range []string{PermalinkEnv, VersionEnv, CommitEnv} {
+			assert.Contains(t, string(exported), name)
+		}
+	})
+}
+
+func TestGetMetadata_Prerelease(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+
+	t.Run("release candidate", func(t *testing.T) {
+		gitCommand(t, "tag", "v1.2.0-rc.1")
+
+		m := getMetadata()
+		assert.Equal(t, "v1.2.0-rc.1", m.Version)
+		assert.True(t, m.IsTaggedRelease)
+		assert.True(t, m.IsPrerelease)
+		assert.Equal(t, "preview", m.Permalink)
+	})
+
+	t.Run("stable release", func(t *testing.T) {
+		gitCommit(t, "fix: last minute fix")
+		gitCommand(t, "tag", "v1.2.0")
+
+		m := getMetadata()
+		assert.True(t, m.IsTaggedRelease)
+		assert.False(t, m.IsPrerelease)
+		assert.Equal(t, "latest", m.Permalink)
+	})
+}
+
+func TestGetMetadata_Hotfix(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	gitCommand(t, "tag", "v1.5.0")
+	gitCommit(t, "feat!: remove the old api")
+	gitCommand(t, "tag", "v2.0.0")
+
+	t.Run("highest stable release", func(t *testing.T) {
+		m := getMetadata()
+		assert.Equal(t, "v2.0.0", m.Version)
+		assert.Equal(t, "latest", m.Permalink)
+	})
+
+	t.Run("hotfix of an older release", func(t *testing.T) {
+		gitCommand(t, "checkout", "-b", "hotfix", "v1.5.0")
+		gitCommit(t, "fix: patch security issue")
+		gitCommand(t, "tag", "v1.5.1")
+		gitCommand(t, "checkout", "-B", "main")
+
+		m := getMetadata()
+		assert.Equal(t, "v1.5.1", m.Version)
+		assert.True(t, m.IsTaggedRelease)
+		assert.Equal(t, "latest-v1", m.Permalink, "latest should not be moved back to an older release")
+	})
+}
+
+func TestGitMetadata_MinorPermalink(t *testing.T) {
+	t.Setenv(PublishMinorPermalinks, "true")
+
+	assert.Equal(t, "latest-v1.2", GitMetadata{Permalink: "latest-v1", Version: "v1.2.3", Branch: "v1", IsTaggedRelease: true}.MinorPermalink())
+	assert.Equal(t, "latest-v1.2", GitMetadata{Permalink: "latest", Version: "v1.2.3", Branch: "main", IsTaggedRelease: true}.MinorPermalink())
+	assert.Empty(t, GitMetadata{Permalink: "latest-v2", Version: "v1.2.3", Branch: "v2", IsTaggedRelease: true}.MinorPermalink(), "the release should be on the branch for its major version")
+	assert.Empty(t, GitMetadata{Permalink: "preview", Version: "v1.3.0-rc.1", Branch: "main", IsTaggedRelease: true, IsPrerelease: true}.MinorPermalink())
+	assert.Empty(t, GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e", Branch: "main"}.MinorPermalink())
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv(PublishMinorPermalinks, "")
+
+		assert.Empty(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Branch: "main", IsTaggedRelease: true}.MinorPermalink())
+	})
+}
+
+func TestGetMetadata_MinorPermalink(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv(PublishMinorPermalinks, "true")
+	useTestRepo(t)
+	gitCommand(t, "checkout", "-b", "release/v1")
+	gitCommit(t, "fix: patch the v1 release")
+	gitCommand(t, "tag", "v1.2.3")
+
+	m := getMetadata()
+	assert.Equal(t, "v1", m.Branch)
+	assert.Equal(t, "latest-v1", m.Permalink)
+	assert.Equal(t, "latest-v1.2", m.MinorPermalink())
+}
+
+func TestPickLatestPermalink(t *testing.T) {
+	tags := []string{"v1.5.0", "v1.5.1", "v2.0.0", "v2.1.0-rc.1", "canary"}
+
+	assert.Equal(t, "latest", pickLatestPermalink("v2.0.0", parseVersionTags(tags)), "the highest stable release should be latest")
+	assert.Equal(t, "latest", pickLatestPermalink("v2.0.1", parseVersionTags(tags)), "a new highest release should be latest")
+	assert.Equal(t, "latest-v1", pickLatestPermalink("v1.5.1", parseVersionTags(tags)), "a hotfix below the highest release should use its major version")
+	assert.Equal(t, "latest", pickLatestPermalink("v1.0.0", nil), "the first release should be latest")
+
+	t.Run("without the v prefix", func(t *testing.T) {
+		t.Cleanup(func() { TagHasVPrefix = true })
+		TagHasVPrefix = false
+
+		tags := []string{"1.5.0", "1.5.1", "2.0.0"}
+		permalink := pickLatestPermalink("1.5.1", parseVersionTags(tags))
+		assert.Equal(t, "latest-1", permalink, "the permalink should match the tags when they don't have a v prefix")
+
+		t.Setenv(PublishVersionedPermalinks, "true")
+		m := GitMetadata{Permalink: permalink, Version: "1.5.1", IsTaggedRelease: true}
+		assert.True(t, m.ShouldPublishPermalink(), "the permalink of the major version should be published")
+	})
+}
+
+func TestGetMetadata_TagPrefix(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	defer func() { TagPrefix = "" }()
+	TagPrefix = "mixin-foo/"
+
+	gitCommand(t, "tag", "mixin-foo/v1.2.0")
+	gitCommand(t, "tag", "v9.0.0")
+	gitCommit(t, "feat(bar): add bar")
+	gitCommand(t, "tag", "mixin-bar/v2.0.0")
+
+	t.Run("untagged for the prefix", func(t *testing.T) {
+		m := getMetadata()
+		assert.Regexp(t, `^v1\.2\.0-1-g[0-9a-f]+$`, m.Version, "only tags with the prefix should be used")
+		assert.False(t, m.IsTaggedRelease)
+		assert.Equal(t, "canary", m.Permalink)
+		assert.Equal(t, "v1", m.MajorTag())
+	})
+
+	t.Run("tagged with the prefix", func(t *testing.T) {
+		gitCommand(t, "tag", "mixin-foo/v1.3.0")
+
+		m := getMetadata()
+		assert.Equal(t, "v1.3.0", m.Version)
+		assert.True(t, m.IsTaggedRelease)
+		assert.Equal(t, "latest", m.Permalink)
+		assert.Equal(t, "v1.3", m.MajorMinorTag())
+	})
+
+	t.Run("version override", func(t *testing.T) {
+		t.Setenv(VersionOverride, "mixin-foo/v1.3.0")
+
+		m := getMetadata()
+		assert.Equal(t, "v1.3.0", m.Version)
+		assert.True(t, m.IsTaggedRelease)
+	})
+}
+
+func TestGetMetadata_TagPattern(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	t.Cleanup(func() {
+		TagPattern = "v*"
+		TagHasVPrefix = true
+	})
+	TagPattern = "[0-9]*"
+	TagHasVPrefix = false
+
+	gitCommand(t, "tag", "1.2.0")
+	gitCommit(t, "feat: add bar")
+	gitCommand(t, "tag", "v9.0.0")
+
+	t.Run("untagged", func(t *testing.T) {
+		m := getMetadata()
+		assert.Regexp(t, `^1\.2\.0-1-g[0-9a-f]+$`, m.Version, "only tags matching the pattern should be used")
+		assert.False(t, m.IsTaggedRelease)
+		assert.Equal(t, "canary", m.Permalink)
+		assert.Equal(t, "1", m.MajorTag())
+	})
+
+	t.Run("tagged", func(t *testing.T) {
+		gitCommand(t, "tag", "1.3.0")
+
+		m := getMetadata()
+		assert.Equal(t, "1.3.0", m.Version)
+		assert.True(t, m.IsTaggedRelease)
+		assert.Equal(t, "latest", m.Permalink)
+		assert.Equal(t, "1", m.MajorTag())
+		assert.Equal(t, "1.3", m.MajorMinorTag())
+
+		previous, err := PreviousVersion()
+		require.NoError(t, err)
+		assert.Equal(t, "1.2.0", previous)
+	})
+
+	t.Run("bump", func(t *testing.T) {
+		next, err := BumpVersion("minor")
+		require.NoError(t, err)
+		assert.Equal(t, "1.4.0", next, "the version should not have a v prefix")
+	})
+}
+
+func TestGetMetadata_AnnotatedTagsOnly(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	gitCommand(t, "tag", "--annotate", "--message", "v1.0.0", "v1.0.0")
+	gitCommit(t, "fix: oops")
+	gitCommand(t, "tag", "v1.0.1")
+
+	t.Run("any tag", func(t *testing.T) {
+		m := getMetadata()
+		assert.Equal(t, "v1.0.1", m.Version, "lightweight tags should be used by default")
+		assert.True(t, m.IsTaggedRelease)
+		assert.Equal(t, "latest", m.Permalink)
+	})
+
+	t.Run("annotated only", func(t *testing.T) {
+		defer func() { AnnotatedTagsOnly = false }()
+		AnnotatedTagsOnly = true
+
+		m := getMetadata()
+		assert.Regexp(t, `^v1\.0\.0-1-g[0-9a-f]+$`, m.Version, "the lightweight tag should be ignored")
+		assert.False(t, m.IsTaggedRelease)
+		assert.Equal(t, "canary", m.Permalink)
+	})
+}
+
+func TestGetMetadata_BuildDate(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	t.Setenv("GIT_COMMITTER_DATE", "2023-01-02T03:04:05+02:00")
+	gitCommit(t, "feat: reproducible builds")
+
+	t.Run("commit date", func(t *testing.T) {
+		t.Setenv(SourceDateEpoch, "")
+
+		m := getMetadata()
+		assert.Equal(t, "2023-01-02T01:04:05Z", m.BuildDate)
+	})
+
+	t.Run("SOURCE_DATE_EPOCH", func(t *testing.T) {
+		t.Setenv(SourceDateEpoch, "1700000000")
+
+		m := getMetadata()
+		assert.Equal(t, "2023-11-14T22:13:20Z", m.BuildDate)
+	})
+
+	t.Run("invalid SOURCE_DATE_EPOCH", func(t *testing.T) {
+		t.Setenv(SourceDateEpoch, "yesterday")
+
+		_, err := getBuildDate()
+		require.ErrorContains(t, err, `invalid SOURCE_DATE_EPOCH value "yesterday"`)
+	})
+}
+
+func TestGetMetadata_Overrides(t *testing.T) {
+	// Git should not be used when the version is overridden
+	t.Setenv("PATH", t.TempDir())
+
+	t.Run("release version", func(t *testing.T) {
+		t.Setenv(VersionOverride, "v1.2.3")
+		t.Setenv(CommitOverride, "8252b6e")
+
+		m := getMetadata()
+		assert.Equal(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e", IsTaggedRelease: true}, m)
+	})
+
+	t.Run("describe version", func(t *testing.T) {
+		t.Setenv(VersionOverride, "v1.2.3-4-g8252b6e")
+		t.Setenv(CommitOverride, "8252b6e")
+
+		m := getMetadata()
+		assert.Equal(t, GitMetadata{Permalink: "dev", Version: "v1.2.3-4-g8252b6e", Commit: "8252b6e", IsTaggedRelease: false}, m)
+	})
+
+	t.Run("prerelease version", func(t *testing.T) {
+		t.Setenv(VersionOverride, "v1.2.3-rc.1")
+
+		m := getMetadata()
+		assert.True(t, m.IsTaggedRelease, "release candidates are tagged releases")
+		assert.True(t, m.IsPrerelease)
+		assert.Equal(t, "preview", m.Permalink)
+		assert.Empty(t, m.Commit, "the commit should be empty when it isn't overridden and git isn't available")
+	})
 }
@@ -0,0 +1,420 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"get.porter.sh/magefiles/tools"
+	"github.com/carolynvs/magex/shx"
+	"github.com/magefile/mage/mg"
+)
+
+// ReleaseOptions are the options for publishing a GitHub release.
+type ReleaseOptions struct {
+	// Repository is the GitHub repository to publish to, e.g. github.com/getporter/porter.
+	// Defaults to the value of PORTER_RELEASE_REPOSITORY, and then the repository
+	// of the RemoteName remote, see DetectRepo.
+	Repository string
+
+	// ArtifactsDir is the directory containing the files to attach to the release.
+	ArtifactsDir string
+
+	// ArtifactGroups are additional named directories of files to attach to the
+	// release, e.g. a mixin bundle published alongside the CLI. Each file is
+	// uploaded with the name of its group as a prefix, e.g. mixin-helm-linux-amd64,
+	// and the release notes list the assets of each group in their own section.
+	ArtifactGroups []ArtifactGroup
+
+	// GenerateSBOMs creates an SBOM for each artifact, named NAME.sbom.json, which is uploaded with the release.
+	GenerateSBOMs bool
+
+	// ChecksumAlgorithms are additional algorithms whose checksums are uploaded
+	// with the release, e.g. SHA512 for checksums_sha512.txt. The SHA256 checksums
+	// are always uploaded in checksums.txt.
+	ChecksumAlgorithms []ChecksumAlgorithm
+
+	// GenerateProvenance creates a SLSA provenance attestation for the artifacts, named
+	// provenance.intoto.json, which is uploaded with the release, and signed when Sign is set.
+	GenerateProvenance bool
+
+	// Sign the artifacts with cosign before they are uploaded. Signing is skipped when nil.
+	Sign *SignOptions
+
+	// GPG signs checksums.txt with GPG, and uploads the signature, checksums.txt.asc,
+	// with the release. Signing is skipped when nil.
+	GPG *GPGOptions
+
+	// OnPublished are called after the release is published, with the metadata
+	// of the build and the assets of the release, e.g. SlackNotify. A failed
+	// hook is logged, and doesn't fail the release. Hooks aren't called in dry-run mode.
+	OnPublished []PublishHook
+
+	// Changelog is the path to a hand-curated changelog, e.g. CHANGELOG.md. When
+	// set, the section for the version, see ChangelogSection, is used as the notes
+	// of the version's release, falling back to GetChangelog when it doesn't have one.
+	Changelog string
+
+	// TagNotes uses the message of the annotated version tag, see TagMessage,
+	// as the notes of the version's release. It takes precedence over Changelog,
+	// which is used when the tag doesn't have a message, e.g. a lightweight tag.
+	TagNotes bool
+
+	// Force overwrites assets that are already attached to the release for
+	// the version. By default only missing assets are uploaded, so that a
+	// failed publish can be retried, and a release that already has every
+	// asset is skipped. Permalink releases are always overwritten.
+	Force bool
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// ArtifactGroup is a named directory of files attached to a release.
+type ArtifactGroup struct {
+	// Name of the group, used as the prefix of its files and the heading of its section in the release notes, e.g. mixin.
+	Name string
+
+	// Dir is the directory containing the files in the group.
+	Dir string
+}
+
+// stagedArtifactGroup is an artifact group after its files were copied into the staging directory with its prefix.
+type stagedArtifactGroup struct {
+	Name  string
+	Files []string
+}
+
+// PublishRelease uploads every file in the artifacts directory, along with a
+// generated checksums.txt and any SBOMs or signatures, to the GitHub release for the current version.
+// Builds that are not a tagged release are only published to the permalink,
+// e.g. canary, which is moved to the current commit.
+func PublishRelease(opts ReleaseOptions) error {
+	repo, err := resolveReleaseRepository(opts.Repository)
+	if err != nil {
+		return err
+	}
+	opts.Repository = repo
+	if opts.ArtifactsDir == "" && len(opts.ArtifactGroups) == 0 {
+		opts.ArtifactsDir = OutputDir
+	}
+
+	if !isDryRun(opts.DryRun) {
+		mg.Deps(tools.EnsureGitHubClient, ConfigureGitBot)
+	}
+
+	return publishRelease(LoadMetadata(), opts)
+}
+
+func publishRelease(info GitMetadata, opts ReleaseOptions) error {
+	// Combine the groups with the ungrouped artifacts, so that they are checksummed, signed and uploaded together
+	var groups []stagedArtifactGroup
+	if len(opts.ArtifactGroups) > 0 {
+		stagingDir, err := os.MkdirTemp("", "porter-release")
+		if err != nil {
+			return fmt.Errorf("error creating a staging directory for the release artifacts: %w", err)
+		}
+		defer os.RemoveAll(stagingDir)
+
+		groups, err = stageArtifactGroups(opts.ArtifactsDir, opts.ArtifactGroups, stagingDir)
+		if err != nil {
+			return err
+		}
+		opts.ArtifactsDir = stagingDir
+	}
+
+	if !opts.Force {
+		expected, err := listReleaseArtifacts(opts.ArtifactsDir)
+		if err != nil {
+			return err
+		}
+		if isReleaseComplete(info, opts.Repository, expected, VerifyReleaseOptions{Signed: opts.Sign != nil}) {
+			return nil
+		}
+	}
+
+	if opts.GenerateSBOMs {
+		if err := generateSBOMs(opts.ArtifactsDir, opts.DryRun); err != nil {
+			return err
+		}
+	}
+
+	checksumsPath := filepath.Join(opts.ArtifactsDir, ChecksumsFile)
+	if err := GenerateChecksums(opts.ArtifactsDir, checksumsPath, opts.ChecksumAlgorithms...); err != nil {
+		return err
+	}
+
+	if opts.GenerateProvenance {
+		if err := generateProvenance(info, opts.ArtifactsDir, filepath.Join(opts.ArtifactsDir, ProvenanceFile)); err != nil {
+			return err
+		}
+	}
+
+	if opts.Sign != nil {
+		signOpts := *opts.Sign
+		signOpts.DryRun = signOpts.DryRun || opts.DryRun
+		if err := signArtifacts(info, opts.ArtifactsDir, signOpts); err != nil {
+			return err
+		}
+	}
+
+	if opts.GPG != nil {
+		gpgOpts := *opts.GPG
+		gpgOpts.DryRun = gpgOpts.DryRun || opts.DryRun
+		if err := SignChecksums(checksumsPath, gpgOpts); err != nil {
+			return err
+		}
+	}
+
+	// Catch artifacts that changed after they were checksummed, before they're uploaded
+	if err := VerifyChecksums(checksumsPath, opts.ArtifactsDir); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(opts.ArtifactsDir)
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", opts.ArtifactsDir, err)
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		files = append(files, filepath.Join(opts.ArtifactsDir, entry.Name()))
+	}
+
+	// Move the permalink (canary/latest) to the current commit and update its release
+	// Pull request artifacts are only published to a bucket, see PublishPullRequestArtifacts
+	publishPermalink := info.ShouldPublishPermalink() && !isPullRequestPermalink(info.Permalink)
+	if publishPermalink {
+		remote := fmt.Sprintf("https://%s.git", opts.Repository)
+		if err := movePermalinkTag(info, info.Permalink, MoveTagOptions{Remote: remote, DryRun: opts.DryRun}); err != nil {
+			return err
+		}
+
+		notes := getArtifactGroupNotes(opts.Repository, info.Permalink, groups)
+		if err := uploadReleaseAssets(opts.Repository, info.Permalink, files, notes, true, opts.DryRun); err != nil {
+			return err
+		}
+	} else {
+		log.Println("Skipping publish release for permalink", info.Permalink)
+	}
+
+	// Move the permalink for the minor version, e.g. latest-v1.2, to the latest patch
+	if minorPermalink := info.MinorPermalink(); minorPermalink != "" {
+		remote := fmt.Sprintf("https://%s.git", opts.Repository)
+		if err := movePermalinkTag(info, minorPermalink, MoveTagOptions{Remote: remote, DryRun: opts.DryRun}); err != nil {
+			return err
+		}
+
+		notes := getArtifactGroupNotes(opts.Repository, minorPermalink, groups)
+		if err := uploadReleaseAssets(opts.Repository, minorPermalink, files, notes, true, opts.DryRun); err != nil {
+			return err
+		}
+	}
+
+	// Only create a release for the exact version (v1.2.3) when it's tagged
+	if !info.IsTaggedRelease {
+		if publishPermalink {
+			runPublishHooks(opts.OnPublished, info, opts.Repository, info.Permalink, files, opts.DryRun)
+		}
+		return nil
+	}
+	tag := getReleaseTag(info)
+	notes := getArtifactGroupNotes(opts.Repository, tag, groups)
+	var releaseNotes string
+	if opts.TagNotes {
+		// Fall back to the changelog, or generated notes, e.g. for a lightweight tag
+		message, err := TagMessage(tag)
+		if err != nil {
+			log.Printf("Skipping using the tag message as the release notes: %s\n", err)
+		}
+		releaseNotes = message
+	}
+	if releaseNotes == "" && opts.Changelog != "" {
+		changelog, err := getReleaseNotes(opts.Changelog, info.Version)
+		if err != nil {
+			return err
+		}
+		releaseNotes = changelog
+	}
+	if releaseNotes != "" {
+		if notes != "" {
+			releaseNotes += "\n\n" + notes
+		}
+		notes = releaseNotes
+	}
+	if err := uploadReleaseAssets(opts.Repository, tag, files, notes, opts.Force, opts.DryRun); err != nil {
+		return err
+	}
+	runPublishHooks(opts.OnPublished, info, opts.Repository, tag, files, opts.DryRun)
+	return nil
+}
+
+// resolveReleaseRepository returns the repository that a release is published to, e.g. github.com/getporter/porter,
+// defaulting to the PORTER_RELEASE_REPOSITORY environment variable and then the repository of the RemoteName remote.
+func resolveReleaseRepository(repo string) (string, error) {
+	if repo == "" {
+		repo = os.Getenv(ReleaseRepository)
+	}
+	if repo == "" {
+		host, owner, name, err := detectRepo()
+		if err != nil {
+			return "", fmt.Errorf("no release repository specified, set %s to github.com/USERNAME/REPO: %w", ReleaseRepository, err)
+		}
+		repo = path.Join(host, owner, name)
+	}
+	return repo, nil
+}
+
+// DetectRepo returns the owner and name of the GitHub repository from the url
+// of the RemoteName remote, which defaults to origin. Both SSH urls, e.g.
+// git@github.com:getporter/porter.git, and HTTPS urls, e.g.
+// https://github.com/getporter/porter.git, are supported.
+func DetectRepo() (owner string, repo string, err error) {
+	_, owner, repo, err = detectRepo()
+	return owner, repo, err
+}
+
+// detectRepo returns the host, owner and name of the repository of the RemoteName remote, e.g. github.com, getporter and porter.
+func detectRepo() (string, string, string, error) {
+	remote, err := retryGit("remote", "get-url", RemoteName)
+	if err != nil {
+		return "", "", "", fmt.Errorf("could not get the url of the %s remote: %w", RemoteName, err)
+	}
+	return parseRepoURL(remote)
+}
+
+// scpRepoURL matches the scp-like syntax for an SSH remote, e.g. git@github.com:getporter/porter.git
+var scpRepoURL = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// parseRepoURL returns the host, owner and name of the repository from a git remote url.
+func parseRepoURL(remote string) (string, string, string, error) {
+	remote = strings.TrimSpace(remote)
+
+	var host, repoPath string
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" && u.Host != "" {
+		host, repoPath = u.Hostname(), u.Path
+		// Don't include credentials used by CI in the error
+		u.User = nil
+		remote = u.String()
+	} else if match := scpRepoURL.FindStringSubmatch(remote); match != nil {
+		host, repoPath = match[1], match[2]
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimSuffix(repoPath, ".git"), "/"), "/")
+	if host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("could not determine the repository from the remote url %s, it must be in the format https://HOST/OWNER/REPO or git@HOST:OWNER/REPO", remote)
+	}
+	return host, parts[0], parts[1], nil
+}
+
+// ReleaseURL returns the URL of the GitHub release for the current build, e.g.
+// https://github.com/getporter/porter/releases/tag/v1.2.3, in the repository
+// from PORTER_RELEASE_REPOSITORY. Builds that are not a tagged release link to
+// the release for their permalink, e.g. canary.
+// An empty string is returned when the repository isn't set.
+func ReleaseURL() string {
+	return releaseURL(LoadMetadata(), os.Getenv(ReleaseRepository))
+}
+
+func releaseURL(info GitMetadata, repo string) string {
+	if repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://%s/releases/tag/%s", repo, getReleaseTag(info))
+}
+
+// ArtifactURL returns the download URL of a file attached to the GitHub
+// release for the current build, e.g.
+// https://github.com/getporter/porter/releases/download/canary/porter-linux-amd64.
+// An empty string is returned when the repository isn't set.
+func ArtifactURL(filename string) string {
+	return artifactURL(LoadMetadata(), os.Getenv(ReleaseRepository), filename)
+}
+
+func artifactURL(info GitMetadata, repo string, filename string) string {
+	if repo == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://%s/releases/download/%s/%s", repo, getReleaseTag(info), filename)
+}
+
+// getReleaseTag returns the tag of the release for the build: the version tag,
+// including the TagPrefix, for tagged releases, otherwise the permalink.
+func getReleaseTag(info GitMetadata) string {
+	if info.IsTaggedRelease {
+		return TagPrefix + info.Version
+	}
+	return info.Permalink
+}
+
+// stageArtifactGroups copies the files in the artifacts directory, when it's set, and
+// the files in each group, prefixed with the name of the group, into the staging directory.
+func stageArtifactGroups(artifactsDir string, groups []ArtifactGroup, stagingDir string) ([]stagedArtifactGroup, error) {
+	stageDir := func(dir string, prefix string) ([]string, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error listing release artifacts in %s: %w", dir, err)
+		}
+
+		var files []string
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			name := prefix + entry.Name()
+			if _, err := os.Stat(filepath.Join(stagingDir, name)); err == nil {
+				return nil, fmt.Errorf("more than one release artifact is named %s", name)
+			}
+			if err := shx.Copy(filepath.Join(dir, entry.Name()), filepath.Join(stagingDir, name)); err != nil {
+				return nil, fmt.Errorf("error staging release artifact %s: %w", name, err)
+			}
+			files = append(files, name)
+		}
+		return files, nil
+	}
+
+	if artifactsDir != "" {
+		if _, err := stageDir(artifactsDir, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	staged := make([]stagedArtifactGroup, 0, len(groups))
+	for _, group := range groups {
+		if group.Name == "" || group.Dir == "" {
+			return nil, fmt.Errorf("the name and directory of each artifact group are required")
+		}
+
+		files, err := stageDir(group.Dir, group.Name+"-")
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, stagedArtifactGroup{Name: group.Name, Files: files})
+	}
+	return staged, nil
+}
+
+// getArtifactGroupNotes returns the release notes for the artifact groups, with
+// a section for each group that links to the download of each of its assets.
+func getArtifactGroupNotes(repo string, tag string, groups []stagedArtifactGroup) string {
+	baseURL := fmt.Sprintf("https://%s/releases/download/%s", repo, tag)
+
+	var sections []string
+	for _, group := range groups {
+		var notes strings.Builder
+		fmt.Fprintf(&notes, "## %s\n", group.Name)
+		for _, file := range group.Files {
+			fmt.Fprintf(&notes, "- [%s](%s/%s)\n", file, baseURL, file)
+		}
+		sections = append(sections, notes.String())
+	}
+	return strings.Join(sections, "\n")
+}
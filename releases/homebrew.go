@@ -0,0 +1,180 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// TapOptions are the options for updating the Homebrew formula of a release.
+type TapOptions struct {
+	// Repository of the Homebrew tap, e.g. github.com/getporter/homebrew-tap.
+	Repository string
+
+	// Formula is the name of the formula, e.g. porter. The formula is written to Formula/FORMULA.rb in the tap.
+	Formula string
+
+	// TemplatePath is the path to a text/template for the formula. The template
+	// is passed the Name, Version (without the leading v), Tag, and the URL and
+	// SHA256 of the DarwinAMD64 and DarwinARM64 binaries.
+	TemplatePath string
+
+	// Name of the binary, e.g. porter. Defaults to the formula name.
+	Name string
+
+	// ArtifactsDir is the directory that contains the darwin binaries, used to calculate their checksums.
+	// Defaults to OutputDir.
+	ArtifactsDir string
+
+	// ReleaseRepository that the binaries were released to, e.g. github.com/getporter/porter.
+	// Defaults to the PORTER_RELEASE_REPOSITORY environment variable.
+	ReleaseRepository string
+
+	// PullRequest opens a pull request against the tap, instead of pushing directly to its default branch.
+	PullRequest bool
+
+	// DryRun prints the rendered formula without updating the tap.
+	DryRun bool
+}
+
+// formulaData is passed to the formula template.
+type formulaData struct {
+	Name        string
+	Version     string
+	Tag         string
+	DarwinAMD64 formulaArtifact
+	DarwinARM64 formulaArtifact
+}
+
+type formulaArtifact struct {
+	URL    string
+	SHA256 string
+}
+
+// UpdateHomebrewTap renders the formula for the release and commits it to the Homebrew tap.
+// Only stable tagged releases published to the latest permalink update the formula,
+// prereleases and hotfixes of older major versions are skipped, and
+// builds that aren't tagged, e.g. canary builds, return ErrNotTagged.
+func UpdateHomebrewTap(opts TapOptions) error {
+	return updateHomebrewTap(LoadMetadata(), opts)
+}
+
+func updateHomebrewTap(info GitMetadata, opts TapOptions) error {
+	if opts.Repository == "" || opts.Formula == "" || opts.TemplatePath == "" {
+		return fmt.Errorf("the tap repository, formula and template path are required")
+	}
+	if opts.Name == "" {
+		opts.Name = opts.Formula
+	}
+
+	if !info.IsTaggedRelease {
+		return fmt.Errorf("cannot update the homebrew tap for %s: %w", info.Version, ErrNotTagged)
+	}
+	if info.IsPrerelease {
+		log.Println("Skipping update homebrew tap for", info.Version)
+		return nil
+	}
+	// Hotfixes of an older major version, e.g. v0.9.9 after v1.2.0, use latest-v0 and shouldn't downgrade the formula
+	if info.Permalink != Permalinks.TaggedAlias {
+		log.Printf("Skipping update homebrew tap for %s, the formula is only updated for releases published to %s\n", info.Version, Permalinks.TaggedAlias)
+		return nil
+	}
+	if opts.ArtifactsDir == "" {
+		opts.ArtifactsDir = OutputDir
+	}
+
+	formula, err := renderFormula(info, opts)
+	if err != nil {
+		return err
+	}
+
+	formulaPath := filepath.Join("Formula", opts.Formula+".rb")
+	if isDryRun(opts.DryRun) {
+		log.Printf("[dry-run] %s:\n%s", formulaPath, formula)
+		return nil
+	}
+
+	tapDir, err := os.MkdirTemp("", "homebrew-tap")
+	if err != nil {
+		return fmt.Errorf("error creating a temporary directory for the homebrew tap: %w", err)
+	}
+	defer os.RemoveAll(tapDir)
+
+	remote := fmt.Sprintf("https://%s.git", opts.Repository)
+	if err := shx.Command("git", "clone", "--depth=1", remote, tapDir).RunV(); err != nil {
+		return fmt.Errorf("error cloning the homebrew tap %s: %w", opts.Repository, err)
+	}
+	configureGitBotIn(tapDir)
+
+	if err := os.MkdirAll(filepath.Join(tapDir, "Formula"), 0755); err != nil {
+		return fmt.Errorf("error creating the Formula directory in the homebrew tap: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tapDir, formulaPath), []byte(formula), 0644); err != nil {
+		return fmt.Errorf("error writing the homebrew formula %s: %w", formulaPath, err)
+	}
+
+	msg := fmt.Sprintf("Update %s to %s", opts.Formula, info.Version)
+	branch := fmt.Sprintf("%s-%s", opts.Formula, info.Version)
+	cmds := []shx.PreparedCommand{
+		shx.Command("git", "add", formulaPath),
+		shx.Command("git", "-c", "user.name='Porter Bot'", "-c", "user.email=bot@porter.sh", "commit", "--signoff", "-m", msg),
+	}
+	if opts.PullRequest {
+		cmds = append(cmds,
+			shx.Command("git", "push", remote, "HEAD:refs/heads/"+branch),
+			shx.Command("gh", "pr", "create", "-R", opts.Repository, "--head", branch, "--title", msg, "--body", msg))
+	} else {
+		cmds = append(cmds, shx.Command("git", "push", remote, "HEAD"))
+	}
+	for _, cmd := range cmds {
+		if err := runOrLog(cmd.In(tapDir), opts.DryRun); err != nil {
+			return fmt.Errorf("error updating the homebrew tap %s: %w", opts.Repository, err)
+		}
+	}
+	return nil
+}
+
+// renderFormula renders the formula template with the download URL and checksum of the darwin binaries.
+func renderFormula(info GitMetadata, opts TapOptions) (string, error) {
+	tmpl, err := template.ParseFiles(opts.TemplatePath)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the homebrew formula template %s: %w", opts.TemplatePath, err)
+	}
+
+	baseURL, err := getDownloadBaseURL(getReleaseTag(info), InstallOptions{Repository: opts.ReleaseRepository})
+	if err != nil {
+		return "", err
+	}
+
+	getArtifact := func(arch string) (formulaArtifact, error) {
+		binaryName := BinaryName(opts.Name, Platform{OS: "darwin", Arch: arch})
+		sum, err := checksumFile(filepath.Join(opts.ArtifactsDir, binaryName))
+		if err != nil {
+			return formulaArtifact{}, err
+		}
+		return formulaArtifact{URL: baseURL + "/" + binaryName, SHA256: sum}, nil
+	}
+
+	data := formulaData{
+		Name:    opts.Name,
+		Version: strings.TrimPrefix(info.Version, "v"),
+		Tag:     getReleaseTag(info),
+	}
+	if data.DarwinAMD64, err = getArtifact("amd64"); err != nil {
+		return "", err
+	}
+	if data.DarwinARM64, err = getArtifact("arm64"); err != nil {
+		return "", err
+	}
+
+	var formula strings.Builder
+	if err := tmpl.Execute(&formula, data); err != nil {
+		return "", fmt.Errorf("error rendering the homebrew formula template %s: %w", opts.TemplatePath, err)
+	}
+	return formula.String(), nil
+}
@@ -0,0 +1,88 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateHomebrewTap(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-darwin-amd64"), []byte("amd64"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-darwin-arm64"), []byte("arm64"), 0755))
+	opts := TapOptions{
+		Repository:        "github.com/getporter/homebrew-tap",
+		Formula:           "porter",
+		TemplatePath:      "testdata/homebrew/formula.rb.tmpl",
+		ArtifactsDir:      dir,
+		ReleaseRepository: "github.com/getporter/porter",
+		DryRun:            true,
+	}
+
+	t.Run("stable release", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+		require.NoError(t, updateHomebrewTap(info, opts))
+
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "[dry-run] "+filepath.Join("Formula", "porter.rb"))
+		assert.Contains(t, gotLogs, `version "1.2.3"`)
+		assert.Contains(t, gotLogs, `url "https://github.com/getporter/porter/releases/download/v1.2.3/porter-darwin-amd64"
+    sha256 "5861314d7fccb39c2192173240eab44fa35ca66426201ca2acd0630a6258dd51"`)
+		assert.Contains(t, gotLogs, `url "https://github.com/getporter/porter/releases/download/v1.2.3/porter-darwin-arm64"
+    sha256 "f69162950f235e3cdbbad33f1f912d1a504be90d8a37d002c735d6f3e3882265"`)
+		assert.Contains(t, gotLogs, `bin.install Dir["porter-darwin-*"].first => "porter"`)
+	})
+
+	t.Run("prerelease", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "preview", Version: "v1.3.0-rc.1", IsTaggedRelease: true, IsPrerelease: true}
+		require.NoError(t, updateHomebrewTap(info, opts))
+		assert.NotContains(t, logs.String(), "[dry-run]")
+	})
+
+	t.Run("hotfix of an older major version", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "latest-v0", Version: "v0.9.9", IsTaggedRelease: true}
+		require.NoError(t, updateHomebrewTap(info, opts))
+		assert.Contains(t, logs.String(), "Skipping update homebrew tap for v0.9.9")
+		assert.NotContains(t, logs.String(), "[dry-run]", "the formula should not be downgraded")
+	})
+
+	t.Run("default artifacts dir", func(t *testing.T) {
+		origOutputDir := OutputDir
+		defer func() { OutputDir = origOutputDir }()
+		OutputDir = dir
+		logs := captureLogs(t)
+		opts := opts
+		opts.ArtifactsDir = ""
+
+		info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+		require.NoError(t, updateHomebrewTap(info, opts))
+		assert.Contains(t, logs.String(), `sha256 "5861314d7fccb39c2192173240eab44fa35ca66426201ca2acd0630a6258dd51"`)
+	})
+
+	t.Run("canary", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		info := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+		err := updateHomebrewTap(info, opts)
+		require.ErrorIs(t, err, ErrNotTagged)
+		assert.NotContains(t, logs.String(), "[dry-run]")
+	})
+
+	t.Run("missing binary", func(t *testing.T) {
+		opts := opts
+		opts.Name = "porter-agent"
+
+		info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+		err := updateHomebrewTap(info, opts)
+		require.ErrorContains(t, err, "porter-agent-darwin-amd64")
+	})
+}
@@ -0,0 +1,86 @@
+package releases
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+)
+
+// Asset is a file that was attached to a release.
+type Asset struct {
+	// Name of the asset, e.g. porter-linux-amd64.
+	Name string
+
+	// Path to the file that was uploaded.
+	Path string
+
+	// URL that the asset is downloaded from, e.g.
+	// https://github.com/getporter/porter/releases/download/v1.2.3/porter-linux-amd64.
+	URL string
+
+	// ReleaseURL is the URL of the release that the asset is attached to, e.g.
+	// https://github.com/getporter/porter/releases/tag/v1.2.3.
+	ReleaseURL string
+}
+
+// PublishHook is called after a release is published, with the metadata of
+// the build and the assets attached to the release, e.g. to announce the release.
+// A failed hook is logged, and doesn't fail the release.
+type PublishHook func(info GitMetadata, assets []Asset) error
+
+// runPublishHooks calls each hook with the assets of the release for the tag, logging the hooks that fail.
+func runPublishHooks(hooks []PublishHook, info GitMetadata, repo string, tag string, files []string, dryRun bool) {
+	if len(hooks) == 0 {
+		return
+	}
+	if isDryRun(dryRun) {
+		log.Printf("[dry-run] Skipping %d publish hooks for the %s release\n", len(hooks), tag)
+		return
+	}
+
+	assets := make([]Asset, len(files))
+	for i, file := range files {
+		name := filepath.Base(file)
+		assets[i] = Asset{
+			Name:       name,
+			Path:       file,
+			URL:        fmt.Sprintf("https://%s/releases/download/%s/%s", repo, tag, name),
+			ReleaseURL: fmt.Sprintf("https://%s/releases/tag/%s", repo, tag),
+		}
+	}
+
+	for _, hook := range hooks {
+		if err := hook(info, assets); err != nil {
+			log.Printf("WARNING: a publish hook for the %s release failed: %s\n", tag, err)
+		}
+	}
+}
+
+// SlackNotify returns a PublishHook that posts the version and the URL of the
+// release to a Slack incoming webhook, e.g. https://hooks.slack.com/services/...
+func SlackNotify(webhookURL string) PublishHook {
+	return func(info GitMetadata, assets []Asset) error {
+		text := fmt.Sprintf("Released %s", info.Version)
+		if len(assets) > 0 {
+			text = fmt.Sprintf("Released <%s|%s>", assets[0].ReleaseURL, info.Version)
+		}
+
+		body, err := json.Marshal(map[string]string{"text": text})
+		if err != nil {
+			return fmt.Errorf("error building the slack message: %w", err)
+		}
+		resp, err := http.Post(webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error posting the release to slack: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("error posting the release to slack: %s", resp.Status)
+		}
+		return nil
+	}
+}
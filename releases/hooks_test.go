@@ -0,0 +1,37 @@
+package releases
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlackNotify(t *testing.T) {
+	var gotMessage map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&gotMessage); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+	assets := []Asset{{Name: ChecksumsFile, ReleaseURL: "https://github.com/getporter/porter/releases/tag/v1.2.3"}}
+
+	require.NoError(t, SlackNotify(srv.URL)(info, assets))
+	assert.Equal(t, "Released <https://github.com/getporter/porter/releases/tag/v1.2.3|v1.2.3>", gotMessage["text"])
+
+	t.Run("webhook error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		t.Cleanup(srv.Close)
+
+		err := SlackNotify(srv.URL)(info, assets)
+		require.ErrorContains(t, err, "403 Forbidden")
+	})
+}
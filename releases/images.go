@@ -0,0 +1,249 @@
+package releases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultImagePlatforms are the platforms built by PublishImages when none are specified.
+var DefaultImagePlatforms = []Platform{
+	{OS: "linux", Arch: "amd64"},
+	{OS: "linux", Arch: "arm64"},
+}
+
+// ImageOptions are the options for publishing a multi-arch Docker image.
+type ImageOptions struct {
+	// Registry to push the image to, e.g. ghcr.io/getporter.
+	Registry string
+
+	// Repository name of the image, e.g. porter-agent.
+	Repository string
+
+	// Dockerfile used to build the image. Defaults to Dockerfile.
+	Dockerfile string
+
+	// Context is the build context directory. Defaults to the current directory.
+	Context string
+
+	// Platforms to build the image for. Defaults to DefaultImagePlatforms.
+	Platforms []Platform
+
+	// MaxParallel is the maximum number of platforms that are built concurrently.
+	// When it's less than the number of platforms, each platform is built
+	// separately and then combined into a manifest list. Defaults to
+	// building every platform at once.
+	MaxParallel int
+
+	// Login to the registry before pushing the image. When the registry isn't
+	// set, the registry from Registry is used, e.g. ghcr.io.
+	Login *RegistryLogin
+
+	// AdditionalRegistries are other registries that the image is pushed to,
+	// such as a mirror on Docker Hub. A failed push to one registry doesn't
+	// stop the image being pushed to the others.
+	AdditionalRegistries []ImageRegistry
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// ImageRegistry is a registry that an image is pushed to.
+type ImageRegistry struct {
+	// Registry to push the image to, e.g. docker.io/getporter.
+	Registry string
+
+	// Login to the registry before pushing the image. When the registry isn't
+	// set, the registry from Registry is used, e.g. docker.io.
+	Login *RegistryLogin
+}
+
+// PublishImages builds a multi-arch image with docker buildx and pushes a
+// manifest list tagged with the version, permalink and major version, e.g.
+// v1.2.3, latest and v1. Builds that are not a tagged release only push the
+// permalink tag, e.g. canary. The image is pushed to Registry and each of the
+// AdditionalRegistries, and the errors from every registry that failed are returned together.
+func PublishImages(opts ImageOptions) error {
+	return publishImages(LoadMetadata(), opts)
+}
+
+func publishImages(info GitMetadata, opts ImageOptions) error {
+	if opts.Registry == "" || opts.Repository == "" {
+		return fmt.Errorf("the image registry and repository are required")
+	}
+	if opts.Dockerfile == "" {
+		opts.Dockerfile = "Dockerfile"
+	}
+	if opts.Context == "" {
+		opts.Context = "."
+	}
+	if len(opts.Platforms) == 0 {
+		opts.Platforms = DefaultImagePlatforms
+	}
+
+	tags := getImageTags(info)
+	if len(tags) == 0 {
+		log.Println("Skipping publish images for permalink", info.Permalink)
+		return nil
+	}
+
+	// Push to every registry, even when one of them fails, e.g. Docker Hub is rate limiting
+	registries := append([]ImageRegistry{{Registry: opts.Registry, Login: opts.Login}}, opts.AdditionalRegistries...)
+	var errs []error
+	for _, registry := range registries {
+		if err := publishRegistryImage(registry, tags, opts); err != nil {
+			log.Println(err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishRegistryImage logs into the registry when needed, and then builds and pushes the image to it.
+// The layers are cached by buildx, so only the first registry builds the image.
+func publishRegistryImage(registry ImageRegistry, tags []string, opts ImageOptions) error {
+	if registry.Registry == "" {
+		return fmt.Errorf("the image registry is required")
+	}
+
+	if registry.Login != nil {
+		login := *registry.Login
+		if login.Registry == "" {
+			login.Registry = strings.SplitN(registry.Registry, "/", 2)[0]
+		}
+		login.DryRun = login.DryRun || opts.DryRun
+		if err := LoginRegistry(login); err != nil {
+			return err
+		}
+	}
+
+	image := fmt.Sprintf("%s/%s", strings.TrimSuffix(registry.Registry, "/"), opts.Repository)
+	if opts.MaxParallel > 0 && opts.MaxParallel < len(opts.Platforms) {
+		return publishPlatformImages(image, tags, opts)
+	}
+
+	platforms := make([]string, len(opts.Platforms))
+	for i, p := range opts.Platforms {
+		platforms[i] = p.String()
+	}
+	cmd := shx.Command("docker", "buildx", "build", "--platform", strings.Join(platforms, ","), "-f", opts.Dockerfile)
+	for _, tag := range tags {
+		cmd = cmd.Args("-t", image+":"+tag)
+	}
+	cmd = cmd.Args("--push", opts.Context)
+
+	if err := runOrLog(cmd, opts.DryRun); err != nil {
+		return fmt.Errorf("error publishing image %s: %w", image, err)
+	}
+	return nil
+}
+
+// getImageTags returns the tags to push for a build.
+func getImageTags(info GitMetadata) []string {
+	var tags []string
+	if info.IsTaggedRelease {
+		tags = append(tags, info.Version)
+		// Only stable releases move the major version tag, e.g. v1
+		if major := info.MajorTag(); major != "" && !info.IsPrerelease {
+			tags = append(tags, major)
+		}
+	}
+
+	if info.ShouldPublishPermalink() {
+		tags = append(tags, info.Permalink)
+	}
+	return tags
+}
+
+// publishPlatformImages builds and pushes the image for each platform by digest,
+// with at most MaxParallel builds at a time, and then pushes a manifest list
+// with the tags that references the image for each platform.
+// The first failed build cancels the remaining builds.
+func publishPlatformImages(image string, tags []string, opts ImageOptions) error {
+	metadataDir, err := os.MkdirTemp("", "porter-images")
+	if err != nil {
+		return fmt.Errorf("error creating a temporary directory for the image metadata: %w", err)
+	}
+	defer os.RemoveAll(metadataDir)
+
+	digests := make([]string, len(opts.Platforms))
+	g, ctx := errgroup.WithContext(context.Background())
+	sem := make(chan struct{}, opts.MaxParallel)
+	for i, platform := range opts.Platforms {
+		i, platform := i, platform
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			// Don't start a build after another has already failed
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			metadataFile := filepath.Join(metadataDir, fmt.Sprintf("%d.json", i))
+			cmd := shx.PreparedCommand{Cmd: exec.CommandContext(ctx, "docker", "buildx", "build",
+				"--platform", platform.String(), "-f", opts.Dockerfile, "--metadata-file", metadataFile,
+				"--output", "type=image,name="+image+",push-by-digest=true,name-canonical=true,push=true",
+				opts.Context)}
+			cmd = cmd.Stderr(os.Stderr)
+			if err := runOrLog(cmd, opts.DryRun); err != nil {
+				return fmt.Errorf("error building image %s for %s: %w", image, platform, err)
+			}
+
+			if isDryRun(opts.DryRun) {
+				digests[i] = fmt.Sprintf("<digest of %s>", platform)
+				return nil
+			}
+			digest, err := readImageDigest(metadataFile)
+			if err != nil {
+				return fmt.Errorf("error building image %s for %s: %w", image, platform, err)
+			}
+			digests[i] = digest
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	cmd := shx.Command("docker", "buildx", "imagetools", "create")
+	for _, tag := range tags {
+		cmd = cmd.Args("-t", image+":"+tag)
+	}
+	for _, digest := range digests {
+		cmd = cmd.Args(image + "@" + digest)
+	}
+	if err := runOrLog(cmd, opts.DryRun); err != nil {
+		return fmt.Errorf("error publishing image %s: %w", image, err)
+	}
+	return nil
+}
+
+// readImageDigest reads the digest of the pushed image from the metadata file written by docker buildx build.
+func readImageDigest(metadataFile string) (string, error) {
+	data, err := os.ReadFile(metadataFile)
+	if err != nil {
+		return "", fmt.Errorf("could not read the image metadata: %w", err)
+	}
+
+	var metadata struct {
+		Digest string `json:"containerimage.digest"`
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil || metadata.Digest == "" {
+		return "", fmt.Errorf("could not determine the image digest from %s", metadataFile)
+	}
+	return metadata.Digest, nil
+}
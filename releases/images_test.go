@@ -0,0 +1,198 @@
+package releases
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetImageTags(t *testing.T) {
+	testcases := []struct {
+		name     string
+		info     GitMetadata
+		wantTags []string
+	}{
+		{
+			name:     "tagged release",
+			info:     GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true},
+			wantTags: []string{"v1.2.3", "v1", "latest"},
+		},
+		{
+			name:     "tagged release on a release branch",
+			info:     GitMetadata{Permalink: "latest-v1", Version: "v1.2.3", IsTaggedRelease: true},
+			wantTags: []string{"v1.2.3", "v1"},
+		},
+		{
+			name:     "release candidate",
+			info:     GitMetadata{Permalink: "preview", Version: "v1.2.0-rc.1", IsTaggedRelease: true, IsPrerelease: true},
+			wantTags: []string{"v1.2.0-rc.1", "preview"},
+		},
+		{
+			name:     "canary",
+			info:     GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"},
+			wantTags: []string{"canary"},
+		},
+		{
+			name: "dev",
+			info: GitMetadata{Permalink: "dev", Version: "v1.2.3-4-g8252b6e"},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantTags, getImageTags(tc.info))
+		})
+	}
+}
+
+func TestPublishImages_DryRun(t *testing.T) {
+	logs := captureLogs(t)
+
+	info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+	opts := ImageOptions{
+		Registry:   "ghcr.io/getporter",
+		Repository: "porter-agent",
+		Dockerfile: "build/images/agent/Dockerfile",
+		DryRun:     true,
+	}
+	require.NoError(t, publishImages(info, opts))
+
+	assert.Contains(t, logs.String(), "[dry-run] docker buildx build --platform linux/amd64,linux/arm64 -f build/images/agent/Dockerfile "+
+		"-t ghcr.io/getporter/porter-agent:v1.2.3 -t ghcr.io/getporter/porter-agent:v1 -t ghcr.io/getporter/porter-agent:latest --push .")
+}
+
+func TestPublishImages_AdditionalRegistries(t *testing.T) {
+	forgetRegistryLogins(t)
+	info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+	opts := ImageOptions{
+		Registry:   "ghcr.io/getporter",
+		Repository: "porter-agent",
+		AdditionalRegistries: []ImageRegistry{
+			{Registry: "docker.io/getporter", Login: &RegistryLogin{Username: "porterbot", Password: "super-secret-token"}},
+		},
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		logs := captureLogs(t)
+		opts := opts
+		opts.DryRun = true
+
+		require.NoError(t, publishImages(info, opts))
+
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "[dry-run] docker buildx build --platform linux/amd64,linux/arm64 -f Dockerfile "+
+			"-t ghcr.io/getporter/porter-agent:v1.2.3 -t ghcr.io/getporter/porter-agent:v1 -t ghcr.io/getporter/porter-agent:latest --push .")
+		assert.Contains(t, gotLogs, "[dry-run] docker login docker.io --username porterbot --password-stdin")
+		assert.Contains(t, gotLogs, "[dry-run] docker buildx build --platform linux/amd64,linux/arm64 -f Dockerfile "+
+			"-t docker.io/getporter/porter-agent:v1.2.3 -t docker.io/getporter/porter-agent:v1 -t docker.io/getporter/porter-agent:latest --push .")
+	})
+
+	t.Run("failed push continues", func(t *testing.T) {
+		forgetRegistryLogins(t)
+		captureLogs(t)
+		t.Setenv(DryRunMode, "")
+		pushLog := filepath.Join(t.TempDir(), "pushes")
+		useFakeCommand(t, "docker", fmt.Sprintf(`if [ "$1" = "login" ]; then exit 0; fi
+echo "$@" >> %s
+case "$*" in *ghcr.io*) echo "denied: permission_denied" >&2; exit 1;; esac`, pushLog))
+
+		err := publishImages(info, opts)
+		require.ErrorContains(t, err, "error publishing image ghcr.io/getporter/porter-agent")
+
+		pushes, readErr := os.ReadFile(pushLog)
+		require.NoError(t, readErr)
+		assert.Contains(t, string(pushes), "-t ghcr.io/getporter/porter-agent:v1.2.3")
+		assert.Contains(t, string(pushes), "-t docker.io/getporter/porter-agent:v1.2.3", "the image should be pushed to docker.io even though ghcr.io failed")
+	})
+}
+
+func TestPublishImages_Login(t *testing.T) {
+	unsetBuildEnvironment(t)
+	forgetRegistryLogins(t)
+	logs := captureLogs(t)
+
+	info := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+	opts := ImageOptions{
+		Registry:   "ghcr.io/getporter",
+		Repository: "porter-agent",
+		Login:      &RegistryLogin{Username: "porter-bot", Password: "super-secret-token"},
+		DryRun:     true,
+	}
+	require.NoError(t, publishImages(info, opts))
+
+	gotLogs := logs.String()
+	assert.Contains(t, gotLogs, "[dry-run] docker login ghcr.io --username porter-bot --password-stdin")
+	assert.Less(t, strings.Index(gotLogs, "docker login"), strings.Index(gotLogs, "docker buildx build"), "the registry should be logged into before pushing")
+	assert.NotContains(t, gotLogs, "super-secret-token")
+}
+
+func TestPublishImages_MaxParallel(t *testing.T) {
+	info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+	platforms := []Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}, {OS: "linux", Arch: "arm"}, {OS: "linux", Arch: "s390x"}, {OS: "linux", Arch: "ppc64le"}}
+
+	// useFakeDocker records how many builds are running each time a build starts,
+	// and the arguments of each imagetools command
+	useFakeDocker := func(t *testing.T, buildScript string) (string, string) {
+		dir := t.TempDir()
+		running := filepath.Join(dir, "running")
+		require.NoError(t, os.Mkdir(running, 0755))
+		concurrencyLog := filepath.Join(dir, "concurrency")
+		imagetoolsLog := filepath.Join(dir, "imagetools")
+		useFakeCommand(t, "docker", fmt.Sprintf(`if [ "$2" = "imagetools" ]; then echo "$@" >> %[3]s; exit 0; fi
+touch %[1]s/$$
+ls %[1]s | wc -l >> %[2]s
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--metadata-file" ]; then metadata=$2; fi
+  if [ "$1" = "--platform" ]; then platform=$2; fi
+  shift
+done
+%[4]s
+sleep 0.2
+rm %[1]s/$$
+echo "{\"containerimage.digest\": \"sha256:$(echo $platform | tr -d /)\"}" > $metadata`, running, concurrencyLog, imagetoolsLog, buildScript))
+		return concurrencyLog, imagetoolsLog
+	}
+
+	t.Run("builds are limited", func(t *testing.T) {
+		concurrencyLog, imagetoolsLog := useFakeDocker(t, "")
+
+		opts := ImageOptions{Registry: "ghcr.io/getporter", Repository: "porter-agent", Platforms: platforms, MaxParallel: 2}
+		require.NoError(t, publishImages(info, opts))
+
+		counts, err := os.ReadFile(concurrencyLog)
+		require.NoError(t, err)
+		gotCounts := strings.Fields(string(counts))
+		require.Len(t, gotCounts, len(platforms), "each platform should be built separately")
+		for _, count := range gotCounts {
+			n, err := strconv.Atoi(count)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, n, 2, "no more than MaxParallel builds should run at once")
+		}
+
+		imagetools, err := os.ReadFile(imagetoolsLog)
+		require.NoError(t, err)
+		assert.Equal(t, "buildx imagetools create -t ghcr.io/getporter/porter-agent:v1.2.3 -t ghcr.io/getporter/porter-agent:v1 -t ghcr.io/getporter/porter-agent:latest "+
+			"ghcr.io/getporter/porter-agent@sha256:linuxamd64 ghcr.io/getporter/porter-agent@sha256:linuxarm64 ghcr.io/getporter/porter-agent@sha256:linuxarm "+
+			"ghcr.io/getporter/porter-agent@sha256:linuxs390x ghcr.io/getporter/porter-agent@sha256:linuxppc64le\n", string(imagetools))
+	})
+
+	t.Run("failed build cancels the others", func(t *testing.T) {
+		concurrencyLog, imagetoolsLog := useFakeDocker(t, "exit 1")
+
+		opts := ImageOptions{Registry: "ghcr.io/getporter", Repository: "porter-agent", Platforms: platforms, MaxParallel: 1}
+		err := publishImages(info, opts)
+		require.ErrorContains(t, err, "error building image ghcr.io/getporter/porter-agent for linux/")
+
+		counts, err := os.ReadFile(concurrencyLog)
+		require.NoError(t, err)
+		assert.Len(t, strings.Fields(string(counts)), 1, "the remaining builds should not start")
+		assert.NoFileExists(t, imagetoolsLog, "the manifest should not be pushed")
+	})
+}
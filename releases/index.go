@@ -0,0 +1,292 @@
+package releases
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+//go:embed index/index.html.tmpl
+var downloadIndexTemplate string
+
+// IndexOptions are the options for updating the download index with UpdateDownloadIndex.
+type IndexOptions struct {
+	// Name of the binary, e.g. porter, which is used as the title of the index.
+	Name string
+
+	// Repository that hosts the index, e.g. github.com/getporter/porter.
+	// Defaults to the PORTER_RELEASE_REPOSITORY environment variable.
+	Repository string
+
+	// Remote is the git remote that the index is cloned from and pushed to.
+	// Defaults to https://REPOSITORY.git.
+	Remote string
+
+	// Branch that the index is committed to. Defaults to gh-pages.
+	Branch string
+
+	// Dir is the directory in the branch that contains the index. Defaults to the root of the branch.
+	Dir string
+
+	// TemplatePath is the path to an html/template for the index. The template
+	// is passed the Name, and the Versions, highest first, each with their
+	// Version and Assets, which have a Platform and URL. Defaults to a simple list of links.
+	TemplatePath string
+
+	// ArtifactsDir is the directory containing the released binaries, named
+	// NAME-GOOS-GOARCH, e.g. bin/porter-linux-amd64. Defaults to OutputDir.
+	ArtifactsDir string
+
+	// ReleaseRepository that the binaries were released to, e.g. github.com/getporter/porter.
+	// Defaults to the PORTER_RELEASE_REPOSITORY environment variable.
+	ReleaseRepository string
+
+	// BaseURL to download the binaries from instead of the GitHub release,
+	// e.g. https://cdn.porter.sh. The binaries are downloaded from BASEURL/VERSION/FILENAME.
+	BaseURL string
+
+	// DryRun prints the rendered index without committing it.
+	DryRun bool
+}
+
+// downloadIndex lists the released versions and their binaries. It's stored
+// in versions.json next to index.html, so that the index can be rendered
+// again with every version when a release is added.
+type downloadIndex struct {
+	Name     string                 `json:"name"`
+	Versions []downloadIndexVersion `json:"versions"`
+}
+
+type downloadIndexVersion struct {
+	Version string               `json:"version"`
+	Assets  []downloadIndexAsset `json:"assets"`
+}
+
+type downloadIndexAsset struct {
+	// Platform of the binary, e.g. linux/amd64.
+	Platform string `json:"platform"`
+
+	// URL to download the binary from.
+	URL string `json:"url"`
+}
+
+// UpdateDownloadIndex adds the release to the download index, an index.html
+// that lists every released version and the download links of its binaries,
+// and commits it to the gh-pages branch of the repository. Versions are
+// identified by their version, so running it again for the same version
+// replaces its entry, and nothing is committed when the index is unchanged.
+// When DryRun is set, the branch is still cloned to list the existing
+// versions, and the rendered index is printed instead of being committed.
+// Builds that aren't tagged, e.g. canary builds, return ErrNotTagged.
+func UpdateDownloadIndex(opts IndexOptions) error {
+	return updateDownloadIndex(LoadMetadata(), opts)
+}
+
+func updateDownloadIndex(info GitMetadata, opts IndexOptions) error {
+	if opts.Name == "" {
+		return fmt.Errorf("the name of the binary is required to update the download index")
+	}
+	if !info.IsTaggedRelease {
+		return fmt.Errorf("cannot update the download index for %s: %w", info.Version, ErrNotTagged)
+	}
+	if opts.Repository == "" {
+		opts.Repository = os.Getenv(ReleaseRepository)
+	}
+	if opts.Remote == "" {
+		if opts.Repository == "" {
+			return fmt.Errorf("no repository specified for the download index, set %s to github.com/USERNAME/REPO", ReleaseRepository)
+		}
+		opts.Remote = fmt.Sprintf("https://%s.git", opts.Repository)
+	}
+	if opts.Branch == "" {
+		opts.Branch = "gh-pages"
+	}
+	if opts.ArtifactsDir == "" {
+		opts.ArtifactsDir = OutputDir
+	}
+
+	assets, err := getDownloadIndexAssets(info, opts)
+	if err != nil {
+		return err
+	}
+
+	pagesDir, err := os.MkdirTemp("", "download-index")
+	if err != nil {
+		return fmt.Errorf("error creating a temporary directory for the download index: %w", err)
+	}
+	defer os.RemoveAll(pagesDir)
+
+	if err := cloneIndexBranch(opts.Remote, opts.Branch, pagesDir); err != nil {
+		return err
+	}
+
+	indexDir := filepath.Join(pagesDir, opts.Dir)
+	html, changed, err := writeDownloadIndex(indexDir, downloadIndexVersion{Version: info.Version, Assets: assets}, opts)
+	if err != nil {
+		return err
+	}
+
+	if isDryRun(opts.DryRun) {
+		log.Printf("[dry-run] %s:\n%s", filepath.Join(opts.Dir, "index.html"), html)
+		return nil
+	}
+	if !changed {
+		log.Printf("The download index on %s already lists %s, skipping the update\n", opts.Branch, info.Version)
+		return nil
+	}
+
+	configureGitBotIn(pagesDir)
+	msg := fmt.Sprintf("Add %s to the download index", info.Version)
+	cmds := []shx.PreparedCommand{
+		shx.Command("git", "add", "-A", "--", filepath.Join(opts.Dir, "index.html"), filepath.Join(opts.Dir, "versions.json")),
+		shx.Command("git", "-c", "user.name='Porter Bot'", "-c", "user.email=bot@porter.sh", "commit", "--signoff", "-m", msg),
+		shx.Command("git", "push", opts.Remote, "HEAD:refs/heads/"+opts.Branch),
+	}
+	for _, cmd := range cmds {
+		if err := runOrLog(cmd.In(pagesDir), false); err != nil {
+			return fmt.Errorf("error updating the download index on %s: %w", opts.Branch, err)
+		}
+	}
+	return nil
+}
+
+// getDownloadIndexAssets returns the download links of the binaries in the artifacts directory, sorted by platform.
+func getDownloadIndexAssets(info GitMetadata, opts IndexOptions) ([]downloadIndexAsset, error) {
+	// The GitHub release is named after the tag, including the TagPrefix
+	release := getReleaseTag(info)
+	if opts.BaseURL != "" {
+		release = info.Version
+	}
+	baseURL, err := getDownloadBaseURL(release, InstallOptions{Repository: opts.ReleaseRepository, BaseURL: opts.BaseURL})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(opts.ArtifactsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing release artifacts in %s: %w", opts.ArtifactsDir, err)
+	}
+
+	var assets []downloadIndexAsset
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		platform, ok := parseBinaryPlatform(entry.Name())
+		if entry.IsDir() || !ok || !strings.HasPrefix(entry.Name(), opts.Name+"-") || (ext != "" && ext != ".exe") {
+			continue
+		}
+		assets = append(assets, downloadIndexAsset{Platform: platform.String(), URL: baseURL + "/" + entry.Name()})
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("no %s binaries were found in %s for the download index", opts.Name, opts.ArtifactsDir)
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].Platform < assets[j].Platform
+	})
+	return assets, nil
+}
+
+// cloneIndexBranch clones the branch of the remote into the directory. When
+// the branch doesn't exist yet, an empty repository is created instead, and the
+// branch is created when the index is pushed.
+func cloneIndexBranch(remote string, branch string, dir string) error {
+	heads, err := retryGit("ls-remote", "--heads", remote, branch)
+	if err != nil {
+		return fmt.Errorf("error checking for the %s branch of %s: %w", branch, remote, err)
+	}
+	if heads != "" {
+		if err := shx.Command("git", "clone", "--depth=1", "--branch", branch, remote, dir).RunV(); err != nil {
+			return fmt.Errorf("error cloning the %s branch of %s: %w", branch, remote, err)
+		}
+		return nil
+	}
+
+	log.Printf("The %s branch doesn't exist in %s, it will be created\n", branch, remote)
+	if err := shx.Command("git", "init", dir).RunV(); err != nil {
+		return fmt.Errorf("error creating a repository for the download index: %w", err)
+	}
+	if err := shx.Command("git", "checkout", "--orphan", branch).In(dir).RunV(); err != nil {
+		return fmt.Errorf("error creating the %s branch for the download index: %w", branch, err)
+	}
+	return nil
+}
+
+// writeDownloadIndex adds or replaces the version in versions.json in the directory,
+// and renders index.html from it. It returns the rendered index, and whether either file changed.
+func writeDownloadIndex(dir string, version downloadIndexVersion, opts IndexOptions) (string, bool, error) {
+	dataPath := filepath.Join(dir, "versions.json")
+	index := downloadIndex{Name: opts.Name}
+	if data, err := os.ReadFile(dataPath); err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return "", false, fmt.Errorf("error parsing the download index %s: %w", dataPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", false, fmt.Errorf("error reading the download index %s: %w", dataPath, err)
+	}
+
+	versions := []downloadIndexVersion{version}
+	for _, existing := range index.Versions {
+		if existing.Version != version.Version {
+			versions = append(versions, existing)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return manifestVersionLess(versions[j].Version, versions[i].Version)
+	})
+	index.Versions = versions
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", false, fmt.Errorf("error serializing the download index: %w", err)
+	}
+	html, err := renderDownloadIndex(index, opts)
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", false, fmt.Errorf("error creating the directory for the download index %s: %w", dir, err)
+	}
+	var changed bool
+	files := map[string][]byte{dataPath: append(data, '\n'), filepath.Join(dir, "index.html"): []byte(html)}
+	for path, contents := range files {
+		if existing, err := os.ReadFile(path); err == nil && string(existing) == string(contents) {
+			continue
+		}
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			return "", false, fmt.Errorf("error writing the download index %s: %w", path, err)
+		}
+		changed = true
+	}
+	return html, changed, nil
+}
+
+// renderDownloadIndex renders the index template, or the default template when a template path isn't specified.
+func renderDownloadIndex(index downloadIndex, opts IndexOptions) (string, error) {
+	name, contents := "index.html", downloadIndexTemplate
+	if opts.TemplatePath != "" {
+		data, err := os.ReadFile(opts.TemplatePath)
+		if err != nil {
+			return "", fmt.Errorf("error reading the download index template %s: %w", opts.TemplatePath, err)
+		}
+		name, contents = opts.TemplatePath, string(data)
+	}
+
+	tmpl, err := template.New(name).Parse(contents)
+	if err != nil {
+		return "", fmt.Errorf("error parsing the download index template %s: %w", name, err)
+	}
+
+	var html strings.Builder
+	if err := tmpl.Execute(&html, index); err != nil {
+		return "", fmt.Errorf("error rendering the download index template %s: %w", name, err)
+	}
+	return html.String(), nil
+}
@@ -0,0 +1,86 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateDownloadIndex(t *testing.T) {
+	repoDir := useTestRepo(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(repoDir, "build"), 0755))
+
+	// Publish an existing version to the gh-pages branch of a local remote
+	remote := filepath.Join(t.TempDir(), "pages.git")
+	gitCommand(t, "init", "--bare", remote)
+	pagesDir := t.TempDir()
+	gitCommand(t, "-C", pagesDir, "init", "--initial-branch=gh-pages")
+	require.NoError(t, os.WriteFile(filepath.Join(pagesDir, "versions.json"),
+		[]byte(`{"name":"porter","versions":[{"version":"v1.0.0","assets":[{"platform":"linux/amd64","url":"https://example.com/v1.0.0/porter-linux-amd64"}]}]}`), 0644))
+	gitCommand(t, "-C", pagesDir, "add", "versions.json")
+	gitCommand(t, "-C", pagesDir, "commit", "-m", "Add v1.0.0")
+	gitCommand(t, "-C", pagesDir, "push", remote, "HEAD:refs/heads/gh-pages")
+
+	artifactsDir := t.TempDir()
+	for _, name := range []string{"porter-linux-amd64", "porter-windows-amd64.exe", ChecksumsFile} {
+		require.NoError(t, os.WriteFile(filepath.Join(artifactsDir, name), []byte("porter"), 0644))
+	}
+	info := GitMetadata{Permalink: "latest", Version: "v1.1.0", IsTaggedRelease: true}
+	opts := IndexOptions{Name: "porter", Remote: remote, ArtifactsDir: artifactsDir, ReleaseRepository: "github.com/getporter/porter"}
+
+	t.Run("dry run", func(t *testing.T) {
+		logs := captureLogs(t)
+		dryRunOpts := opts
+		dryRunOpts.DryRun = true
+
+		require.NoError(t, updateDownloadIndex(info, dryRunOpts))
+		assert.Contains(t, logs.String(), "[dry-run] index.html")
+		assert.Contains(t, logs.String(), `<a href="https://github.com/getporter/porter/releases/download/v1.1.0/porter-linux-amd64">linux/amd64</a>`)
+		assert.Contains(t, logs.String(), `<a href="https://example.com/v1.0.0/porter-linux-amd64">linux/amd64</a>`)
+		assert.Equal(t, "Add v1.0.0", gitCommand(t, "--git-dir", remote, "log", "-1", "--format=%s", "gh-pages"), "the dry run should not push")
+	})
+
+	t.Run("new version", func(t *testing.T) {
+		require.NoError(t, updateDownloadIndex(info, opts))
+
+		index := gitCommand(t, "--git-dir", remote, "show", "gh-pages:index.html")
+		assert.Contains(t, index, `<h2 id="v1.1.0">v1.1.0</h2>`)
+		assert.Contains(t, index, `porter-windows-amd64.exe">windows/amd64</a>`)
+		assert.NotContains(t, index, ChecksumsFile)
+		assert.Less(t, strings.Index(index, "v1.1.0"), strings.Index(index, "v1.0.0"), "the highest version should be listed first")
+	})
+
+	t.Run("same version again", func(t *testing.T) {
+		logs := captureLogs(t)
+		before := gitCommand(t, "--git-dir", remote, "rev-parse", "gh-pages")
+
+		require.NoError(t, updateDownloadIndex(info, opts))
+		assert.Equal(t, before, gitCommand(t, "--git-dir", remote, "rev-parse", "gh-pages"), "an unchanged index should not be committed")
+		assert.Contains(t, logs.String(), "already lists v1.1.0")
+
+		index := gitCommand(t, "--git-dir", remote, "show", "gh-pages:index.html")
+		assert.Equal(t, 1, strings.Count(index, `<h2 id="v1.1.0">`))
+	})
+
+	t.Run("new branch", func(t *testing.T) {
+		emptyRemote := filepath.Join(t.TempDir(), "empty.git")
+		gitCommand(t, "init", "--bare", emptyRemote)
+		newOpts := opts
+		newOpts.Remote = emptyRemote
+		newOpts.Dir = "downloads"
+
+		require.NoError(t, updateDownloadIndex(info, newOpts))
+		index := gitCommand(t, "--git-dir", emptyRemote, "show", "gh-pages:downloads/index.html")
+		assert.Contains(t, index, `<h2 id="v1.1.0">v1.1.0</h2>`)
+	})
+
+	t.Run("not tagged", func(t *testing.T) {
+		canary := GitMetadata{Permalink: "canary", Version: "v1.1.0-3-gabc1234"}
+		err := updateDownloadIndex(canary, opts)
+		require.ErrorIs(t, err, ErrNotTagged)
+	})
+}
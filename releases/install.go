@@ -0,0 +1,238 @@
+package releases
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var (
+	// downloadAttempts is how many times a download is attempted when it fails with a transient error.
+	downloadAttempts = 3
+
+	// checksumAttempts is the default number of times a binary is downloaded
+	// when it doesn't match its checksum, e.g. because the download was truncated.
+	checksumAttempts = 3
+
+	// downloadRetryBackoff is how long to wait before the first retry of a download,
+	// subsequent retries wait proportionally longer.
+	downloadRetryBackoff = time.Second
+)
+
+// InstallOptions are the options for installing a released binary.
+type InstallOptions struct {
+	// Repository that the binary was released to, e.g. github.com/getporter/porter.
+	// Defaults to the PORTER_RELEASE_REPOSITORY environment variable.
+	Repository string
+
+	// BaseURL to download the artifacts from instead of the GitHub release,
+	// e.g. https://porter-canary.s3.amazonaws.com for artifacts published with PublishToBucket.
+	// The artifacts are downloaded from BASEURL/PERMALINK/FILENAME.
+	BaseURL string
+
+	// Name of the binary, e.g. porter. Defaults to the filename of the destination.
+	Name string
+
+	// ChecksumAttempts is how many times the binary is downloaded when it doesn't
+	// match the published checksum. Defaults to 3.
+	ChecksumAttempts int
+}
+
+// InstallRelease downloads the binary for the current platform that was
+// released under the permalink, e.g. canary, and writes it to dest.
+// The binary is verified against the published checksums.txt before it is installed,
+// and downloaded again when it doesn't match. A binary that still doesn't match
+// returns ErrChecksumMismatch, and a binary that doesn't exist returns ErrAssetNotFound.
+func InstallRelease(permalink string, dest string) error {
+	return InstallReleaseWith(permalink, dest, InstallOptions{})
+}
+
+// InstallReleaseWith downloads the binary for the current platform that was
+// released under the permalink, using the specified options.
+func InstallReleaseWith(permalink string, dest string, opts InstallOptions) error {
+	if opts.Name == "" {
+		opts.Name = strings.TrimSuffix(filepath.Base(dest), fileExt(runtime.GOOS))
+	}
+	if opts.ChecksumAttempts <= 0 {
+		opts.ChecksumAttempts = checksumAttempts
+	}
+
+	baseURL, err := getDownloadBaseURL(permalink, opts)
+	if err != nil {
+		return err
+	}
+	binaryName := BinaryName(opts.Name, Platform{OS: runtime.GOOS, Arch: runtime.GOARCH})
+
+	var checksums bytes.Buffer
+	if err := download(baseURL+"/"+ChecksumsFile, &checksums); err != nil {
+		return err
+	}
+	wantSum, err := findChecksum(checksums.String(), binaryName)
+	if err != nil {
+		return fmt.Errorf("could not verify %s: %w", binaryName, err)
+	}
+
+	// Download next to the destination so that it can be moved into place once it's verified
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating the directory for %s: %w", dest, err)
+	}
+	tmpPath, err := downloadVerified(baseURL+"/"+binaryName, filepath.Dir(dest), binaryName, wantSum, opts.ChecksumAttempts)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	if err := os.Chmod(tmpPath, 0755); err != nil {
+		return fmt.Errorf("error making %s executable: %w", dest, err)
+	}
+	if err := os.Rename(tmpPath, dest); err != nil {
+		return fmt.Errorf("error installing %s: %w", dest, err)
+	}
+	log.Printf("Installed %s %s to %s\n", opts.Name, permalink, dest)
+	return nil
+}
+
+// downloadVerified downloads the file to a temporary file in the directory and
+// returns its path once it matches the checksum. A download that doesn't match
+// is deleted and downloaded again, up to the number of attempts.
+func downloadVerified(url string, dir string, filename string, wantSum string, attempts int) (string, error) {
+	for i := 1; ; i++ {
+		tmp, err := os.CreateTemp(dir, "."+filename+"-*")
+		if err != nil {
+			return "", fmt.Errorf("error creating a temporary file for %s: %w", filename, err)
+		}
+
+		err = download(url, tmp)
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+			return "", err
+		}
+
+		gotSum, err := checksumFile(tmp.Name())
+		if err != nil {
+			os.Remove(tmp.Name())
+			return "", err
+		}
+		if gotSum == wantSum {
+			return tmp.Name(), nil
+		}
+
+		// Remove the corrupt download so that it can't be installed by mistake
+		os.Remove(tmp.Name())
+		if i >= attempts {
+			return "", fmt.Errorf("%w for %s after %d attempts: expected %s but got %s", ErrChecksumMismatch, filename, attempts, wantSum, gotSum)
+		}
+		log.Printf("checksum mismatch for %s: expected %s but got %s, downloading it again (%d/%d)\n", filename, wantSum, gotSum, i, attempts-1)
+		time.Sleep(time.Duration(i) * downloadRetryBackoff)
+	}
+}
+
+// getDownloadBaseURL returns the URL that the artifacts released under the permalink are downloaded from.
+func getDownloadBaseURL(permalink string, opts InstallOptions) (string, error) {
+	if opts.BaseURL != "" {
+		return strings.TrimSuffix(opts.BaseURL, "/") + "/" + permalink, nil
+	}
+
+	repo := opts.Repository
+	if repo == "" {
+		repo = os.Getenv(ReleaseRepository)
+	}
+	if repo == "" {
+		return "", fmt.Errorf("no release repository specified, set %s to github.com/USERNAME/REPO", ReleaseRepository)
+	}
+	return fmt.Sprintf("https://%s/releases/download/%s", repo, permalink), nil
+}
+
+// findChecksum returns the checksum of the file listed in the contents of a checksums file,
+// which uses the same format as sha256sum.
+func findChecksum(checksums string, filename string) (string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == filename {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("%s is not listed in %s", filename, ChecksumsFile)
+}
+
+// download writes the contents of the URL to the writer, retrying network and
+// server errors. Anything written by a failed attempt is discarded before retrying.
+func download(url string, w io.Writer) error {
+	for i := 1; ; i++ {
+		err := downloadOnce(url, w)
+		var transient transientDownloadError
+		if err == nil || !errors.As(err, &transient) || i >= downloadAttempts {
+			return err
+		}
+
+		log.Printf("%s, retrying (%d/%d)\n", err, i, downloadAttempts-1)
+		if err := resetDownload(w); err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(i) * downloadRetryBackoff)
+	}
+}
+
+// transientDownloadError is an error that may succeed when the download is retried.
+type transientDownloadError struct {
+	err error
+}
+
+func (e transientDownloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientDownloadError) Unwrap() error {
+	return e.err
+}
+
+func downloadOnce(url string, w io.Writer) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return transientDownloadError{fmt.Errorf("error downloading %s: %w", url, err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("error downloading %s: %s: %w", url, resp.Status, ErrAssetNotFound)
+		}
+		err := fmt.Errorf("error downloading %s: %s", url, resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return transientDownloadError{err}
+		}
+		return err
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return transientDownloadError{fmt.Errorf("error downloading %s: %w", url, err)}
+	}
+	return nil
+}
+
+// resetDownload discards anything written by a failed download before it's retried.
+func resetDownload(w io.Writer) error {
+	switch w := w.(type) {
+	case *bytes.Buffer:
+		w.Reset()
+	case *os.File:
+		if err := w.Truncate(0); err != nil {
+			return fmt.Errorf("error resetting %s: %w", w.Name(), err)
+		}
+		if _, err := w.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error resetting %s: %w", w.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package releases
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallRelease(t *testing.T) {
+	origBackoff := downloadRetryBackoff
+	downloadRetryBackoff = time.Millisecond
+	t.Cleanup(func() { downloadRetryBackoff = origBackoff })
+
+	binaryName := BinaryName("porter", Platform{OS: runtime.GOOS, Arch: runtime.GOARCH})
+	binary := []byte("#!/bin/sh\necho canary\n")
+	sum := sha256.Sum256(binary)
+
+	// useServer serves the canary release, and returns how many times the binary was requested
+	useServer := func(t *testing.T, checksums string, failures int32) (string, *int32) {
+		var requests int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/canary/" + ChecksumsFile:
+				fmt.Fprint(w, checksums)
+			case "/canary/" + binaryName:
+				if atomic.AddInt32(&requests, 1) <= failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write(binary)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL, &requests
+	}
+	validChecksums := fmt.Sprintf("%s  %s\n%s  porter-other-arch\n", hex.EncodeToString(sum[:]), binaryName, hex.EncodeToString(sum[:]))
+
+	t.Run("verified", func(t *testing.T) {
+		baseURL, _ := useServer(t, validChecksums, 0)
+		dest := filepath.Join(t.TempDir(), "bin", "porter")
+
+		require.NoError(t, InstallReleaseWith("canary", dest, InstallOptions{BaseURL: baseURL}))
+
+		got, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, binary, got)
+		info, err := os.Stat(dest)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm(), "the binary should be executable")
+	})
+
+	t.Run("transient error", func(t *testing.T) {
+		captureLogs(t)
+		baseURL, requests := useServer(t, validChecksums, 1)
+		dest := filepath.Join(t.TempDir(), "porter")
+
+		require.NoError(t, InstallReleaseWith("canary", dest, InstallOptions{BaseURL: baseURL}))
+		assert.Equal(t, int32(2), *requests, "the download should be retried")
+		assert.FileExists(t, dest)
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		captureLogs(t)
+		baseURL, requests := useServer(t, fmt.Sprintf("%064d  %s\n", 0, binaryName), 0)
+		dir := t.TempDir()
+		dest := filepath.Join(dir, "porter")
+
+		err := InstallReleaseWith("canary", dest, InstallOptions{BaseURL: baseURL, ChecksumAttempts: 2})
+		require.ErrorIs(t, err, ErrChecksumMismatch)
+		require.ErrorContains(t, err, "checksum mismatch for "+binaryName+" after 2 attempts")
+		assert.Equal(t, int32(2), *requests, "the binary should be downloaded again")
+		assert.NoFileExists(t, dest)
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries, "the download should be removed")
+	})
+
+	t.Run("corrupt download", func(t *testing.T) {
+		logs := captureLogs(t)
+		var requests int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/canary/" + ChecksumsFile:
+				fmt.Fprint(w, validChecksums)
+			case "/canary/" + binaryName:
+				// Truncate the first download
+				if atomic.AddInt32(&requests, 1) == 1 {
+					w.Write(binary[:5])
+					return
+				}
+				w.Write(binary)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		dir := t.TempDir()
+		dest := filepath.Join(dir, "porter")
+
+		require.NoError(t, InstallReleaseWith("canary", dest, InstallOptions{BaseURL: srv.URL}))
+		assert.Equal(t, int32(2), requests, "the corrupt download should be downloaded again")
+		got, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, binary, got)
+		entries, _ := os.ReadDir(dir)
+		assert.Len(t, entries, 1, "the corrupt download should be removed")
+		assert.Contains(t, logs.String(), "downloading it again (1/2)")
+	})
+
+	t.Run("not listed in checksums", func(t *testing.T) {
+		baseURL, requests := useServer(t, "", 0)
+
+		err := InstallReleaseWith("canary", filepath.Join(t.TempDir(), "porter"), InstallOptions{BaseURL: baseURL})
+		require.ErrorContains(t, err, binaryName+" is not listed in "+ChecksumsFile)
+		assert.Equal(t, int32(0), *requests, "the binary should not be downloaded")
+	})
+
+	t.Run("permalink not found", func(t *testing.T) {
+		baseURL, _ := useServer(t, validChecksums, 0)
+
+		err := InstallReleaseWith("latest", filepath.Join(t.TempDir(), "porter"), InstallOptions{BaseURL: baseURL})
+		require.ErrorIs(t, err, ErrAssetNotFound)
+		require.ErrorContains(t, err, "404 Not Found")
+		assert.NotErrorIs(t, err, ErrChecksumMismatch)
+	})
+}
+
+func TestGetDownloadBaseURL(t *testing.T) {
+	t.Setenv(ReleaseRepository, "github.com/getporter/porter")
+
+	url, err := getDownloadBaseURL("canary", InstallOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://github.com/getporter/porter/releases/download/canary", url)
+
+	url, err = getDownloadBaseURL("canary", InstallOptions{BaseURL: "https://porter-canary.s3.amazonaws.com/"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://porter-canary.s3.amazonaws.com/canary", url)
+
+	t.Setenv(ReleaseRepository, "")
+	_, err = getDownloadBaseURL("canary", InstallOptions{})
+	require.ErrorContains(t, err, "no release repository specified")
+}
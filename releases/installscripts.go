@@ -0,0 +1,114 @@
+package releases
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var (
+	//go:embed install/install.sh.tmpl
+	templateInstallSh string
+
+	//go:embed install/install.ps1.tmpl
+	templateInstallPs1 string
+)
+
+// InstallScriptOptions are the options for generating install scripts.
+type InstallScriptOptions struct {
+	// Repository that the binary is released to, e.g. github.com/getporter/porter.
+	// Defaults to the PORTER_RELEASE_REPOSITORY environment variable.
+	Repository string
+
+	// BaseURL to download the artifacts from instead of the GitHub release,
+	// e.g. https://porter-canary.s3.amazonaws.com. The artifacts are
+	// downloaded from BASEURL/PERMALINK/FILENAME.
+	BaseURL string
+
+	// Name of the binary, e.g. porter. Defaults to the name of the repository.
+	Name string
+
+	// Permalink that the scripts install from, e.g. canary. Defaults to the permalink of the current build.
+	Permalink string
+}
+
+// installScriptData is passed to the install script templates.
+type installScriptData struct {
+	Repository    string
+	Name          string
+	Permalink     string
+	BaseURL       string
+	ChecksumsFile string
+}
+
+// GenerateInstallScripts writes install.sh and install.ps1 to the output
+// directory, which download the binary for the user's platform from the
+// permalink of the current build, verify it against checksums.txt, and install it.
+func GenerateInstallScripts(outDir string) error {
+	return GenerateInstallScriptsWith(outDir, InstallScriptOptions{})
+}
+
+// GenerateInstallScriptsWith writes install.sh and install.ps1 to the output
+// directory, using the specified options.
+func GenerateInstallScriptsWith(outDir string, opts InstallScriptOptions) error {
+	if opts.Permalink == "" {
+		opts.Permalink = LoadMetadata().Permalink
+	}
+	return generateInstallScripts(outDir, opts)
+}
+
+func generateInstallScripts(outDir string, opts InstallScriptOptions) error {
+	if opts.Repository == "" {
+		opts.Repository = os.Getenv(ReleaseRepository)
+	}
+	if opts.Name == "" && opts.Repository != "" {
+		opts.Name = path.Base(opts.Repository)
+	}
+	if opts.Name == "" {
+		return fmt.Errorf("the name of the binary is required when the repository isn't set")
+	}
+
+	baseURL, err := getDownloadBaseURL(opts.Permalink, InstallOptions{Repository: opts.Repository, BaseURL: opts.BaseURL})
+	if err != nil {
+		return err
+	}
+	data := installScriptData{
+		Repository:    opts.Repository,
+		Name:          opts.Name,
+		Permalink:     opts.Permalink,
+		BaseURL:       baseURL,
+		ChecksumsFile: ChecksumsFile,
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("error creating the install scripts directory %s: %w", outDir, err)
+	}
+	scripts := []struct {
+		name     string
+		template string
+	}{
+		{"install.sh", templateInstallSh},
+		{"install.ps1", templateInstallPs1},
+	}
+	for _, script := range scripts {
+		tmpl, err := template.New(script.name).Parse(script.template)
+		if err != nil {
+			return fmt.Errorf("error parsing the %s template: %w", script.name, err)
+		}
+
+		var contents strings.Builder
+		if err := tmpl.Execute(&contents, data); err != nil {
+			return fmt.Errorf("error rendering %s: %w", script.name, err)
+		}
+
+		scriptPath := filepath.Join(outDir, script.name)
+		if err := os.WriteFile(scriptPath, []byte(contents.String()), 0755); err != nil {
+			return fmt.Errorf("error writing %s: %w", scriptPath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateInstallScripts(t *testing.T) {
+	outDir := t.TempDir()
+	opts := InstallScriptOptions{Repository: "github.com/getporter/porter", Permalink: "canary"}
+	require.NoError(t, generateInstallScripts(outDir, opts))
+
+	installSh, err := os.ReadFile(filepath.Join(outDir, "install.sh"))
+	require.NoError(t, err)
+	assert.Contains(t, string(installSh), `BASE_URL="https://github.com/getporter/porter/releases/download/canary"`)
+	assert.Contains(t, string(installSh), `NAME="porter"`)
+	assert.Contains(t, string(installSh), `"${BASE_URL}/checksums.txt"`)
+
+	installPs1, err := os.ReadFile(filepath.Join(outDir, "install.ps1"))
+	require.NoError(t, err)
+	assert.Contains(t, string(installPs1), `$BaseUrl = 'https://github.com/getporter/porter/releases/download/canary'`)
+	assert.Contains(t, string(installPs1), `$Binary = "$Name-windows-$Arch.exe"`)
+
+	t.Run("reproducible", func(t *testing.T) {
+		againDir := t.TempDir()
+		require.NoError(t, generateInstallScripts(againDir, opts))
+
+		for _, script := range []string{"install.sh", "install.ps1"} {
+			want, _ := os.ReadFile(filepath.Join(outDir, script))
+			got, err := os.ReadFile(filepath.Join(againDir, script))
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got), "%s should be identical for the same inputs", script)
+		}
+	})
+
+	t.Run("bucket", func(t *testing.T) {
+		outDir := t.TempDir()
+		opts := InstallScriptOptions{BaseURL: "https://porter-canary.s3.amazonaws.com", Name: "porter", Permalink: "canary"}
+		require.NoError(t, generateInstallScripts(outDir, opts))
+
+		installSh, err := os.ReadFile(filepath.Join(outDir, "install.sh"))
+		require.NoError(t, err)
+		assert.Contains(t, string(installSh), `BASE_URL="https://porter-canary.s3.amazonaws.com/canary"`)
+	})
+}
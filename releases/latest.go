@@ -0,0 +1,99 @@
+package releases
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// LatestPointerOptions are the options for writing the latest.json pointer file with WriteLatestPointerWith.
+type LatestPointerOptions struct {
+	// ArtifactsDir is the directory containing the released binaries, named
+	// NAME-GOOS-GOARCH, e.g. bin/porter-linux-amd64. Defaults to OutputDir.
+	ArtifactsDir string
+
+	// Repository is the GitHub repository that the release was published to, e.g. github.com/getporter/porter.
+	// Defaults to the value of PORTER_RELEASE_REPOSITORY.
+	Repository string
+
+	// BaseURL to download the artifacts from instead of the GitHub release,
+	// e.g. https://cdn.porter.sh. The binaries are downloaded from BASEURL/PERMALINK/FILENAME,
+	// e.g. https://cdn.porter.sh/latest/porter-linux-amd64, matching the layout of PublishToBucket.
+	BaseURL string
+}
+
+// latestPointer is the contents of latest.json, which a static download site
+// uses to redirect to the binaries of the latest release.
+type latestPointer struct {
+	// Version of the latest release, e.g. v1.2.3.
+	Version string `json:"version"`
+
+	// Downloads are the download links of the binaries, keyed by platform, e.g. linux/amd64.
+	Downloads map[string]string `json:"downloads"`
+}
+
+// WriteLatestPointer writes a JSON file to outPath, e.g. bin/latest.json,
+// with the version of the latest release and the download URL of the binary
+// for each platform, so that a static site can redirect to the latest release.
+// Upload it to the root of the bucket after publishing. It's only written for
+// stable releases published to the latest permalink, and skipped otherwise.
+func WriteLatestPointer(outPath string) error {
+	return WriteLatestPointerWith(outPath, LatestPointerOptions{})
+}
+
+// WriteLatestPointerWith writes latest.json to outPath, using the specified options.
+func WriteLatestPointerWith(outPath string, opts LatestPointerOptions) error {
+	return writeLatestPointer(LoadMetadata(), outPath, opts)
+}
+
+func writeLatestPointer(info GitMetadata, outPath string, opts LatestPointerOptions) error {
+	if !info.IsTaggedRelease || info.IsPrerelease || info.Permalink != Permalinks.TaggedAlias || !info.ShouldPublishPermalink() {
+		log.Printf("Skipping the latest pointer for %s, it's only written for releases published to %s\n", info.Version, Permalinks.TaggedAlias)
+		return nil
+	}
+	if opts.ArtifactsDir == "" {
+		opts.ArtifactsDir = OutputDir
+	}
+
+	// PublishToBucket uploads the binaries under the permalink, while the GitHub release is named after the tag
+	release := getReleaseTag(info)
+	if opts.BaseURL != "" {
+		release = info.Permalink
+	}
+	baseURL, err := getDownloadBaseURL(release, InstallOptions{Repository: opts.Repository, BaseURL: opts.BaseURL})
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(opts.ArtifactsDir)
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", opts.ArtifactsDir, err)
+	}
+
+	pointer := latestPointer{Version: info.Version, Downloads: map[string]string{}}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		platform, ok := parseBinaryPlatform(entry.Name())
+		if entry.IsDir() || !ok || (ext != "" && ext != ".exe") {
+			continue
+		}
+		pointer.Downloads[platform.String()] = baseURL + "/" + entry.Name()
+	}
+	if len(pointer.Downloads) == 0 {
+		return fmt.Errorf("no binaries were found in %s for the latest pointer", opts.ArtifactsDir)
+	}
+
+	data, err := json.MarshalIndent(pointer, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing the latest pointer: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("error creating the directory for %s: %w", outPath, err)
+	}
+	if err := os.WriteFile(outPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing the latest pointer %s: %w", outPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteLatestPointer(t *testing.T) {
+	artifactsDir := t.TempDir()
+	for _, name := range []string{"porter-linux-amd64", "porter-darwin-arm64", "porter-windows-amd64.exe", ChecksumsFile, "porter-v1.2.3-linux-amd64.tar.gz"} {
+		require.NoError(t, os.WriteFile(filepath.Join(artifactsDir, name), []byte("porter"), 0644))
+	}
+	opts := LatestPointerOptions{ArtifactsDir: artifactsDir, Repository: "github.com/getporter/porter"}
+
+	t.Run("latest release", func(t *testing.T) {
+		outPath := filepath.Join(t.TempDir(), "latest.json")
+		info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+
+		require.NoError(t, writeLatestPointer(info, outPath, opts))
+		data, err := os.ReadFile(outPath)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+  "version": "v1.2.3",
+  "downloads": {
+    "darwin/arm64": "https://github.com/getporter/porter/releases/download/v1.2.3/porter-darwin-arm64",
+    "linux/amd64": "https://github.com/getporter/porter/releases/download/v1.2.3/porter-linux-amd64",
+    "windows/amd64": "https://github.com/getporter/porter/releases/download/v1.2.3/porter-windows-amd64.exe"
+  }
+}`, string(data))
+	})
+
+	t.Run("base url", func(t *testing.T) {
+		outPath := filepath.Join(t.TempDir(), "latest.json")
+		info := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+
+		require.NoError(t, writeLatestPointer(info, outPath, LatestPointerOptions{ArtifactsDir: artifactsDir, BaseURL: "https://cdn.porter.sh/"}))
+		data, err := os.ReadFile(outPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"linux/amd64": "https://cdn.porter.sh/latest/porter-linux-amd64"`,
+			"the binaries should be downloaded from the permalink that PublishToBucket uploads them to")
+	})
+
+	t.Run("other permalinks are skipped", func(t *testing.T) {
+		for _, info := range []GitMetadata{
+			{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"},
+			{Permalink: "preview", Version: "v1.3.0-rc.1", IsTaggedRelease: true, IsPrerelease: true},
+			{Permalink: "latest-v1", Version: "v1.2.3", IsTaggedRelease: true},
+		} {
+			outPath := filepath.Join(t.TempDir(), "latest.json")
+			require.NoError(t, writeLatestPointer(info, outPath, opts))
+			assert.NoFileExists(t, outPath, "the latest pointer should not be written for %s", info.Permalink)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package releases
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// VerboseLogging is the environment variable that controls if the metadata
+// loaded by LoadMetadata is logged. Defaults to true.
+const VerboseLogging = "PORTER_MAGE_VERBOSE"
+
+var (
+	// Logger receives the metadata logged by LoadMetadata. Defaults to slog.Default,
+	// set it to redirect the output.
+	Logger *slog.Logger
+
+	verboseOverride *bool
+	verboseMu       sync.Mutex
+)
+
+// SetVerbose overrides if the metadata loaded by LoadMetadata is logged,
+// taking precedence over the PORTER_MAGE_VERBOSE environment variable.
+func SetVerbose(verbose bool) {
+	verboseMu.Lock()
+	defer verboseMu.Unlock()
+	verboseOverride = &verbose
+}
+
+// IsVerbose determines if the metadata loaded by LoadMetadata is logged.
+func IsVerbose() bool {
+	verboseMu.Lock()
+	defer verboseMu.Unlock()
+	if verboseOverride != nil {
+		return *verboseOverride
+	}
+
+	if verbose, err := strconv.ParseBool(os.Getenv(VerboseLogging)); err == nil {
+		return verbose
+	}
+	return true
+}
+
+func getLogger() *slog.Logger {
+	if Logger != nil {
+		return Logger
+	}
+	return slog.Default()
+}
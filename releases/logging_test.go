@@ -0,0 +1,61 @@
+package releases
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMetadata_Verbose(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv(VersionOverride, "v1.2.3")
+	t.Setenv(CommitOverride, "8252b6e")
+
+	// useLogger redirects the logs, and recomputes the metadata the next time it's loaded
+	useLogger := func(t *testing.T) *bytes.Buffer {
+		var logs bytes.Buffer
+		Logger = slog.New(slog.NewTextHandler(&logs, nil))
+		loadMetadata = sync.Once{}
+		t.Cleanup(func() {
+			Logger = nil
+			verboseOverride = nil
+			loadMetadata = sync.Once{}
+			gitMetadata = GitMetadata{}
+		})
+		return &logs
+	}
+
+	t.Run("default", func(t *testing.T) {
+		logs := useLogger(t)
+
+		LoadMetadata()
+		assert.Contains(t, logs.String(), `msg="Loaded git metadata" taggedRelease=true prerelease=false permalink=latest version=v1.2.3 commit=8252b6e`)
+	})
+
+	t.Run("env var", func(t *testing.T) {
+		logs := useLogger(t)
+		t.Setenv(VerboseLogging, "false")
+
+		LoadMetadata()
+		assert.Empty(t, logs.String())
+	})
+
+	t.Run("toggled between calls", func(t *testing.T) {
+		logs := useLogger(t)
+		t.Setenv(VerboseLogging, "true")
+		SetVerbose(false)
+
+		m := LoadMetadata()
+		assert.Empty(t, logs.String(), "SetVerbose should take precedence over the environment variable")
+
+		SetVerbose(true)
+		t.Setenv(VersionOverride, "v9.9.9")
+		m2 := LoadMetadata()
+		assert.Equal(t, m, m2, "the metadata should only be computed once")
+		assert.Empty(t, logs.String(), "the metadata is only logged when it's computed")
+	})
+}
@@ -0,0 +1,139 @@
+package releases
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// MixinManifestOptions are the options for adding a release to a mixin manifest.
+type MixinManifestOptions struct {
+	// ManifestPath is the path to the mixin manifest. Defaults to mixin.json.
+	ManifestPath string
+
+	// Name of the mixin, e.g. helm.
+	Name string
+
+	// Version that was published, e.g. v1.2.3.
+	Version string
+
+	// Permalink that was published, e.g. canary. Builds that are not a tagged
+	// release are added to the manifest under their permalink instead of their version.
+	Permalink string
+
+	// BaseURL that the mixins are downloaded from, e.g. https://cdn.porter.sh/mixins.
+	// The binaries are downloaded from BASEURL/NAME/VERSION/NAME-GOOS-GOARCH.
+	BaseURL string
+
+	// Platforms that the mixin was built for. Defaults to DefaultPlatforms.
+	Platforms []Platform
+}
+
+// mixinManifest lists the versions of a mixin that can be installed,
+// and where to download the binary for each platform.
+type mixinManifest struct {
+	Name     string                 `json:"name"`
+	Versions []mixinManifestVersion `json:"versions"`
+}
+
+type mixinManifestVersion struct {
+	Version string `json:"version"`
+
+	// URLs are the download links of the binaries, keyed by GOOS and then GOARCH,
+	// followed by the variant when the platform has one, e.g. armv7.
+	URLs map[string]map[string]string `json:"urls"`
+}
+
+// GenerateMixinManifest adds the release to the mixin manifest, mixin.json,
+// that lists the versions which `porter mixin install` can install, creating
+// the manifest when it doesn't exist. Versions are identified by their version,
+// so running it again for the same version replaces its entry. Permalinks,
+// e.g. canary, are listed first, followed by the versions, highest first.
+func GenerateMixinManifest(opts MixinManifestOptions) error {
+	if opts.ManifestPath == "" {
+		opts.ManifestPath = "mixin.json"
+	}
+	if len(opts.Platforms) == 0 {
+		opts.Platforms = DefaultPlatforms
+	}
+	if opts.Name == "" || opts.Version == "" {
+		return fmt.Errorf("the name and version of the release are required to generate the mixin manifest")
+	}
+	if opts.BaseURL == "" {
+		return fmt.Errorf("no base URL was specified for %s@%s", opts.Name, opts.Version)
+	}
+
+	manifest := mixinManifest{Name: opts.Name}
+	if data, err := os.ReadFile(opts.ManifestPath); err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("error parsing the mixin manifest %s: %w", opts.ManifestPath, err)
+		}
+		if manifest.Name != opts.Name {
+			return fmt.Errorf("the mixin manifest %s is for the %s mixin, not %s", opts.ManifestPath, manifest.Name, opts.Name)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading the mixin manifest %s: %w", opts.ManifestPath, err)
+	}
+
+	addManifestVersion(&manifest, opts)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing the mixin manifest: %w", err)
+	}
+	if err := os.WriteFile(opts.ManifestPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing the mixin manifest %s: %w", opts.ManifestPath, err)
+	}
+	return nil
+}
+
+// addManifestVersion adds or replaces the version of the release in the manifest.
+func addManifestVersion(manifest *mixinManifest, opts MixinManifestOptions) {
+	// Untagged builds replace the previous build for their permalink, e.g. canary
+	version := opts.Version
+	if opts.Permalink != "" && !releaseVersion.MatchString(version) {
+		version = opts.Permalink
+	}
+
+	entry := mixinManifestVersion{Version: version, URLs: map[string]map[string]string{}}
+	baseURL := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(opts.BaseURL, "/"), opts.Name, version)
+	for _, platform := range opts.Platforms {
+		if entry.URLs[platform.OS] == nil {
+			entry.URLs[platform.OS] = map[string]string{}
+		}
+		// Key variants by the architecture of their binary, e.g. armv7, so that arm/v6 and arm/v7 don't replace each other
+		entry.URLs[platform.OS][platform.binaryArch()] = baseURL + "/" + BinaryName(opts.Name, platform)
+	}
+
+	versions := []mixinManifestVersion{entry}
+	for _, existing := range manifest.Versions {
+		if existing.Version != entry.Version {
+			versions = append(versions, existing)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return manifestVersionLess(versions[j].Version, versions[i].Version)
+	})
+	manifest.Versions = versions
+}
+
+// manifestVersionLess sorts permalinks, such as canary, after the versions,
+// and the versions by semver, so that the manifest lists them in reverse.
+func manifestVersionLess(a string, b string) bool {
+	aVersion, aErr := semver.NewVersion(a)
+	bVersion, bErr := semver.NewVersion(b)
+	switch {
+	case aErr != nil && bErr != nil:
+		return a > b
+	case aErr != nil:
+		return false
+	case bErr != nil:
+		return true
+	default:
+		return aVersion.LessThan(bVersion)
+	}
+}
@@ -0,0 +1,109 @@
+package releases
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateMixinManifest(t *testing.T) {
+	manifestPath := filepath.Join(t.TempDir(), "mixin.json")
+	existing := `{
+  "name": "helm",
+  "versions": [
+    {"version": "canary", "urls": {"linux": {"amd64": "https://cdn.porter.sh/mixins/helm/canary/helm-linux-amd64"}}},
+    {"version": "v1.2.0", "urls": {"linux": {"amd64": "https://cdn.porter.sh/mixins/helm/v1.2.0/helm-linux-amd64"}}},
+    {"version": "v1.10.0", "urls": {"linux": {"amd64": "https://cdn.porter.sh/mixins/helm/v1.10.0/helm-linux-amd64"}}}
+  ]
+}`
+	require.NoError(t, os.WriteFile(manifestPath, []byte(existing), 0644))
+
+	readManifest := func(t *testing.T) mixinManifest {
+		data, err := os.ReadFile(manifestPath)
+		require.NoError(t, err)
+		var manifest mixinManifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		return manifest
+	}
+	listVersions := func(manifest mixinManifest) []string {
+		var versions []string
+		for _, v := range manifest.Versions {
+			versions = append(versions, v.Version)
+		}
+		return versions
+	}
+	opts := MixinManifestOptions{
+		ManifestPath: manifestPath,
+		Name:         "helm",
+		Version:      "v1.9.1",
+		BaseURL:      "https://cdn.porter.sh/mixins/",
+		Platforms:    []Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}, {OS: "windows", Arch: "amd64"}},
+	}
+
+	t.Run("merge a new version", func(t *testing.T) {
+		require.NoError(t, GenerateMixinManifest(opts))
+
+		manifest := readManifest(t)
+		assert.Equal(t, "helm", manifest.Name)
+		assert.Equal(t, []string{"canary", "v1.10.0", "v1.9.1", "v1.2.0"}, listVersions(manifest), "the versions should be sorted highest first")
+		assert.Equal(t, map[string]map[string]string{
+			"linux": {
+				"amd64": "https://cdn.porter.sh/mixins/helm/v1.9.1/helm-linux-amd64",
+				"arm64": "https://cdn.porter.sh/mixins/helm/v1.9.1/helm-linux-arm64",
+			},
+			"windows": {"amd64": "https://cdn.porter.sh/mixins/helm/v1.9.1/helm-windows-amd64.exe"},
+		}, manifest.Versions[2].URLs)
+	})
+
+	t.Run("arm variants", func(t *testing.T) {
+		opts := opts
+		opts.ManifestPath = filepath.Join(t.TempDir(), "mixin.json")
+		opts.Platforms = []Platform{{OS: "linux", Arch: "arm", Variant: "v6"}, {OS: "linux", Arch: "arm", Variant: "v7"}}
+		require.NoError(t, GenerateMixinManifest(opts))
+
+		data, err := os.ReadFile(opts.ManifestPath)
+		require.NoError(t, err)
+		var manifest mixinManifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		require.Len(t, manifest.Versions, 1)
+		assert.Equal(t, map[string]map[string]string{
+			"linux": {
+				"armv6": "https://cdn.porter.sh/mixins/helm/v1.9.1/helm-linux-armv6",
+				"armv7": "https://cdn.porter.sh/mixins/helm/v1.9.1/helm-linux-armv7",
+			},
+		}, manifest.Versions[0].URLs, "each variant should have its own download link")
+	})
+
+	t.Run("idempotent", func(t *testing.T) {
+		before, err := os.ReadFile(manifestPath)
+		require.NoError(t, err)
+
+		require.NoError(t, GenerateMixinManifest(opts))
+		after, err := os.ReadFile(manifestPath)
+		require.NoError(t, err)
+		assert.Equal(t, string(before), string(after), "adding the same version again should not change the manifest")
+	})
+
+	t.Run("canary replaces the previous build", func(t *testing.T) {
+		canary := opts
+		canary.Version = "v1.10.0-3-g8252b6e"
+		canary.Permalink = "canary"
+		require.NoError(t, GenerateMixinManifest(canary))
+
+		manifest := readManifest(t)
+		assert.Equal(t, []string{"canary", "v1.10.0", "v1.9.1", "v1.2.0"}, listVersions(manifest), "the permalink should not be duplicated")
+		assert.Equal(t, "https://cdn.porter.sh/mixins/helm/canary/helm-linux-arm64", manifest.Versions[0].URLs["linux"]["arm64"])
+	})
+
+	t.Run("different mixin", func(t *testing.T) {
+		other := opts
+		other.Name = "kubernetes"
+
+		err := GenerateMixinManifest(other)
+		require.ErrorContains(t, err, "is for the helm mixin, not kubernetes")
+	})
+}
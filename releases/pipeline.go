@@ -0,0 +1,255 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"get.porter.sh/magefiles/tools"
+	"github.com/carolynvs/magex/shx"
+)
+
+// PipelineOptions are the options for running every stage of a release with ReleaseWith.
+type PipelineOptions struct {
+	// Build are the options for cross-compiling the binaries. Pkg defaults to
+	// the module in the current directory, and Name to the last element of Pkg.
+	Build BuildOptions
+
+	// Tools that must be installed before the release starts. Defaults to
+	// gh, along with cosign when the artifacts are signed.
+	Tools []tools.Tool
+
+	// Clean are the options for removing the artifacts of a previous build.
+	Clean CleanOptions
+
+	// ArchiveDir is the directory where the binaries are archived, and which is
+	// published. Defaults to dist.
+	ArchiveDir string
+
+	// Sign the artifacts with cosign when they are published. Signing is skipped when nil.
+	// Defaults to Publish.Sign.
+	Sign *SignOptions
+
+	// Publish are the options for publishing the release. ArtifactsDir defaults to ArchiveDir.
+	Publish ReleaseOptions
+
+	// SkipTools skips checking that the required tools are installed.
+	SkipTools bool
+
+	// SkipClean skips removing the artifacts of a previous build.
+	SkipClean bool
+
+	// SkipBuild skips cross-compiling the binaries, e.g. when they were built by a previous step of the CI pipeline.
+	SkipBuild bool
+
+	// SkipArchive skips archiving the binaries, so that the archive directory is published as-is.
+	SkipArchive bool
+
+	// SkipChecksums skips generating checksums.txt after archiving. It is still generated when the release is published.
+	SkipChecksums bool
+
+	// SkipPublish skips publishing the release, and verifying it.
+	SkipPublish bool
+
+	// SkipVerify skips checking that every artifact was attached to the published release.
+	SkipVerify bool
+
+	// DryRun logs the commands that would be run by the stages that clean
+	// or publish, without executing them. Verifying the release is skipped.
+	DryRun bool
+}
+
+// Release runs every stage of a release in order: checking the required tools,
+// cleaning, cross-compiling the binaries, archiving them, generating checksums,
+// publishing the release, or the permalink for canary builds, and verifying the
+// published release. The artifacts are signed by the publish stage, once every
+// release file has been generated. The release stops at the first stage that fails.
+// A tagged release that was already published with every archive is skipped
+// without rebuilding, unless Publish.Force is set.
+func Release() error {
+	return ReleaseWith(PipelineOptions{})
+}
+
+// ReleaseWith runs the stages of a release using the specified options.
+// Each stage can be skipped so that the release can be composed with other steps.
+func ReleaseWith(opts PipelineOptions) error {
+	if opts.ArchiveDir == "" {
+		opts.ArchiveDir = "dist"
+	}
+	if opts.Build.OutputDir == "" {
+		opts.Build.OutputDir = OutputDir
+	}
+	if opts.Publish.ArtifactsDir == "" {
+		opts.Publish.ArtifactsDir = opts.ArchiveDir
+	}
+	// The artifacts are signed when they are published, after every release file,
+	// such as checksums.txt and the SBOMs, has been generated, so the signatures match what is uploaded
+	if opts.Sign == nil {
+		opts.Sign = opts.Publish.Sign
+	}
+	opts.Publish.Sign = opts.Sign
+	opts.Publish.DryRun = opts.Publish.DryRun || opts.DryRun
+	opts.Clean.DryRun = opts.Clean.DryRun || opts.DryRun
+
+	// Resolve the repository once, so that every stage uses the same one, e.g. when it's detected from the remote
+	if !opts.SkipPublish {
+		repo, err := resolveReleaseRepository(opts.Publish.Repository)
+		if err != nil {
+			return err
+		}
+		opts.Publish.Repository = repo
+	}
+
+	// Don't rebuild a release that was already published, e.g. when the release is triggered twice for the same tag
+	if !opts.SkipPublish && !opts.Publish.Force && isPipelineReleaseComplete(LoadMetadata(), opts) {
+		return nil
+	}
+
+	stages := []struct {
+		name string
+		skip bool
+		run  func() error
+	}{
+		{"tools", opts.SkipTools, func() error { return tools.EnsureTools(getPipelineTools(opts)...) }},
+		{"clean", opts.SkipClean, func() error { return CleanWith(opts.Clean) }},
+		{"build", opts.SkipBuild, func() error { return buildPipelineBinaries(opts.Build) }},
+		{"archive", opts.SkipArchive, func() error { return Archive(opts.Build.OutputDir, opts.ArchiveDir) }},
+		{"checksums", opts.SkipChecksums, func() error {
+			return GenerateChecksums(opts.ArchiveDir, filepath.Join(opts.ArchiveDir, ChecksumsFile))
+		}},
+		{"publish", opts.SkipPublish, func() error { return PublishRelease(opts.Publish) }},
+		{"verify", opts.SkipPublish || opts.SkipVerify, func() error { return verifyPipelineRelease(LoadMetadata(), opts) }},
+	}
+	for _, stage := range stages {
+		if stage.skip {
+			log.Printf("Skipping the %s stage of the release\n", stage.name)
+			continue
+		}
+
+		log.Printf("Running the %s stage of the release\n", stage.name)
+		if err := stage.run(); err != nil {
+			return fmt.Errorf("the release failed at the %s stage: %w", stage.name, err)
+		}
+	}
+	return nil
+}
+
+// getPipelineTools returns the tools that must be installed for the release.
+func getPipelineTools(opts PipelineOptions) []tools.Tool {
+	if len(opts.Tools) > 0 {
+		return opts.Tools
+	}
+
+	required := []tools.Tool{tools.GitHubClientTool}
+	if opts.Sign != nil {
+		required = append(required, tools.CosignTool)
+	}
+	return required
+}
+
+// buildPipelineBinaries cross-compiles the binaries, defaulting the package to the current module.
+func buildPipelineBinaries(opts BuildOptions) error {
+	opts, err := resolvePipelineBuild(opts)
+	if err != nil {
+		return err
+	}
+	return XBuildAllWith(opts)
+}
+
+// resolvePipelineBuild defaults the package of the build to the module in the
+// current directory, and the name of the binary to the last element of the package.
+func resolvePipelineBuild(opts BuildOptions) (BuildOptions, error) {
+	if opts.Pkg == "" {
+		pkg, err := shx.OutputE("go", "list", "-m")
+		if err != nil {
+			return BuildOptions{}, fmt.Errorf("could not determine the Go module in the current directory: %w", err)
+		}
+		opts.Pkg = pkg
+	}
+	if opts.Name == "" {
+		opts.Name = path.Base(opts.Pkg)
+	}
+	return opts, nil
+}
+
+// isPipelineReleaseComplete determines if the release of a tagged build
+// already has the archive for every platform, or the artifacts in the archive
+// directory when archiving is skipped, so that the release can be skipped without rebuilding.
+func isPipelineReleaseComplete(info GitMetadata, opts PipelineOptions) bool {
+	if !info.IsTaggedRelease {
+		return false
+	}
+
+	repo, err := resolveReleaseRepository(opts.Publish.Repository)
+	if err != nil {
+		return false
+	}
+
+	// The archive directory is published as-is when archiving is skipped
+	var expected []string
+	if opts.SkipArchive {
+		artifacts, err := listReleaseArtifacts(opts.Publish.ArtifactsDir)
+		if err != nil || len(artifacts) == 0 {
+			return false
+		}
+		expected = artifacts
+	} else {
+		build, err := resolvePipelineBuild(opts.Build)
+		if err != nil {
+			return false
+		}
+		platforms := build.Platforms
+		if len(platforms) == 0 {
+			if platforms, err = getPlatforms(DefaultPlatforms); err != nil {
+				return false
+			}
+		}
+		for _, platform := range platforms {
+			expected = append(expected, ArchiveName(build.Name, info.Version, platform))
+		}
+	}
+	return isReleaseComplete(info, repo, expected, VerifyReleaseOptions{Signed: opts.Sign != nil})
+}
+
+// listReleaseArtifacts returns the names of the artifacts in the directory
+// that are expected on the release, skipping checksums and signatures.
+func listReleaseArtifacts(artifactsDir string) ([]string, error) {
+	if artifactsDir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+	var expected []string
+	for _, entry := range entries {
+		if entry.IsDir() || isChecksumsFile(entry.Name()) || isSignatureFile(entry.Name()) {
+			continue
+		}
+		expected = append(expected, entry.Name())
+	}
+	return expected, nil
+}
+
+// verifyPipelineRelease checks that every artifact is attached to the release
+// that was published, which is skipped when nothing was published.
+func verifyPipelineRelease(info GitMetadata, opts PipelineOptions) error {
+	if isDryRun(opts.DryRun) {
+		log.Println("[dry-run] Skipping verifying the release because it wasn't published")
+		return nil
+	}
+	if !info.IsTaggedRelease && (!info.ShouldPublishPermalink() || isPullRequestPermalink(info.Permalink)) {
+		log.Println("Skipping verifying the release for permalink", info.Permalink)
+		return nil
+	}
+
+	expected, err := listReleaseArtifacts(opts.Publish.ArtifactsDir)
+	if err != nil {
+		return err
+	}
+
+	signed := opts.Sign != nil && (info.IsTaggedRelease || opts.Sign.SignCanary)
+	return VerifyReleaseWith(expected, VerifyReleaseOptions{Repository: opts.Publish.Repository, Signed: signed})
+}
@@ -0,0 +1,153 @@
+package releases
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReleaseWith(t *testing.T) {
+	useMetadata(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Commit: "8252b6e", IsTaggedRelease: true})
+	host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+
+	t.Run("dry run", func(t *testing.T) {
+		dir := useTestModule(t)
+		logs := captureLogs(t)
+		// The release doesn't exist yet
+		useFakeCommand(t, "gh", "exit 1")
+
+		err := ReleaseWith(PipelineOptions{
+			Build:     BuildOptions{Platforms: []Platform{host}},
+			Sign:      &SignOptions{},
+			Publish:   ReleaseOptions{Repository: "github.com/example/hello"},
+			SkipTools: true,
+			DryRun:    true,
+		})
+		require.NoError(t, err)
+
+		archiveName := "hello-v1.2.3-" + host.OS + "-" + host.Arch + ".tar.gz"
+		if host.OS == "windows" {
+			archiveName = "hello-v1.2.3-" + host.OS + "-" + host.Arch + ".zip"
+		}
+		assert.FileExists(t, filepath.Join(dir, "dist", archiveName))
+		assert.FileExists(t, filepath.Join(dir, "dist", ChecksumsFile))
+
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "Skipping the tools stage of the release")
+		assert.Contains(t, gotLogs, "[dry-run] cosign sign-blob")
+		assert.Less(t, strings.Index(gotLogs, "Running the publish stage of the release"), strings.Index(gotLogs, "[dry-run] cosign sign-blob"),
+			"the artifacts should be signed after publish generates the release files, so the signatures match what is uploaded")
+		assert.Contains(t, gotLogs, "[dry-run] gh release create -R github.com/example/hello v1.2.3")
+		assert.Contains(t, gotLogs, "[dry-run] Skipping verifying the release")
+	})
+
+	t.Run("already released", func(t *testing.T) {
+		useTestModule(t)
+		logs := captureLogs(t)
+		archiveName := ArchiveName("hello", "v1.2.3", host)
+		// The release was published with every archive
+		useFakeCommand(t, "gh", `if [ "$7" = "isDraft" ]; then echo false; exit 0; fi
+if [ "$2" = "view" ]; then printf "checksums.txt\n`+archiveName+`\n"; exit 0; fi
+exit 1`)
+
+		err := ReleaseWith(PipelineOptions{
+			Build:     BuildOptions{Platforms: []Platform{host}},
+			Publish:   ReleaseOptions{Repository: "github.com/example/hello"},
+			SkipTools: true,
+			DryRun:    true,
+		})
+		require.NoError(t, err)
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "v1.2.3 was already released to github.com/example/hello")
+		assert.NotContains(t, gotLogs, "Running the build stage of the release", "the release should not be built again")
+		assert.NoDirExists(t, "dist")
+
+		t.Run("force", func(t *testing.T) {
+			logs := captureLogs(t)
+
+			err := ReleaseWith(PipelineOptions{
+				Build:     BuildOptions{Platforms: []Platform{host}},
+				Publish:   ReleaseOptions{Repository: "github.com/example/hello", Force: true},
+				SkipTools: true,
+				DryRun:    true,
+			})
+			require.NoError(t, err)
+			assert.Contains(t, logs.String(), "Running the publish stage of the release")
+			assert.FileExists(t, filepath.Join("dist", archiveName))
+		})
+	})
+
+	t.Run("skip stages", func(t *testing.T) {
+		dir := useTestModule(t)
+		logs := captureLogs(t)
+		binDir := filepath.Join(dir, "bin")
+		require.NoError(t, os.MkdirAll(binDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(binDir, BinaryName("hello", Platform{OS: "linux", Arch: "amd64"})), []byte("hello"), 0755))
+
+		err := ReleaseWith(PipelineOptions{
+			SkipTools:   true,
+			SkipClean:   true,
+			SkipBuild:   true,
+			SkipPublish: true,
+		})
+		require.NoError(t, err)
+
+		assert.FileExists(t, filepath.Join(dir, "dist", "hello-v1.2.3-linux-amd64.tar.gz"), "the existing binaries should be archived")
+		gotLogs := logs.String()
+		for _, stage := range []string{"tools", "clean", "build", "publish", "verify"} {
+			assert.Contains(t, gotLogs, "Skipping the "+stage+" stage of the release")
+		}
+		assert.Contains(t, gotLogs, "Running the checksums stage of the release")
+	})
+
+	t.Run("failed stage", func(t *testing.T) {
+		useTestModule(t)
+		captureLogs(t)
+
+		err := ReleaseWith(PipelineOptions{
+			Build:     BuildOptions{Name: "missing", Platforms: []Platform{host}},
+			Publish:   ReleaseOptions{Repository: "github.com/example/hello"},
+			SkipTools: true,
+			DryRun:    true,
+		})
+		require.ErrorContains(t, err, "the release failed at the build stage")
+		assert.NoDirExists(t, "dist", "the release should stop at the first stage that fails")
+	})
+
+	t.Run("repository from the remote", func(t *testing.T) {
+		dir := useTestRepo(t)
+		logs := captureLogs(t)
+		t.Setenv(ReleaseRepository, "")
+		t.Setenv(DryRunMode, "")
+		gitCommand(t, "remote", "add", "origin", "https://github.com/example/hello.git")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "build"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "dist"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "dist", "hello-linux-amd64"), []byte("hello"), 0755))
+
+		// Don't push the permalink tag to the remote
+		realGit, err := exec.LookPath("git")
+		require.NoError(t, err)
+		useFakeCommand(t, "git", `if [ "$1" = "push" ]; then exit 0; fi
+exec `+realGit+` "$@"`)
+		// The release is published with every artifact
+		useFakeCommand(t, "gh", `if [ "$1" = "--version" ]; then echo "gh version 2.27.0"; exit 0; fi
+if [ "$2" = "view" ]; then printf "checksums.txt\nhello-linux-amd64\n"; fi
+exit 0`)
+
+		err = ReleaseWith(PipelineOptions{
+			Publish:     ReleaseOptions{Force: true},
+			SkipTools:   true,
+			SkipClean:   true,
+			SkipBuild:   true,
+			SkipArchive: true,
+		})
+		require.NoError(t, err)
+		assert.Contains(t, logs.String(), "Running the verify stage of the release")
+	})
+}
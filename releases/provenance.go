@@ -0,0 +1,137 @@
+package releases
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ProvenanceFile is the name of the SLSA provenance attestation attached to a release.
+const ProvenanceFile = "provenance.intoto.json"
+
+// provenanceStatement is an in-toto statement with a SLSA v0.2 provenance predicate.
+// See https://slsa.dev/provenance/v0.2
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	PredicateType string              `json:"predicateType"`
+	Subject       []provenanceSubject `json:"subject"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type provenancePredicate struct {
+	Builder    provenanceBuilder    `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation provenanceInvocation `json:"invocation"`
+	Metadata   provenanceMetadata   `json:"metadata"`
+	Materials  []provenanceMaterial `json:"materials"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type provenanceInvocation struct {
+	ConfigSource provenanceMaterial `json:"configSource"`
+	Parameters   map[string]string  `json:"parameters"`
+	Environment  map[string]string  `json:"environment"`
+}
+
+type provenanceMaterial struct {
+	URI        string            `json:"uri"`
+	Digest     map[string]string `json:"digest"`
+	EntryPoint string            `json:"entryPoint,omitempty"`
+}
+
+type provenanceMetadata struct {
+	BuildFinishedOn string `json:"buildFinishedOn"`
+}
+
+// GenerateProvenance writes a SLSA provenance attestation for the artifacts in
+// the directory to the output file, as an in-toto statement. It records the
+// builder, the source repository and commit, how the build was invoked, and the
+// SHA256 digest of each artifact. Builds that aren't running on CI use a local builder id.
+func GenerateProvenance(artifactsDir string, outputPath string) error {
+	return generateProvenance(LoadMetadata(), artifactsDir, outputPath)
+}
+
+func generateProvenance(info GitMetadata, artifactsDir string, outputPath string) error {
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || isGeneratedFile(entry.Name()) || entry.Name() == filepath.Base(outputPath) {
+			continue
+		}
+		files = append(files, filepath.Join(artifactsDir, entry.Name()))
+	}
+	sort.Strings(files)
+
+	sums, err := checksumFiles(files, ChecksumWorkers)
+	if err != nil {
+		return err
+	}
+
+	env := detectBuildEnvironment()
+	commit, err := retryGit("rev-parse", "HEAD")
+	if err != nil {
+		commit = info.Commit
+	}
+	source := provenanceMaterial{
+		URI:    "git+" + getSourceRepository(),
+		Digest: map[string]string{"sha1": commit},
+	}
+
+	statement := provenanceStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		PredicateType: "https://slsa.dev/provenance/v0.2",
+		Predicate: provenancePredicate{
+			Builder:   provenanceBuilder{ID: env.BuilderID()},
+			BuildType: "https://get.porter.sh/magefiles/release@v1",
+			Invocation: provenanceInvocation{
+				ConfigSource: provenanceMaterial{URI: source.URI, Digest: source.Digest, EntryPoint: filepath.Base(os.Args[0])},
+				Parameters:   map[string]string{"version": info.Version, "permalink": info.Permalink},
+				Environment:  map[string]string{"provider": env.Name()},
+			},
+			Metadata:  provenanceMetadata{BuildFinishedOn: time.Now().UTC().Format(time.RFC3339)},
+			Materials: []provenanceMaterial{source},
+		},
+	}
+	for i, file := range files {
+		statement.Subject = append(statement.Subject, provenanceSubject{
+			Name:   filepath.Base(file),
+			Digest: map[string]string{"sha256": sums[i]},
+		})
+	}
+
+	data, err := json.MarshalIndent(statement, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing the provenance attestation: %w", err)
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return fmt.Errorf("error writing the provenance attestation %s: %w", outputPath, err)
+	}
+	return nil
+}
+
+// Get the url of the RemoteName remote, or an empty string when it isn't known.
+// Credentials in the url, e.g. a token used by CI, are removed.
+func getSourceRepository() string {
+	remote, _ := retryGit("config", "--get", "remote."+RemoteName+".url")
+	if u, err := url.Parse(remote); err == nil && u.User != nil {
+		u.User = nil
+		return u.String()
+	}
+	return remote
+}
@@ -0,0 +1,142 @@
+package releases
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+var (
+	// DefaultPruneRetention is how long canary artifacts are kept by PruneCanaries when a retention isn't specified.
+	DefaultPruneRetention = 30 * 24 * time.Hour
+
+	// DefaultPruneKeep is how many of the most recent canary artifacts are always kept by PruneCanaries.
+	DefaultPruneKeep = 10
+)
+
+// PruneOptions are the options for removing stale canary artifacts from a bucket.
+type PruneOptions struct {
+	// Bucket containing the canary artifacts, e.g. porter-canary.
+	Bucket string
+
+	// EndpointURL of the storage service when it isn't AWS, e.g. https://minio.example.com.
+	EndpointURL string
+
+	// Prefix of the canary artifacts in the bucket. Defaults to the untagged permalink, e.g. canary/.
+	Prefix string
+
+	// Retention is how long to keep artifacts. Defaults to DefaultPruneRetention.
+	Retention time.Duration
+
+	// Keep is how many of the most recent artifacts are always kept,
+	// regardless of their age. Defaults to DefaultPruneKeep.
+	Keep int
+
+	// DryRun logs the artifacts that would be deleted, without deleting them.
+	DryRun bool
+}
+
+// bucketObject is an object in a bucket, as listed by aws s3api list-objects-v2.
+type bucketObject struct {
+	Key          string
+	LastModified time.Time
+}
+
+// PruneCanaries deletes canary artifacts from a bucket that are older than the
+// retention, while always keeping the most recent artifacts. Artifacts for a
+// stable version, e.g. canary/v1.2.3/porter-linux-amd64, are never deleted.
+func PruneCanaries(opts PruneOptions) error {
+	if opts.Bucket == "" {
+		return fmt.Errorf("the bucket to prune is required")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = Permalinks.UntaggedAlias + "/"
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultPruneRetention
+	}
+	if opts.Keep <= 0 {
+		opts.Keep = DefaultPruneKeep
+	}
+
+	bucket := strings.TrimPrefix(opts.Bucket, "s3://")
+	objects, err := listBucketObjects(bucket, opts)
+	if err != nil {
+		return err
+	}
+
+	stale := pickCanariesToPrune(objects, time.Now(), opts)
+	for _, obj := range stale {
+		cmd := shx.Command("aws", "s3", "rm", fmt.Sprintf("s3://%s/%s", bucket, obj.Key))
+		if opts.EndpointURL != "" {
+			cmd = cmd.Args("--endpoint-url", opts.EndpointURL)
+		}
+		if err := runOrLog(cmd, opts.DryRun); err != nil {
+			return fmt.Errorf("error deleting s3://%s/%s: %w", bucket, obj.Key, err)
+		}
+	}
+
+	if isDryRun(opts.DryRun) {
+		log.Printf("Would prune %d of %d canary artifacts from s3://%s/%s\n", len(stale), len(objects), bucket, opts.Prefix)
+	} else {
+		log.Printf("Pruned %d of %d canary artifacts from s3://%s/%s\n", len(stale), len(objects), bucket, opts.Prefix)
+	}
+	return nil
+}
+
+func listBucketObjects(bucket string, opts PruneOptions) ([]bucketObject, error) {
+	cmd := shx.Command("aws", "s3api", "list-objects-v2", "--bucket", bucket, "--prefix", opts.Prefix, "--output", "json")
+	if opts.EndpointURL != "" {
+		cmd = cmd.Args("--endpoint-url", opts.EndpointURL)
+	}
+	output, err := cmd.OutputE()
+	if err != nil {
+		return nil, fmt.Errorf("error listing the objects in s3://%s/%s: %w", bucket, opts.Prefix, err)
+	}
+
+	// The output is empty when there aren't any objects
+	var listing struct {
+		Contents []bucketObject
+	}
+	if strings.TrimSpace(output) == "" {
+		return nil, nil
+	}
+	if err := json.Unmarshal([]byte(output), &listing); err != nil {
+		return nil, fmt.Errorf("error parsing the objects in s3://%s/%s: %w", bucket, opts.Prefix, err)
+	}
+	return listing.Contents, nil
+}
+
+// pickCanariesToPrune returns the objects that are older than the retention,
+// skipping the most recent objects and stable versions.
+func pickCanariesToPrune(objects []bucketObject, now time.Time, opts PruneOptions) []bucketObject {
+	sorted := make([]bucketObject, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastModified.After(sorted[j].LastModified)
+	})
+
+	var stale []bucketObject
+	for i, obj := range sorted {
+		if i < opts.Keep || now.Sub(obj.LastModified) < opts.Retention || isStableVersionKey(obj.Key) {
+			continue
+		}
+		stale = append(stale, obj)
+	}
+	return stale
+}
+
+// isStableVersionKey determines if any part of the key is a stable version, e.g. canary/v1.2.3/porter-linux-amd64.
+func isStableVersionKey(key string) bool {
+	for _, part := range strings.Split(key, "/") {
+		if releaseVersion.MatchString(part) && !isPrerelease(part) {
+			return true
+		}
+	}
+	return false
+}
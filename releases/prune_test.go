@@ -0,0 +1,58 @@
+package releases
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickCanariesToPrune(t *testing.T) {
+	now := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.Add(-time.Duration(days) * 24 * time.Hour) }
+	objects := []bucketObject{
+		{Key: "canary/v1.2.3-4-g8252b6e/porter-linux-amd64", LastModified: daysAgo(40)},
+		{Key: "canary/porter-linux-amd64", LastModified: daysAgo(1)},
+		{Key: "canary/v1.2.3/porter-linux-amd64", LastModified: daysAgo(90)},
+		{Key: "canary/v1.2.3-2-gabc1234/porter-linux-amd64", LastModified: daysAgo(50)},
+		{Key: "canary/v1.3.0-rc.1/porter-linux-amd64", LastModified: daysAgo(35)},
+		{Key: "canary/v1.2.4-1-gfe72ff7/porter-linux-amd64", LastModified: daysAgo(10)},
+	}
+
+	t.Run("retention", func(t *testing.T) {
+		stale := pickCanariesToPrune(objects, now, PruneOptions{Retention: 30 * 24 * time.Hour, Keep: 1})
+		assert.Equal(t, []bucketObject{
+			{Key: "canary/v1.3.0-rc.1/porter-linux-amd64", LastModified: daysAgo(35)},
+			{Key: "canary/v1.2.3-4-g8252b6e/porter-linux-amd64", LastModified: daysAgo(40)},
+			{Key: "canary/v1.2.3-2-gabc1234/porter-linux-amd64", LastModified: daysAgo(50)},
+		}, stale, "stable versions and artifacts within the retention should be kept")
+	})
+
+	t.Run("keep most recent", func(t *testing.T) {
+		stale := pickCanariesToPrune(objects, now, PruneOptions{Retention: 24 * time.Hour, Keep: 4})
+		assert.Equal(t, []bucketObject{
+			{Key: "canary/v1.2.3-2-gabc1234/porter-linux-amd64", LastModified: daysAgo(50)},
+		}, stale)
+	})
+}
+
+func TestPruneCanaries_DryRun(t *testing.T) {
+	useFakeCommand(t, "aws", fmt.Sprintf(`cat <<EOF
+{"Contents": [
+  {"Key": "canary/porter-linux-amd64", "LastModified": "%s"},
+  {"Key": "canary/v1.2.3-4-g8252b6e/porter-linux-amd64", "LastModified": "2020-01-01T00:00:00.000Z"},
+  {"Key": "canary/v1.2.3/porter-linux-amd64", "LastModified": "2020-01-01T00:00:00+00:00"}
+]}
+EOF`, time.Now().Format(time.RFC3339)))
+	logs := captureLogs(t)
+
+	err := PruneCanaries(PruneOptions{Bucket: "porter-canary", Keep: 1, DryRun: true})
+	require.NoError(t, err)
+
+	gotLogs := logs.String()
+	assert.Contains(t, gotLogs, "[dry-run] aws s3 rm s3://porter-canary/canary/v1.2.3-4-g8252b6e/porter-linux-amd64")
+	assert.NotContains(t, gotLogs, "s3://porter-canary/canary/v1.2.3/", "stable versions should never be deleted")
+	assert.Contains(t, gotLogs, "Would prune 1 of 3 canary artifacts from s3://porter-canary/canary/")
+}
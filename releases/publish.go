@@ -1,27 +1,52 @@
 package releases
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"get.porter.sh/magefiles/tools"
 	"github.com/carolynvs/magex/mgx"
 	"github.com/carolynvs/magex/shx"
 	"github.com/magefile/mage/mg"
+	"golang.org/x/sync/errgroup"
 )
 
 var must = shx.CommandBuilder{StopOnError: true}
 
 const (
-	packagesRepo      = "bin/mixins/.packages"
 	ReleaseRepository = "PORTER_RELEASE_REPOSITORY"
 	PackagesRemote    = "PORTER_PACKAGES_REMOTE"
+
+	// GitHubRetries is the environment variable that sets how many times a gh
+	// command is attempted when GitHub rate limits the request. Defaults to 5.
+	GitHubRetries = "PORTER_GH_RETRIES"
+)
+
+var (
+	// gitHubRetryBackoff is how long to wait before the first retry of a rate limited gh command,
+	// subsequent retries wait exponentially longer.
+	gitHubRetryBackoff = 2 * time.Second
+
+	// gitHubRateLimitErrors are messages from gh that indicate GitHub rate limited the request.
+	// Secondary rate limits are reported as an HTTP 403, which doesn't always mention the rate limit.
+	gitHubRateLimitErrors = []string{
+		"http 429",
+		"rate limit",
+		"abuse detection mechanism",
+		"submitted too quickly",
+	}
 )
 
 // Prepares bin directory for publishing a package
@@ -36,10 +61,10 @@ func preparePackageForPublish(pkgType string, name string) {
 		return
 	}
 
-	binDir := filepath.Join("bin", pkgType+"s", name)
+	binDir := filepath.Join(OutputDir, pkgType+"s", name)
 	// Temp hack until we have mixin.mk totally moved into mage
 	if name == "porter" {
-		binDir = "bin"
+		binDir = OutputDir
 	}
 	versionDir := filepath.Join(binDir, info.Version)
 	permalinkDir := filepath.Join(binDir, info.Permalink)
@@ -94,13 +119,13 @@ func publishPackage(pkgType string, name string) {
 		}
 	}
 	remote := fmt.Sprintf("https://%s.git", repo)
-	versionDir := filepath.Join("bin", pkgType+"s", name, info.Version)
+	versionDir := filepath.Join(OutputDir, pkgType+"s", name, info.Version)
 
 	// Create or update GitHub release for the permalink (canary/latest) with the version's binaries
 	if info.ShouldPublishPermalink() {
 		// Move the permalink tag. The existing release automatically points to the tag.
-		must.RunV("git", "tag", info.Permalink, info.Version+"^{}", "-f")
-		must.RunV("git", "push", "-f", remote, info.Permalink)
+		mgx.Must(runOrLog(shx.Command("git", "tag", info.Permalink, TagPrefix+info.Version+"^{}", "-f"), false))
+		mgx.Must(runOrLog(shx.Command("git", "push", "-f", remote, info.Permalink), false))
 
 		AddFilesToRelease(repo, info.Permalink, versionDir)
 	} else {
@@ -109,7 +134,7 @@ func publishPackage(pkgType string, name string) {
 
 	// Create GitHub release for the exact version (v1.2.3) and attach assets
 	if info.IsTaggedRelease {
-		AddFilesToRelease(repo, info.Version, versionDir)
+		AddFilesToRelease(repo, getReleaseTag(info), versionDir)
 	}
 }
 
@@ -126,12 +151,13 @@ func PublishPlugin(plugin string) {
 func publishPackageFeed(pkgType string, name string) {
 	info := LoadMetadata()
 
-	if !(info.Permalink == "canary" || info.IsTaggedRelease) {
+	if !(info.Permalink == Permalinks.UntaggedAlias || info.IsTaggedRelease) {
 		fmt.Println("Skipping publish package feed for permalink", info.Permalink)
 		return
 	}
 
 	// Clone the packages repository
+	packagesRepo := getPackagesRepo()
 	if _, err := os.Stat(packagesRepo); !os.IsNotExist(err) {
 		os.RemoveAll(packagesRepo)
 	}
@@ -146,7 +172,7 @@ func publishPackageFeed(pkgType string, name string) {
 
 	must.Command("git", "-c", "user.name='Porter Bot'", "-c", "user.email=bot@porter.sh", "commit", "--signoff", "-am", fmt.Sprintf("Add %s@%s to %s feed", name, info.Version, pkgType)).
 		In(packagesRepo).RunV()
-	must.Command("git", "push").In(packagesRepo).RunV()
+	mgx.Must(runOrLog(shx.Command("git", "push").In(packagesRepo), false))
 }
 
 // Generate an updated mixin feed and publishes it.
@@ -159,22 +185,27 @@ func PublishPluginFeed(plugin string) {
 	publishPackageFeed("plugin", plugin)
 }
 
+// getPackagesRepo returns the directory where the packages repository is cloned.
+func getPackagesRepo() string {
+	return filepath.Join(OutputDir, "mixins", ".packages")
+}
+
 func generatePackageFeed(pkgType string) error {
 	pkgDir := pkgType + "s"
-	feedFile := filepath.Join(packagesRepo, pkgDir, "atom.xml")
+	feedFile := filepath.Join(getPackagesRepo(), pkgDir, "atom.xml")
 	if err := os.MkdirAll(filepath.Dir(feedFile), 0770); err != nil {
 		return err
 	}
 
-	return shx.RunE("bin/porter", "mixins", "feed", "generate", "-d", filepath.Join("bin", pkgDir), "-f", feedFile, "-t", "build/atom-template.xml")
+	return shx.RunE(filepath.Join(OutputDir, "porter"), "mixins", "feed", "generate", "-d", filepath.Join(OutputDir, pkgDir), "-f", feedFile, "-t", "build/atom-template.xml")
 }
 
-// Generate a mixin feed from any mixin versions in bin/mixins.
+// Generate a mixin feed from any mixin versions in OUTPUTDIR/mixins, e.g. bin/mixins.
 func GenerateMixinFeed() error {
 	return generatePackageFeed("mixin")
 }
 
-// Generate a plugin feed from any plugin versions in bin/plugins.
+// Generate a plugin feed from any plugin versions in OUTPUTDIR/plugins, e.g. bin/plugins.
 func GeneratePluginFeed() error {
 	return generatePackageFeed("plugin")
 }
@@ -185,28 +216,243 @@ func AddFilesToRelease(repo string, tag string, dir string) {
 	files, err := getReleaseAssets(dir)
 	mgx.Must(err)
 
-	if !releaseExists(repo, tag) {
-		// Mark canary releases as a pre-release
-		draft := ""
-		if strings.HasPrefix(tag, "canary") {
-			draft = "-p"
+	mgx.Must(uploadReleaseAssets(repo, tag, files, "", true, false))
+}
+
+// uploadReleaseAssets creates or updates a GitHub release with the specified files.
+// The notes are added before the generated release notes when the release is created.
+// When the release exists, existing assets are only replaced when overwrite is set,
+// otherwise just the missing assets are uploaded. The assets are uploaded
+// concurrently, see UploadWorkers, and the release stays in draft until they are all uploaded.
+// When dryRun is set, the gh commands are logged instead of executed, and the
+// release isn't looked up, so the commands that create it are logged.
+func uploadReleaseAssets(repo string, tag string, files []string, notes string, overwrite bool, dryRun bool) error {
+	// Don't call GitHub in a dry run, so that it works offline and without gh being authenticated
+	exists := false
+	if isDryRun(dryRun) {
+		log.Printf("[dry-run] Skipping checking if the %s release exists, logging the commands that create it\n", tag)
+	} else {
+		exists = releaseExists(repo, tag)
+	}
+
+	if !exists {
+		// Mark canary and prerelease releases, e.g. v1.2.0-rc.1, as a pre-release
+		prerelease := ""
+		if strings.HasPrefix(tag, Permalinks.UntaggedAlias) || strings.HasPrefix(tag, Permalinks.PrereleaseAlias) || isPrerelease(tag) {
+			prerelease = "--prerelease"
 		}
 
-		// Create the GH release and upload the assets at the same time
+		// Create the GH release in draft, and then upload the assets
 		// The release stays in draft until all assets are uploaded
-		must.Command("gh", "release", "create", "-R", repo, tag, "--generate-notes", draft).
-			Args(files...).CollapseArgs().RunV()
-	} else {
+		cmd := shx.Command("gh", "release", "create", "-R", repo, tag, "--generate-notes", prerelease)
+		if notes != "" {
+			cmd = cmd.Args("--notes", notes)
+		}
+		if err := runGitHub(cmd.Args("--draft").CollapseArgs(), dryRun); err != nil {
+			return newReleaseUploadError(tag, files, err)
+		}
+		if err := uploadAssets(repo, tag, files, false, dryRun); err != nil {
+			return err
+		}
+	} else if overwrite {
 		// We must have failed when creating the release last time, and someone kicked the build to retry
 		// Get the release back into the desired state (see gh release create above for what we want to look like)
 
 		// Upload the release assets and overwrite existing assets
-		must.Command("gh", "release", "upload", "--clobber", "-R", repo, tag).
-			Args(files...).RunV()
+		if err := uploadAssets(repo, tag, files, true, dryRun); err != nil {
+			return err
+		}
+	} else {
+		// Only upload the assets that weren't attached last time
+		missing, err := getMissingReleaseAssets(repo, tag, files)
+		if err != nil {
+			return err
+		}
+
+		if len(missing) == 0 {
+			log.Printf("All assets are already attached to the %s release\n", tag)
+		} else if err := uploadAssets(repo, tag, missing, false, dryRun); err != nil {
+			return err
+		}
+	}
+
+	// The release may still be stuck in draft from a previous failed upload, make sure draft is cleared
+	return runGitHub(shx.Command("gh", "release", "edit", "--draft=false", "-R", repo, tag), dryRun)
+}
+
+// UploadWorkers is the maximum number of assets that are uploaded to a GitHub release at once. Defaults to 4.
+var UploadWorkers = 4
+
+// uploadReleaseAsset uploads a file to an existing GitHub release, writing the output of gh to out.
+// Existing assets with the same name are replaced when overwrite is set.
+var uploadReleaseAsset = func(repo string, tag string, file string, overwrite bool, dryRun bool, out io.Writer) error {
+	cmd := shx.Command("gh", "release", "upload")
+	if overwrite {
+		cmd = cmd.Args("--clobber")
+	}
+	return runGitHubTo(cmd.Args("-R", repo, tag, file), dryRun, out)
+}
+
+// uploadAssets uploads the files to a GitHub release concurrently, with at
+// most UploadWorkers uploads at a time. Every file is attempted, and the errors
+// for the files that failed are returned together. The output of each upload
+// is logged in the order of the files once they have all finished, so that
+// the logs don't depend on which upload finished first.
+func uploadAssets(repo string, tag string, files []string, overwrite bool, dryRun bool) error {
+	outputs := make([]bytes.Buffer, len(files))
+	errs := make([]error, len(files))
+
+	workers := UploadWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each upload's output and error are stored at the same index as the file,
+	// so that the uploads don't share any state
+	var g errgroup.Group
+	sem := make(chan struct{}, workers)
+	for i, file := range files {
+		i, file := i, file
+		sem <- struct{}{}
+		g.Go(func() error {
+			defer func() { <-sem }()
+
+			errs[i] = uploadReleaseAsset(repo, tag, file, overwrite, dryRun, &outputs[i])
+			return nil
+		})
+	}
+	g.Wait()
+
+	var failed []error
+	for i, file := range files {
+		if outputs[i].Len() > 0 {
+			log.Print(outputs[i].String())
+		}
+		if errs[i] != nil {
+			failed = append(failed, newReleaseUploadError(tag, []string{file}, errs[i]))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// newReleaseUploadError returns an ErrUploadFailed for files that failed to upload to a GitHub release.
+func newReleaseUploadError(tag string, files []string, err error) error {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = filepath.Base(file)
+	}
+	return ErrUploadFailed{Asset: strings.Join(names, ", "), Destination: fmt.Sprintf("the %s release", tag), Err: err}
+}
+
+// runGitHub is like runOrLog for a gh command, retrying the command with
+// exponential backoff and jitter when GitHub rate limits the request, e.g. with
+// an HTTP 429, or an HTTP 403 for its secondary rate limits. Other errors are not retried.
+func runGitHub(cmd shx.PreparedCommand, dryRun bool) error {
+	return runGitHubTo(cmd, dryRun, nil)
+}
+
+// runGitHubTo is like runGitHub, writing the output of gh, and the dry-run and
+// retry messages, to out instead. When out is nil, the output is written to
+// stdout and stderr, and the messages are logged.
+func runGitHubTo(cmd shx.PreparedCommand, dryRun bool, out io.Writer) error {
+	logf := log.Printf
+	stdout := io.Writer(os.Stdout)
+	if out != nil {
+		logf = func(format string, args ...interface{}) { fmt.Fprintf(out, format, args...) }
+		stdout = out
+	}
+
+	if isDryRun(dryRun) {
+		logf("[dry-run] %s\n", cmd)
+		return nil
+	}
+
+	attempts, err := getRetryAttempts(GitHubRetries, 5)
+	if err != nil {
+		return err
+	}
+	timeout, err := getCommandTimeout()
+	if err != nil {
+		return err
+	}
+
+	for i := 1; ; i++ {
+		// A command can only be run once, so run a copy of it for each attempt
+		timed, ctx, cancel := commandWithTimeout(cmd.Cmd, timeout)
+		attempt := shx.PreparedCommand{Cmd: timed}
+
+		// stdout and stderr are copied by separate goroutines, so when they are
+		// written to out, stderr is only appended once the command completes
+		var errOutput bytes.Buffer
+		stderr := io.Writer(&errOutput)
+		if out == nil {
+			stderr = io.MultiWriter(os.Stderr, &errOutput)
+		}
+		_, _, err := attempt.Stdout(stdout).Stderr(stderr).Exec()
+		cancel()
+		if out != nil {
+			out.Write(errOutput.Bytes())
+		}
+		if err == nil {
+			return nil
+		}
+		if err := timeoutError(ctx, timed, timeout, err); errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if i >= attempts || !isGitHubRateLimitError(errOutput.String()) {
+			return err
+		}
+
+		delay := gitHubRetryBackoff << (i - 1)
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		logf("GitHub rate limited %s, retrying in %s (%d/%d)\n", cmd, delay, i, attempts-1)
+		time.Sleep(delay)
+	}
+}
 
-		// The release may still be stuck in draft from a previous failed upload while creating the release, make sure draft is cleared
-		must.Command("gh", "release", "edit", "--draft=false", "-R", repo, tag).RunV()
+func isGitHubRateLimitError(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, rateLimitErr := range gitHubRateLimitErrors {
+		if strings.Contains(msg, rateLimitErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// DryRunMode is the environment variable that enables dry-run mode for every
+// function that publishes, signs or uploads, e.g. PORTER_MAGE_DRY_RUN=1 mage publish.
+const DryRunMode = "PORTER_MAGE_DRY_RUN"
+
+// DryRun logs the commands that would be run by every function that publishes,
+// signs or uploads, without executing them. It can also be enabled with
+// SetDryRun or the PORTER_MAGE_DRY_RUN environment variable.
+var DryRun bool
+
+// SetDryRun enables or disables dry-run mode for every function that has side effects.
+func SetDryRun(dryRun bool) {
+	DryRun = dryRun
+}
+
+// isDryRun determines if the commands should only be logged, either because
+// the function's own dry-run option is set or dry-run mode is enabled globally.
+func isDryRun(dryRun bool) bool {
+	if dryRun || DryRun {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(os.Getenv(DryRunMode))
+	return enabled
+}
+
+// runOrLog executes the command, printing its output.
+// When dryRun is set, or dry-run mode is enabled, the command is logged instead of executed.
+func runOrLog(cmd shx.PreparedCommand, dryRun bool) error {
+	if isDryRun(dryRun) {
+		log.Println("[dry-run]", cmd)
+		return nil
 	}
+	disablePrompts(cmd.Cmd)
+	return cmd.RunV()
 }
 
 func getReleaseAssets(dir string) ([]string, error) {
@@ -229,6 +475,27 @@ func getReleaseAssets(dir string) ([]string, error) {
 	return releaseFiles, nil
 }
 
+// getMissingReleaseAssets returns the files that aren't attached to the release, compared by filename.
+func getMissingReleaseAssets(repo string, tag string, files []string) ([]string, error) {
+	output, err := shx.OutputE("gh", "release", "view", "-R", repo, tag, "--json", "assets", "--jq", ".assets[].name")
+	if err != nil {
+		return nil, fmt.Errorf("error listing the assets of the %s release: %w", tag, err)
+	}
+
+	existing := map[string]bool{}
+	for _, name := range strings.Split(output, "\n") {
+		existing[name] = true
+	}
+
+	var missing []string
+	for _, file := range files {
+		if !existing[filepath.Base(file)] {
+			missing = append(missing, file)
+		}
+	}
+	return missing, nil
+}
+
 func releaseExists(repo string, version string) bool {
 	return shx.RunE("gh", "release", "view", "-R", repo, version) == nil
 }
@@ -1,11 +1,18 @@
 package releases
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"get.porter.sh/magefiles/porter"
 	"github.com/carolynvs/magex/mgx"
@@ -136,3 +143,205 @@ func TestGeneratePluginFeed_PorterNotInstalled(t *testing.T) {
 	err = GeneratePluginFeed()
 	require.Errorf(t, err, "farts", "GeneratePluginFeed should fail when porter is not in the bin")
 }
+
+func TestRunOrLog(t *testing.T) {
+	t.Setenv(DryRunMode, "")
+	ranFile := filepath.Join(t.TempDir(), "ran")
+	useFakeCommand(t, "publish", "touch "+ranFile)
+	cmd := shx.Command("publish", "v1.2.3")
+
+	testcases := []struct {
+		name   string
+		setup  func(t *testing.T)
+		dryRun bool
+	}{
+		{name: "option", dryRun: true},
+		{name: "SetDryRun", setup: func(t *testing.T) {
+			SetDryRun(true)
+			t.Cleanup(func() { SetDryRun(false) })
+		}},
+		{name: "env var", setup: func(t *testing.T) {
+			t.Setenv(DryRunMode, "1")
+		}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup(t)
+			}
+			logs := captureLogs(t)
+
+			require.NoError(t, runOrLog(cmd, tc.dryRun))
+			assert.Contains(t, logs.String(), "[dry-run] publish v1.2.3")
+			assert.NoFileExists(t, ranFile, "the command should not be executed")
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		require.NoError(t, runOrLog(cmd, false))
+		assert.FileExists(t, ranFile, "the command should be executed")
+	})
+}
+
+func TestRunGitHub(t *testing.T) {
+	origBackoff := gitHubRetryBackoff
+	gitHubRetryBackoff = time.Millisecond
+	t.Cleanup(func() { gitHubRetryBackoff = origBackoff })
+	t.Setenv(DryRunMode, "")
+	t.Setenv(GitHubRetries, "")
+	os.Unsetenv(GitHubRetries)
+
+	// useFakeGitHub fails the first attempts of gh with the error, and returns the file that counts the attempts
+	useFakeGitHub := func(t *testing.T, failures int, msg string) string {
+		attemptsFile := filepath.Join(t.TempDir(), "attempts")
+		useFakeCommand(t, "gh", fmt.Sprintf(`echo attempt >> %s
+if [ $(wc -l < %s) -le %d ]; then echo "%s" >&2; exit 1; fi`, attemptsFile, attemptsFile, failures, msg))
+		return attemptsFile
+	}
+	countAttempts := func(t *testing.T, attemptsFile string) int {
+		data, err := os.ReadFile(attemptsFile)
+		require.NoError(t, err)
+		return strings.Count(string(data), "attempt")
+	}
+
+	t.Run("rate limited", func(t *testing.T) {
+		logs := captureLogs(t)
+		attemptsFile := useFakeGitHub(t, 2, "HTTP 429: Too Many Requests")
+
+		require.NoError(t, runGitHub(shx.Command("gh", "release", "upload"), false))
+		assert.Equal(t, 3, countAttempts(t, attemptsFile))
+		assert.Contains(t, logs.String(), "GitHub rate limited gh release upload, retrying in")
+	})
+
+	t.Run("secondary rate limit", func(t *testing.T) {
+		captureLogs(t)
+		attemptsFile := useFakeGitHub(t, 1, "HTTP 403: You have exceeded a secondary rate limit")
+
+		require.NoError(t, runGitHub(shx.Command("gh", "release", "upload"), false))
+		assert.Equal(t, 2, countAttempts(t, attemptsFile))
+	})
+
+	t.Run("secondary rate limit without the rate limit message", func(t *testing.T) {
+		for _, msg := range []string{
+			"HTTP 403: You have triggered an abuse detection mechanism. Please wait a few minutes before you try again.",
+			"HTTP 403: was submitted too quickly",
+		} {
+			captureLogs(t)
+			attemptsFile := useFakeGitHub(t, 1, msg)
+
+			require.NoError(t, runGitHub(shx.Command("gh", "release", "upload"), false), msg)
+			assert.Equal(t, 2, countAttempts(t, attemptsFile), msg)
+		}
+	})
+
+	t.Run("out of retries", func(t *testing.T) {
+		captureLogs(t)
+		t.Setenv(GitHubRetries, "2")
+		attemptsFile := useFakeGitHub(t, 3, "HTTP 429: Too Many Requests")
+
+		require.Error(t, runGitHub(shx.Command("gh", "release", "upload"), false))
+		assert.Equal(t, 2, countAttempts(t, attemptsFile))
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		attemptsFile := useFakeGitHub(t, 1, "HTTP 403: Resource not accessible by integration")
+
+		require.Error(t, runGitHub(shx.Command("gh", "release", "upload"), false))
+		assert.Equal(t, 1, countAttempts(t, attemptsFile))
+	})
+}
+
+func TestUploadReleaseAssets_UploadFailed(t *testing.T) {
+	t.Setenv(DryRunMode, "")
+	useFakeCommand(t, "gh", `echo "HTTP 422: Validation Failed" >&2; exit 1`)
+
+	err := uploadReleaseAssets("github.com/getporter/porter", "v1.2.3", []string{"bin/porter-linux-amd64", "bin/checksums.txt"}, "", false, false)
+	var uploadErr ErrUploadFailed
+	require.ErrorAs(t, err, &uploadErr)
+	assert.Equal(t, "porter-linux-amd64, checksums.txt", uploadErr.Asset)
+	assert.Equal(t, "the v1.2.3 release", uploadErr.Destination)
+}
+
+func TestUploadReleaseAssets_DryRun(t *testing.T) {
+	t.Setenv(DryRunMode, "")
+	// gh isn't installed, so the dry run should not call it
+	t.Setenv("PATH", t.TempDir())
+	logs := captureLogs(t)
+
+	err := uploadReleaseAssets("github.com/getporter/porter", "v1.2.3", []string{"bin/porter-linux-amd64"}, "", false, true)
+	require.NoError(t, err)
+
+	gotLogs := logs.String()
+	assert.Contains(t, gotLogs, "[dry-run] Skipping checking if the v1.2.3 release exists")
+	assert.Contains(t, gotLogs, "[dry-run] gh release create -R github.com/getporter/porter v1.2.3 --generate-notes --draft")
+	assert.Contains(t, gotLogs, "[dry-run] gh release upload -R github.com/getporter/porter v1.2.3 bin/porter-linux-amd64")
+	assert.Contains(t, gotLogs, "[dry-run] gh release edit --draft=false -R github.com/getporter/porter v1.2.3")
+}
+
+func TestUploadReleaseAsset_Output(t *testing.T) {
+	t.Setenv(DryRunMode, "")
+	// gh writes its progress to both stdout and stderr
+	useFakeCommand(t, "gh", `for i in 1 2 3 4 5; do echo "stdout $i"; echo "stderr $i" >&2; done`)
+
+	var out bytes.Buffer
+	require.NoError(t, uploadReleaseAsset("github.com/getporter/porter", "v1.2.3", "bin/porter-linux-amd64", false, false, &out))
+
+	gotOutput := out.String()
+	assert.Contains(t, gotOutput, "stdout 5\n")
+	assert.Contains(t, gotOutput, "stderr 5\n")
+}
+
+func TestUploadAssets(t *testing.T) {
+	origWorkers, origUploader := UploadWorkers, uploadReleaseAsset
+	t.Cleanup(func() { UploadWorkers, uploadReleaseAsset = origWorkers, origUploader })
+	UploadWorkers = 3
+
+	files := make([]string, 10)
+	for i := range files {
+		files[i] = fmt.Sprintf("bin/asset-%d", i)
+	}
+
+	// Fake the upload, tracking the maximum number of concurrent uploads and failing some of the assets
+	var mu sync.Mutex
+	var running, maxRunning int
+	attempted := map[string]bool{}
+	uploadReleaseAsset = func(repo string, tag string, file string, overwrite bool, dryRun bool, out io.Writer) error {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		attempted[file] = true
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(out, "uploaded %s\n", file)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if file == "bin/asset-2" || file == "bin/asset-7" {
+			return errors.New("HTTP 502: Bad Gateway")
+		}
+		return nil
+	}
+
+	logs := captureLogs(t)
+	err := uploadAssets("github.com/getporter/porter", "v1.2.3", files, false, false)
+
+	assert.Len(t, attempted, len(files), "every asset should be attempted")
+	assert.Greater(t, maxRunning, 1, "the assets should be uploaded concurrently")
+	assert.LessOrEqual(t, maxRunning, UploadWorkers, "no more than UploadWorkers assets should be uploaded at once")
+
+	require.ErrorContains(t, err, "error uploading asset-2 to the v1.2.3 release: HTTP 502: Bad Gateway")
+	require.ErrorContains(t, err, "error uploading asset-7 to the v1.2.3 release", "every failed asset should be reported")
+	var uploadErr ErrUploadFailed
+	require.ErrorAs(t, err, &uploadErr)
+
+	var uploaded []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if i := strings.Index(line, "uploaded "); i >= 0 {
+			uploaded = append(uploaded, strings.TrimPrefix(line[i:], "uploaded "))
+		}
+	}
+	assert.Equal(t, files, uploaded, "the output should be logged in the order of the assets")
+}
@@ -0,0 +1,111 @@
+package releases
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// DefaultRegistry is the registry used by LoginRegistry when none is specified.
+const DefaultRegistry = "ghcr.io"
+
+var (
+	// loggedInRegistries tracks the registries that LoginRegistry has logged into,
+	// so that publishing multiple images only logs in once.
+	loggedInRegistries   = map[string]bool{}
+	loggedInRegistriesMu sync.Mutex
+)
+
+// RegistryLogin are the credentials for logging into a container registry.
+type RegistryLogin struct {
+	// Registry to log into, e.g. ghcr.io. Defaults to DefaultRegistry.
+	Registry string
+
+	// Username for the registry. Defaults to GITHUB_ACTOR on GitHub Actions when the registry is ghcr.io.
+	Username string
+
+	// Password or token for the registry. Defaults to GITHUB_TOKEN on GitHub Actions when the registry is ghcr.io.
+	Password string
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// LoginRegistry logs docker into a container registry. On GitHub Actions, the
+// GITHUB_TOKEN provisioned for the workflow is used to log into ghcr.io when a username and
+// password aren't specified, so that long-lived credentials don't need to be stored.
+// When there aren't any credentials and docker is already logged into the
+// registry, the existing login is used.
+func LoginRegistry(opts RegistryLogin) error {
+	if opts.Registry == "" {
+		opts.Registry = DefaultRegistry
+	}
+
+	loggedInRegistriesMu.Lock()
+	defer loggedInRegistriesMu.Unlock()
+	if loggedInRegistries[opts.Registry] {
+		return nil
+	}
+
+	// The GITHUB_TOKEN can only log into GitHub's registry
+	if opts.Username == "" && opts.Password == "" && opts.Registry == DefaultRegistry && detectBuildEnvironment().Name() == "github" {
+		opts.Username = os.Getenv("GITHUB_ACTOR")
+		opts.Password = os.Getenv("GITHUB_TOKEN")
+	}
+
+	if opts.Username == "" || opts.Password == "" {
+		if isLoggedIn(opts.Registry) {
+			log.Println("Using the existing login for", opts.Registry)
+			loggedInRegistries[opts.Registry] = true
+			return nil
+		}
+		return fmt.Errorf("a username and password are required to log into %s", opts.Registry)
+	}
+
+	// Pass the password on stdin so that it isn't exposed in the process list or logs
+	cmd := shx.Command("docker", "login", opts.Registry, "--username", opts.Username, "--password-stdin").
+		Stdin(strings.NewReader(opts.Password))
+	if err := runOrLog(cmd, opts.DryRun); err != nil {
+		return fmt.Errorf("error logging into %s: %w", opts.Registry, err)
+	}
+
+	// A dry run only logged the command, so a later call must still log in
+	if !isDryRun(opts.DryRun) {
+		loggedInRegistries[opts.Registry] = true
+	}
+	return nil
+}
+
+// isLoggedIn determines if the docker config has credentials for the registry.
+func isLoggedIn(registry string) bool {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false
+		}
+		configDir = filepath.Join(home, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return false
+	}
+
+	var config struct {
+		Auths       map[string]json.RawMessage `json:"auths"`
+		CredHelpers map[string]string          `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return false
+	}
+	_, hasAuth := config.Auths[registry]
+	_, hasHelper := config.CredHelpers[registry]
+	return hasAuth || hasHelper
+}
@@ -0,0 +1,95 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forgetRegistryLogins resets which registries LoginRegistry has logged into.
+func forgetRegistryLogins(t *testing.T) {
+	loggedInRegistries = map[string]bool{}
+	t.Cleanup(func() { loggedInRegistries = map[string]bool{} })
+}
+
+func TestLoginRegistry(t *testing.T) {
+	unsetBuildEnvironment(t)
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	t.Run("github actions token", func(t *testing.T) {
+		forgetRegistryLogins(t)
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_ACTOR", "porter-bot")
+		t.Setenv("GITHUB_TOKEN", "super-secret-token")
+		logs := captureLogs(t)
+
+		require.NoError(t, LoginRegistry(RegistryLogin{DryRun: true}))
+
+		gotLogs := logs.String()
+		assert.Contains(t, gotLogs, "[dry-run] docker login ghcr.io --username porter-bot --password-stdin")
+		assert.NotContains(t, gotLogs, "super-secret-token", "the token should not be logged")
+	})
+
+	t.Run("github actions other registry", func(t *testing.T) {
+		forgetRegistryLogins(t)
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_ACTOR", "porter-bot")
+		t.Setenv("GITHUB_TOKEN", "super-secret-token")
+		logs := captureLogs(t)
+
+		err := LoginRegistry(RegistryLogin{Registry: "docker.io", DryRun: true})
+		require.ErrorContains(t, err, "a username and password are required to log into docker.io",
+			"the GITHUB_TOKEN should only be used for ghcr.io")
+		assert.NotContains(t, logs.String(), "docker login")
+	})
+
+	t.Run("logs in once", func(t *testing.T) {
+		forgetRegistryLogins(t)
+		t.Setenv("GITHUB_ACTIONS", "true")
+		t.Setenv("GITHUB_ACTOR", "porter-bot")
+		t.Setenv("GITHUB_TOKEN", "super-secret-token")
+		countFile := filepath.Join(t.TempDir(), "count")
+		useFakeCommand(t, "docker", `echo login >> `+countFile)
+
+		// A dry run doesn't log in, so the next call should run docker login
+		require.NoError(t, LoginRegistry(RegistryLogin{DryRun: true}))
+		require.NoError(t, LoginRegistry(RegistryLogin{}))
+		require.NoError(t, LoginRegistry(RegistryLogin{}))
+
+		data, err := os.ReadFile(countFile)
+		require.NoError(t, err, "docker login should run after a dry run")
+		assert.Equal(t, 1, strings.Count(string(data), "login"), "the registry should only be logged into once")
+	})
+
+	t.Run("username and password", func(t *testing.T) {
+		forgetRegistryLogins(t)
+		logs := captureLogs(t)
+
+		require.NoError(t, LoginRegistry(RegistryLogin{Registry: "docker.io", Username: "me", Password: "hunter2", DryRun: true}))
+
+		assert.Contains(t, logs.String(), "[dry-run] docker login docker.io --username me --password-stdin")
+		assert.NotContains(t, logs.String(), "hunter2")
+	})
+
+	t.Run("already logged in", func(t *testing.T) {
+		forgetRegistryLogins(t)
+		configDir := t.TempDir()
+		t.Setenv("DOCKER_CONFIG", configDir)
+		require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"auths":{"ghcr.io":{}}}`), 0600))
+		logs := captureLogs(t)
+
+		require.NoError(t, LoginRegistry(RegistryLogin{DryRun: true}))
+		assert.NotContains(t, logs.String(), "docker login")
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		forgetRegistryLogins(t)
+
+		err := LoginRegistry(RegistryLogin{DryRun: true})
+		require.ErrorContains(t, err, "a username and password are required to log into ghcr.io")
+	})
+}
@@ -0,0 +1,77 @@
+package releases
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// SBOMExt is the extension of the SBOM generated for a release artifact.
+const SBOMExt = ".sbom.json"
+
+var (
+	// SyftPath is the path to the syft command used to generate SBOMs.
+	SyftPath = "syft"
+
+	// SBOMFormat is the format of the generated SBOMs, either cyclonedx-json or spdx-json.
+	SBOMFormat = "cyclonedx-json"
+)
+
+// GenerateSBOM uses syft to generate a software bill of materials (SBOM) for a binary
+// in the format specified by SBOMFormat.
+func GenerateSBOM(binaryPath string, outputPath string) error {
+	return generateSBOM(binaryPath, outputPath, false)
+}
+
+func generateSBOM(binaryPath string, outputPath string, dryRun bool) error {
+	switch SBOMFormat {
+	case "cyclonedx-json", "spdx-json":
+	default:
+		return fmt.Errorf("unsupported SBOM format %q, use either cyclonedx-json or spdx-json", SBOMFormat)
+	}
+
+	if _, err := exec.LookPath(SyftPath); err != nil && !isDryRun(dryRun) {
+		return fmt.Errorf("syft is required to generate SBOMs but was not found at %s. Install it from https://github.com/anchore/syft#installation or set releases.SyftPath to its location", SyftPath)
+	}
+
+	cmd := shx.Command(SyftPath, "file:"+binaryPath, "-o", SBOMFormat+"="+outputPath)
+	if err := runOrLog(cmd, dryRun); err != nil {
+		return fmt.Errorf("error generating an SBOM for %s: %w", binaryPath, err)
+	}
+	return nil
+}
+
+// generateSBOMs generates an SBOM for every release artifact in the directory, named NAME.sbom.json.
+func generateSBOMs(artifactsDir string, dryRun bool) error {
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || isGeneratedFile(entry.Name()) {
+			continue
+		}
+
+		artifact := filepath.Join(artifactsDir, entry.Name())
+		if err := generateSBOM(artifact, artifact+SBOMExt, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isGeneratedFile determines if the file describes another release artifact,
+// such as a checksum, signature or SBOM, instead of being an artifact itself.
+func isGeneratedFile(path string) bool {
+	name := filepath.Base(path)
+	if isChecksumsFile(name) || name == ProvenanceFile || strings.HasSuffix(name, SBOMExt) || isSignatureFile(name) {
+		return true
+	}
+	_, added := AddChecksumExt(name)
+	return !added
+}
@@ -0,0 +1,79 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSBOM(t *testing.T) {
+	// Fake syft by writing the file specified by -o FORMAT=PATH
+	useFakeCommand(t, "syft", `echo "$1 $3" > "${3#*=}"`)
+
+	t.Run("cyclonedx", func(t *testing.T) {
+		dir := t.TempDir()
+		sbomPath := filepath.Join(dir, "porter-linux-amd64.sbom.json")
+
+		require.NoError(t, GenerateSBOM("bin/porter-linux-amd64", sbomPath))
+
+		sbom, err := os.ReadFile(sbomPath)
+		require.NoError(t, err)
+		assert.Equal(t, "file:bin/porter-linux-amd64 cyclonedx-json="+sbomPath+"\n", string(sbom))
+	})
+
+	t.Run("spdx", func(t *testing.T) {
+		origFormat := SBOMFormat
+		defer func() { SBOMFormat = origFormat }()
+		SBOMFormat = "spdx-json"
+		sbomPath := filepath.Join(t.TempDir(), "porter.sbom.json")
+
+		require.NoError(t, GenerateSBOM("bin/porter", sbomPath))
+
+		sbom, err := os.ReadFile(sbomPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(sbom), "spdx-json=")
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		origFormat := SBOMFormat
+		defer func() { SBOMFormat = origFormat }()
+		SBOMFormat = "xml"
+
+		err := GenerateSBOM("bin/porter", "porter.sbom.json")
+		require.ErrorContains(t, err, `unsupported SBOM format "xml"`)
+	})
+
+	t.Run("syft not installed", func(t *testing.T) {
+		origSyft := SyftPath
+		defer func() { SyftPath = origSyft }()
+		SyftPath = filepath.Join(t.TempDir(), "syft")
+
+		err := GenerateSBOM("bin/porter", "porter.sbom.json")
+		require.ErrorContains(t, err, "syft is required to generate SBOMs but was not found")
+
+		t.Run("dry run mode", func(t *testing.T) {
+			t.Setenv(DryRunMode, "true")
+			logs := captureLogs(t)
+
+			require.NoError(t, GenerateSBOM("bin/porter", "porter.sbom.json"), "syft isn't required when the dry run is enabled by the environment")
+			assert.Contains(t, logs.String(), "[dry-run] "+SyftPath+" file:bin/porter")
+		})
+	})
+
+	t.Run("each release artifact", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-linux-amd64"), nil, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ChecksumsFile), nil, 0644))
+
+		require.NoError(t, generateSBOMs(dir, false))
+		assert.FileExists(t, filepath.Join(dir, "porter-linux-amd64.sbom.json"))
+		assert.NoFileExists(t, filepath.Join(dir, ChecksumsFile+SBOMExt), "SBOMs should only be generated for artifacts")
+
+		// Regenerating should not create an SBOM for the SBOM
+		require.NoError(t, generateSBOMs(dir, false))
+		assert.NoFileExists(t, filepath.Join(dir, "porter-linux-amd64.sbom.json.sbom.json"), "SBOMs should only be generated for artifacts")
+	})
+}
@@ -0,0 +1,108 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// SignOptions are the options for signing release artifacts with cosign.
+type SignOptions struct {
+	// KeyPath is the path to the cosign private key. When empty, keyless
+	// signing is used with the OIDC token provided by the CI system, e.g. GitHub Actions.
+	KeyPath string
+
+	// SignCanary enables signing artifacts for builds that are not a tagged release.
+	SignCanary bool
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// SignArtifacts signs every file in the artifacts directory with cosign, writing
+// the signature next to the artifact with a .sig extension. Keyless signatures
+// also write the signing certificate with a .pem extension.
+// Only tagged releases are signed unless SignCanary is set.
+func SignArtifacts(artifactsDir string, opts SignOptions) error {
+	return signArtifacts(LoadMetadata(), artifactsDir, opts)
+}
+
+func signArtifacts(info GitMetadata, artifactsDir string, opts SignOptions) error {
+	if !info.IsTaggedRelease && !opts.SignCanary {
+		log.Println("Skipping signing artifacts for permalink", info.Permalink)
+		return nil
+	}
+
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || isSignatureFile(entry.Name()) {
+			continue
+		}
+
+		artifact := filepath.Join(artifactsDir, entry.Name())
+		cmd := shx.Command("cosign", "sign-blob", "--yes", "--output-signature", artifact+".sig")
+		if opts.KeyPath != "" {
+			cmd = cmd.Args("--key", opts.KeyPath)
+		} else {
+			cmd = cmd.Args("--output-certificate", artifact+".pem")
+		}
+		cmd = cmd.Args(artifact)
+
+		if err := runOrLog(cmd, opts.DryRun); err != nil {
+			return fmt.Errorf("error signing release artifact %s: %w", artifact, err)
+		}
+	}
+	return nil
+}
+
+// GPGKeyID is the environment variable that specifies the GPG key used by
+// SignChecksums when GPGOptions.KeyID isn't set, e.g. bot@porter.sh.
+const GPGKeyID = "PORTER_GPG_KEY_ID"
+
+// GPGOptions are the options for signing the checksums file with GPG.
+type GPGOptions struct {
+	// KeyID is the GPG key used to sign, e.g. its fingerprint or email address.
+	// Defaults to the PORTER_GPG_KEY_ID environment variable.
+	KeyID string
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// SignChecksums writes a detached, ASCII armored GPG signature for the checksums
+// file next to it, e.g. checksums.txt.asc, so that consumers can verify every
+// artifact with a single signature. The signing is skipped with a warning when no key is configured.
+func SignChecksums(checksumsPath string, opts GPGOptions) error {
+	if opts.KeyID == "" {
+		opts.KeyID = os.Getenv(GPGKeyID)
+	}
+	if opts.KeyID == "" {
+		log.Printf("WARNING: Skipping signing %s with GPG because no key is configured, set %s\n", checksumsPath, GPGKeyID)
+		return nil
+	}
+
+	sigPath := checksumsPath + ".asc"
+	cmd := shx.Command("gpg", "--batch", "--yes", "--local-user", opts.KeyID,
+		"--detach-sign", "--armor", "--output", sigPath, checksumsPath)
+	if err := runOrLog(cmd, opts.DryRun); err != nil {
+		return fmt.Errorf("error signing %s with GPG: %w", checksumsPath, err)
+	}
+	return nil
+}
+
+// isSignatureFile determines if the file was generated when signing an artifact.
+func isSignatureFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".sig", ".pem", ".asc":
+		return true
+	default:
+		return false
+	}
+}
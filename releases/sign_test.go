@@ -0,0 +1,102 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useFakeCosign fakes cosign by writing the requested signature file
+func useFakeCosign(t *testing.T) {
+	useFakeCommand(t, "cosign", `while [ $# -gt 0 ]; do
+  if [ "$1" = "--output-signature" ]; then echo signature > "$2"; fi
+  shift
+done`)
+}
+
+func TestSignArtifacts(t *testing.T) {
+	useFakeCosign(t)
+	tagged := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+	canary := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+
+	t.Run("tagged release", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-linux-amd64"), nil, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-linux-amd64.sig"), nil, 0644))
+
+		require.NoError(t, signArtifacts(tagged, dir, SignOptions{KeyPath: "cosign.key"}))
+
+		assert.FileExists(t, filepath.Join(dir, "porter-linux-amd64.sig"))
+		assert.NoFileExists(t, filepath.Join(dir, "porter-linux-amd64.sig.sig"), "signatures should not be signed")
+	})
+
+	t.Run("keyless dry run", func(t *testing.T) {
+		logs := captureLogs(t)
+		dir := t.TempDir()
+		artifact := filepath.Join(dir, "porter-linux-amd64")
+		require.NoError(t, os.WriteFile(artifact, nil, 0755))
+
+		require.NoError(t, signArtifacts(tagged, dir, SignOptions{DryRun: true}))
+
+		assert.Contains(t, logs.String(), "[dry-run] cosign sign-blob --yes --output-signature "+artifact+".sig --output-certificate "+artifact+".pem "+artifact)
+		assert.NoFileExists(t, artifact+".sig")
+	})
+
+	t.Run("canary skipped", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-linux-amd64"), nil, 0755))
+
+		require.NoError(t, signArtifacts(canary, dir, SignOptions{}))
+		assert.NoFileExists(t, filepath.Join(dir, "porter-linux-amd64.sig"))
+	})
+
+	t.Run("sign canary", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-linux-amd64"), nil, 0755))
+
+		require.NoError(t, signArtifacts(canary, dir, SignOptions{SignCanary: true}))
+		assert.FileExists(t, filepath.Join(dir, "porter-linux-amd64.sig"))
+	})
+}
+
+func TestSignChecksums(t *testing.T) {
+	t.Setenv(DryRunMode, "")
+
+	t.Run("signed", func(t *testing.T) {
+		argsFile := filepath.Join(t.TempDir(), "args")
+		useFakeCommand(t, "gpg", `echo "$@" > `+argsFile+`
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--output" ]; then echo signature > "$2"; fi
+  shift
+done`)
+		checksumsPath := filepath.Join(t.TempDir(), ChecksumsFile)
+		require.NoError(t, os.WriteFile(checksumsPath, []byte("abc123  porter-linux-amd64\n"), 0644))
+
+		require.NoError(t, SignChecksums(checksumsPath, GPGOptions{KeyID: "bot@porter.sh"}))
+
+		assert.FileExists(t, checksumsPath+".asc")
+		args, err := os.ReadFile(argsFile)
+		require.NoError(t, err)
+		assert.Contains(t, string(args), "--local-user bot@porter.sh --detach-sign --armor")
+	})
+
+	t.Run("key from environment", func(t *testing.T) {
+		logs := captureLogs(t)
+		t.Setenv(GPGKeyID, "bot@porter.sh")
+
+		require.NoError(t, SignChecksums("dist/checksums.txt", GPGOptions{DryRun: true}))
+		assert.Contains(t, logs.String(), "[dry-run] gpg --batch --yes --local-user bot@porter.sh --detach-sign --armor --output dist/checksums.txt.asc dist/checksums.txt")
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		logs := captureLogs(t)
+		t.Setenv(GPGKeyID, "")
+		useFakeCommand(t, "gpg", "exit 1")
+
+		require.NoError(t, SignChecksums("dist/checksums.txt", GPGOptions{}))
+		assert.Contains(t, logs.String(), "WARNING: Skipping signing dist/checksums.txt with GPG because no key is configured")
+	})
+}
@@ -0,0 +1,87 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// SmokeTestOptions are the options for smoke testing the binaries built by XBuildAllWith.
+type SmokeTestOptions struct {
+	// Args passed to each binary. Defaults to version.
+	Args []string
+
+	// Expected is a string that the output must contain. Defaults to the version of the build.
+	Expected string
+}
+
+// SmokeTest runs `BIN version` for every binary in the directory that can run
+// on the current platform, and checks that it prints the version of the build.
+// This catches builds where the version wasn't injected, e.g. when the ldflags
+// package path is wrong. Binaries for other platforms are skipped.
+func SmokeTest(binDir string) error {
+	return SmokeTestWith(binDir, SmokeTestOptions{})
+}
+
+// SmokeTestWith runs each binary in the directory that can run on the current
+// platform with the specified arguments, and checks its output.
+func SmokeTestWith(binDir string, opts SmokeTestOptions) error {
+	if len(opts.Args) == 0 {
+		opts.Args = []string{"version"}
+	}
+	if opts.Expected == "" {
+		opts.Expected = LoadMetadata().Version
+	}
+
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return fmt.Errorf("error listing binaries in %s: %w", binDir, err)
+	}
+
+	tested := 0
+	for _, entry := range entries {
+		platform, ok := parseBinaryPlatform(entry.Name())
+		if entry.IsDir() || !ok || platform != (Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}) {
+			continue
+		}
+
+		bin := filepath.Join(binDir, entry.Name())
+		output, err := shx.OutputE(bin, opts.Args...)
+		if err != nil {
+			return fmt.Errorf("smoke test of %s failed: %w", bin, err)
+		}
+		if !strings.Contains(output, opts.Expected) {
+			return fmt.Errorf("smoke test of %s failed: expected the output of %s %s to contain %q but got %q",
+				bin, entry.Name(), strings.Join(opts.Args, " "), opts.Expected, output)
+		}
+		tested++
+	}
+
+	if tested == 0 {
+		log.Printf("WARNING: no binaries in %s can run on %s/%s, skipping the smoke test\n", binDir, runtime.GOOS, runtime.GOARCH)
+	}
+	return nil
+}
+
+// parseBinaryPlatform gets the platform from a binary named NAME-GOOS-GOARCH, e.g. porter-linux-amd64,
+// including the variant of the architecture when it has one, e.g. porter-linux-armv7.
+func parseBinaryPlatform(filename string) (Platform, bool) {
+	parts := strings.Split(strings.TrimSuffix(filename, ".exe"), "-")
+	if len(parts) < 3 {
+		return Platform{}, false
+	}
+	platform := Platform{OS: parts[len(parts)-2], Arch: parts[len(parts)-1]}
+	for arch, supported := range platformVariants {
+		if variant := strings.TrimPrefix(platform.Arch, arch); variant != platform.Arch {
+			if _, ok := supported.variants[variant]; ok {
+				platform.Arch, platform.Variant = arch, variant
+			}
+		}
+	}
+	return platform, true
+}
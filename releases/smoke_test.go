@@ -0,0 +1,70 @@
+package releases
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeTest(t *testing.T) {
+	useMetadata(t, GitMetadata{Version: "v1.2.3", Commit: "8252b6e"})
+	host := runtime.GOOS + "-" + runtime.GOARCH
+
+	// useBinaries creates a binary for the host which prints the specified output,
+	// and a binary for another platform which fails if it's run
+	useBinaries := func(t *testing.T, output string) string {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-"+host), []byte("#!/bin/sh\necho \"$1 "+output+"\"\n"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "porter-plan9-386"), []byte("#!/bin/sh\nexit 1\n"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ChecksumsFile), nil, 0644))
+		return dir
+	}
+
+	t.Run("version injected", func(t *testing.T) {
+		dir := useBinaries(t, "porter v1.2.3 (8252b6e)")
+
+		require.NoError(t, SmokeTest(dir))
+	})
+
+	t.Run("version missing", func(t *testing.T) {
+		dir := useBinaries(t, "porter  ()")
+
+		err := SmokeTest(dir)
+		require.ErrorContains(t, err, `expected the output of porter-`+host+` version to contain "v1.2.3"`)
+	})
+
+	t.Run("custom command", func(t *testing.T) {
+		dir := useBinaries(t, "8252b6e")
+
+		err := SmokeTestWith(dir, SmokeTestOptions{Args: []string{"--version"}, Expected: "--version 8252b6e"})
+		require.NoError(t, err)
+	})
+
+	t.Run("no binaries for host", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		require.NoError(t, SmokeTest(t.TempDir()))
+		assert.Contains(t, logs.String(), "skipping the smoke test")
+	})
+}
+
+func TestParseBinaryPlatform(t *testing.T) {
+	p, ok := parseBinaryPlatform("porter-windows-amd64.exe")
+	require.True(t, ok)
+	assert.Equal(t, Platform{OS: "windows", Arch: "amd64"}, p)
+
+	p, ok = parseBinaryPlatform("az-mixin-linux-arm64")
+	require.True(t, ok)
+	assert.Equal(t, Platform{OS: "linux", Arch: "arm64"}, p)
+
+	p, ok = parseBinaryPlatform("porter-linux-armv7")
+	require.True(t, ok)
+	assert.Equal(t, Platform{OS: "linux", Arch: "arm", Variant: "v7"}, p)
+
+	_, ok = parseBinaryPlatform(ChecksumsFile)
+	assert.False(t, ok)
+}
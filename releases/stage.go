@@ -0,0 +1,181 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// ArtifactStore is where StageRelease uploads the artifacts of a build, and
+// PromoteRelease copies them from when they are promoted to a permalink.
+type ArtifactStore interface {
+	// Upload the file to the key, e.g. staging/COMMIT/porter-linux-amd64.
+	Upload(file string, key string) error
+
+	// Copy the object at srcKey to destKey, e.g. from staging/COMMIT/porter-linux-amd64 to latest/porter-linux-amd64.
+	Copy(srcKey string, destKey string) error
+
+	// List the keys of the objects that start with the prefix.
+	List(prefix string) ([]string, error)
+}
+
+// StagingStore is the store used by StageRelease and PromoteRelease, e.g. a
+// BucketStore. It must be set before staging a release.
+var StagingStore ArtifactStore
+
+// StagingPrefix is the prefix of the keys of staged artifacts in the StagingStore.
+var StagingPrefix = "staging"
+
+// stageIDPattern matches a stage ID, which is the hash of the commit that was staged.
+var stageIDPattern = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+// StageRelease uploads the artifacts in the directory to the StagingStore under
+// STAGINGPREFIX/COMMIT/FILENAME, so that they can be tested and then promoted
+// to a permalink with PromoteRelease without being rebuilt. The staged
+// artifacts are identified by the commit they were built from, which is
+// returned as the stage ID, so staging the same commit again replaces them.
+func StageRelease(artifactsDir string) (stageID string, err error) {
+	commit, err := retryGit("rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("error resolving the current commit: %w", err)
+	}
+	return stageRelease(StagingStore, commit, artifactsDir)
+}
+
+func stageRelease(store ArtifactStore, commit string, artifactsDir string) (string, error) {
+	if store == nil {
+		return "", fmt.Errorf("no staging store is configured, set StagingStore, e.g. to a BucketStore")
+	}
+
+	entries, err := os.ReadDir(artifactsDir)
+	if err != nil {
+		return "", fmt.Errorf("error listing release artifacts in %s: %w", artifactsDir, err)
+	}
+
+	prefix := stagePrefix(commit)
+	var staged int
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		key := prefix + entry.Name()
+		if err := store.Upload(filepath.Join(artifactsDir, entry.Name()), key); err != nil {
+			return "", ErrUploadFailed{Asset: entry.Name(), Destination: key, Err: err}
+		}
+		staged++
+	}
+	if staged == 0 {
+		return "", fmt.Errorf("no artifacts were found in %s to stage", artifactsDir)
+	}
+
+	log.Printf("Staged %d artifacts for %s to %s\n", staged, commit, prefix)
+	return commit, nil
+}
+
+// PromoteRelease copies the artifacts staged by StageRelease for the stage ID
+// to the permalink in the StagingStore, e.g. latest/porter-linux-amd64, and
+// moves the permalink tag to the staged commit. Permalinks that should not be
+// published return ErrPermalinkNotPublishable.
+func PromoteRelease(stageID string, permalink string) error {
+	return promoteRelease(StagingStore, LoadMetadata(), stageID, permalink)
+}
+
+func promoteRelease(store ArtifactStore, info GitMetadata, stageID string, permalink string) error {
+	if store == nil {
+		return fmt.Errorf("no staging store is configured, set StagingStore, e.g. to a BucketStore")
+	}
+	if !stageIDPattern.MatchString(stageID) {
+		return fmt.Errorf("invalid stage ID %q, it must be the commit returned by StageRelease", stageID)
+	}
+
+	// Check the permalink can be moved before copying anything to it
+	info.Permalink = permalink
+	if releaseVersion.MatchString(permalink) || !info.ShouldPublishPermalink() {
+		return fmt.Errorf("refusing to promote %s to %s: %w", stageID, permalink, ErrPermalinkNotPublishable)
+	}
+
+	prefix := stagePrefix(stageID)
+	keys, err := store.List(prefix)
+	if err != nil {
+		return fmt.Errorf("error listing the staged artifacts in %s: %w", prefix, err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no artifacts are staged for %s", stageID)
+	}
+
+	for _, key := range keys {
+		dest := path.Join(permalink, strings.TrimPrefix(key, prefix))
+		if err := store.Copy(key, dest); err != nil {
+			return fmt.Errorf("error promoting %s to %s: %w", key, dest, err)
+		}
+	}
+	log.Printf("Promoted %d artifacts for %s to %s\n", len(keys), stageID, permalink)
+
+	return movePermalinkTag(info, permalink, MoveTagOptions{Commit: stageID})
+}
+
+// stagePrefix returns the prefix of the keys of the artifacts staged for the commit, e.g. staging/COMMIT/.
+func stagePrefix(commit string) string {
+	return path.Join(StagingPrefix, commit) + "/"
+}
+
+// BucketStore is an ArtifactStore backed by an S3 compatible bucket, using the aws CLI.
+type BucketStore struct {
+	// Bucket is the name of the bucket, e.g. porter-staging.
+	Bucket string
+
+	// EndpointURL of the storage service when it isn't AWS, e.g. https://minio.example.com.
+	EndpointURL string
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// Upload the file to the key in the bucket.
+func (s BucketStore) Upload(file string, key string) error {
+	contentType, err := detectContentType(file)
+	if err != nil {
+		return err
+	}
+	return runOrLog(s.command("s3", "cp", file, s.url(key), "--content-type", contentType), s.DryRun)
+}
+
+// Copy the object at srcKey to destKey in the bucket.
+func (s BucketStore) Copy(srcKey string, destKey string) error {
+	return runOrLog(s.command("s3", "cp", s.url(srcKey), s.url(destKey)), s.DryRun)
+}
+
+// List the keys of the objects in the bucket that start with the prefix.
+func (s BucketStore) List(prefix string) ([]string, error) {
+	objects, err := listBucketObjects(s.bucket(), PruneOptions{EndpointURL: s.EndpointURL, Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = obj.Key
+	}
+	return keys, nil
+}
+
+func (s BucketStore) bucket() string {
+	return strings.TrimPrefix(s.Bucket, "s3://")
+}
+
+func (s BucketStore) url(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket(), key)
+}
+
+func (s BucketStore) command(args ...string) shx.PreparedCommand {
+	cmd := shx.Command("aws", args...)
+	if s.EndpointURL != "" {
+		cmd = cmd.Args("--endpoint-url", s.EndpointURL)
+	}
+	return cmd
+}
@@ -0,0 +1,95 @@
+package releases
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStore is an in-memory ArtifactStore.
+type fakeStore struct {
+	objects map[string]string
+}
+
+func (s *fakeStore) Upload(file string, key string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	s.objects[key] = string(data)
+	return nil
+}
+
+func (s *fakeStore) Copy(srcKey string, destKey string) error {
+	data, ok := s.objects[srcKey]
+	if !ok {
+		return errors.New("object not found")
+	}
+	s.objects[destKey] = data
+	return nil
+}
+
+func (s *fakeStore) List(prefix string) ([]string, error) {
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestStageAndPromoteRelease(t *testing.T) {
+	logs := captureLogs(t)
+	t.Setenv(DryRunMode, "true")
+	store := &fakeStore{objects: map[string]string{}}
+	commit := "8252b6e2cbe6a1e5f7e3a0f0c4d1b2a3f4e5d6c7"
+
+	artifactsDir := t.TempDir()
+	for _, name := range []string{"porter-linux-amd64", "porter-darwin-arm64"} {
+		require.NoError(t, os.WriteFile(filepath.Join(artifactsDir, name), []byte(name), 0644))
+	}
+
+	stageID, err := stageRelease(store, commit, artifactsDir)
+	require.NoError(t, err)
+	assert.Equal(t, commit, stageID, "the stage should be identified by the commit")
+	assert.Equal(t, map[string]string{
+		"staging/" + commit + "/porter-darwin-arm64": "porter-darwin-arm64",
+		"staging/" + commit + "/porter-linux-amd64":  "porter-linux-amd64",
+	}, store.objects)
+
+	info := GitMetadata{Permalink: "canary", Version: "v1.2.3", Branch: "main", IsTaggedRelease: true}
+	require.NoError(t, promoteRelease(store, info, stageID, "latest"))
+	assert.Equal(t, "porter-linux-amd64", store.objects["latest/porter-linux-amd64"])
+	assert.Equal(t, "porter-darwin-arm64", store.objects["latest/porter-darwin-arm64"])
+	assert.Contains(t, logs.String(), "[dry-run] git tag --force latest "+commit, "the tag should be moved to the staged commit")
+	assert.Contains(t, logs.String(), "[dry-run] git push --force origin refs/tags/latest")
+
+	t.Run("unknown stage", func(t *testing.T) {
+		err := promoteRelease(store, info, "fe72ff73", "latest")
+		require.EqualError(t, err, "no artifacts are staged for fe72ff73")
+	})
+
+	t.Run("invalid stage", func(t *testing.T) {
+		err := promoteRelease(store, info, "../latest", "canary")
+		require.ErrorContains(t, err, `invalid stage ID "../latest"`)
+	})
+
+	t.Run("unpublishable permalink", func(t *testing.T) {
+		err := promoteRelease(store, info, stageID, "v1.2.3")
+		require.ErrorIs(t, err, ErrPermalinkNotPublishable)
+		assert.NotContains(t, store.objects, "v1.2.3/porter-linux-amd64", "nothing should be copied to a permalink that isn't published")
+	})
+
+	t.Run("no store", func(t *testing.T) {
+		_, err := stageRelease(nil, commit, artifactsDir)
+		require.ErrorContains(t, err, "no staging store is configured")
+	})
+}
@@ -0,0 +1,65 @@
+package releases
+
+import (
+	"fmt"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// MoveTagOptions are the options for moving a permalink tag.
+type MoveTagOptions struct {
+	// Remote is the name or URL of the git remote to push the tag to. Defaults to RemoteName.
+	Remote string
+
+	// Commit that the tag points at, e.g. a commit that was staged with StageRelease. Defaults to HEAD.
+	Commit string
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// MovePermalinkTag points the permalink tag, e.g. canary, at the current
+// commit and force pushes it to the RemoteName remote, so that downloads from the permalink
+// resolve to the current build.
+func MovePermalinkTag(permalink string) error {
+	return MovePermalinkTagWith(permalink, MoveTagOptions{})
+}
+
+// MovePermalinkTagWith points the permalink tag, e.g. canary, at the current
+// commit and force pushes it to the remote. Permalinks that should not be
+// published are not moved and return ErrPermalinkNotPublishable, and version
+// tags such as v1.2.3 are never moved.
+func MovePermalinkTagWith(permalink string, opts MoveTagOptions) error {
+	return movePermalinkTag(LoadMetadata(), permalink, opts)
+}
+
+func movePermalinkTag(info GitMetadata, permalink string, opts MoveTagOptions) error {
+	if releaseVersion.MatchString(permalink) {
+		return fmt.Errorf("refusing to move the version tag %s, only permalink tags may be moved", permalink)
+	}
+
+	info.Permalink = permalink
+	if !info.ShouldPublishPermalink() {
+		return fmt.Errorf("refusing to move the permalink tag %s: %w", permalink, ErrPermalinkNotPublishable)
+	}
+
+	if opts.Remote == "" {
+		opts.Remote = RemoteName
+	}
+	if opts.Commit == "" {
+		opts.Commit = "HEAD"
+	}
+
+	err := runOrLog(shx.Command("git", "tag", "--force", permalink, opts.Commit), opts.DryRun)
+	if err != nil {
+		return fmt.Errorf("error moving the permalink tag %s: %w", permalink, err)
+	}
+
+	// A forced push replaces the remote tag in a single update, so the permalink
+	// never disappears from the remote part way through the move
+	err = runOrLog(shx.Command("git", "push", "--force", opts.Remote, "refs/tags/"+permalink), opts.DryRun)
+	if err != nil {
+		return fmt.Errorf("error pushing the permalink tag %s to %s: %w", permalink, opts.Remote, err)
+	}
+	return nil
+}
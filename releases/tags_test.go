@@ -0,0 +1,54 @@
+package releases
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMovePermalinkTag(t *testing.T) {
+	useMetadata(t, GitMetadata{})
+
+	t.Run("move canary", func(t *testing.T) {
+		useTestRepo(t)
+		remote := filepath.Join(t.TempDir(), "remote.git")
+		gitCommand(t, "init", "--bare", remote)
+		gitCommand(t, "remote", "add", "origin", remote)
+
+		gitCommand(t, "tag", "canary")
+		gitCommand(t, "push", "origin", "main", "canary")
+		gitCommit(t, "new feature")
+		head := gitCommand(t, "rev-parse", "HEAD")
+
+		require.NoError(t, MovePermalinkTag("canary"))
+
+		remoteTag := gitCommand(t, "--git-dir", remote, "rev-parse", "canary^{commit}")
+		assert.Equal(t, head, remoteTag, "the remote canary tag should point to HEAD")
+	})
+
+	t.Run("unpublished permalink", func(t *testing.T) {
+		useTestRepo(t)
+		gitCommand(t, "tag", "dev")
+		origTag := gitCommand(t, "rev-parse", "dev")
+		gitCommit(t, "new feature")
+
+		err := MovePermalinkTag("dev")
+		require.ErrorIs(t, err, ErrPermalinkNotPublishable)
+		assert.Equal(t, origTag, gitCommand(t, "rev-parse", "dev"), "the dev tag should not be moved")
+	})
+
+	t.Run("version tag", func(t *testing.T) {
+		err := MovePermalinkTag("v1.2.3")
+		require.ErrorContains(t, err, "refusing to move the version tag v1.2.3")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		logs := captureLogs(t)
+
+		require.NoError(t, MovePermalinkTagWith("latest", MoveTagOptions{Remote: "upstream", DryRun: true}))
+		assert.Contains(t, logs.String(), "[dry-run] git tag --force latest HEAD")
+		assert.Contains(t, logs.String(), "[dry-run] git push --force upstream refs/tags/latest")
+	})
+}
@@ -0,0 +1,123 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+// VerifyReleaseOptions are the options for verifying that a published release is complete.
+type VerifyReleaseOptions struct {
+	// Repository that the release was published to, e.g. github.com/getporter/porter.
+	// Defaults to the PORTER_RELEASE_REPOSITORY environment variable, and then
+	// the repository of the RemoteName remote, see DetectRepo.
+	Repository string
+
+	// Signed requires a signature, NAME.sig, for each expected asset and the checksums file.
+	Signed bool
+
+	// ChecksumFiles requires a checksum file, NAME.sha256sum, for each expected asset.
+	ChecksumFiles bool
+
+	// VerifyChecksums downloads checksums.txt and checks that every expected asset is listed in it.
+	VerifyChecksums bool
+}
+
+// VerifyRelease checks that the release for the current build, the version
+// for tagged releases or otherwise the permalink, has every expected asset
+// along with checksums.txt. Every missing asset is reported at once.
+func VerifyRelease(expected []string) error {
+	return VerifyReleaseWith(expected, VerifyReleaseOptions{})
+}
+
+// VerifyReleaseWith checks that the release for the current build has every
+// expected asset, using the specified options.
+func VerifyReleaseWith(expected []string, opts VerifyReleaseOptions) error {
+	repo, err := resolveReleaseRepository(opts.Repository)
+	if err != nil {
+		return err
+	}
+	opts.Repository = repo
+
+	return verifyRelease(opts.Repository, getReleaseTag(LoadMetadata()), expected, opts)
+}
+
+func verifyRelease(repo string, tag string, expected []string, opts VerifyReleaseOptions) error {
+	wantAssets := []string{ChecksumsFile}
+	if opts.Signed {
+		wantAssets = append(wantAssets, ChecksumsFile+".sig")
+	}
+	for _, name := range expected {
+		wantAssets = append(wantAssets, name)
+		if opts.ChecksumFiles {
+			checksumFile, _ := AddChecksumExt(name)
+			wantAssets = append(wantAssets, checksumFile)
+		}
+		if opts.Signed {
+			wantAssets = append(wantAssets, name+".sig")
+		}
+	}
+
+	missing, err := getMissingReleaseAssets(repo, tag, wantAssets)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, name := range missing {
+		problems = append(problems, "missing "+name)
+	}
+
+	if opts.VerifyChecksums && !contains(missing, ChecksumsFile) {
+		checksums, err := shx.OutputE("gh", "release", "download", tag, "-R", repo, "--pattern", ChecksumsFile, "--output", "-")
+		if err != nil {
+			return fmt.Errorf("error downloading %s from the %s release: %w", ChecksumsFile, tag, err)
+		}
+		for _, name := range expected {
+			if _, err := findChecksum(checksums, filepath.Base(name)); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("the %s release is incomplete:\n  - %s", tag, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// isReleaseComplete determines if the release of a tagged build was already
+// published with every expected asset, using the same checks as VerifyRelease,
+// so that a release that is triggered again for the same tag can be skipped.
+// Builds that aren't a tagged release are never complete, because their permalink
+// is updated by every build.
+func isReleaseComplete(info GitMetadata, repo string, expected []string, opts VerifyReleaseOptions) bool {
+	if !info.IsTaggedRelease || repo == "" {
+		return false
+	}
+
+	// A draft is left behind when a previous release failed before it was published
+	tag := getReleaseTag(info)
+	isDraft, err := shx.OutputE("gh", "release", "view", "-R", repo, tag, "--json", "isDraft", "--jq", ".isDraft")
+	if err != nil || isDraft != "false" {
+		return false
+	}
+	if err := verifyRelease(repo, tag, expected, opts); err != nil {
+		return false
+	}
+
+	log.Printf("%s was already released to %s with every asset, skipping the release. Set Force to release it again.\n", tag, repo)
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package releases
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyRelease(t *testing.T) {
+	// Report the assets attached to the release, and the contents of its checksums.txt
+	useFakeCommand(t, "gh", `if [ "$2" = "view" ]; then printf "checksums.txt\nchecksums.txt.sig\nporter-linux-amd64\nporter-linux-amd64.sig\nporter-darwin-arm64\n"; fi
+if [ "$2" = "download" ]; then printf "abc123  porter-linux-amd64\n"; fi`)
+	expected := []string{"porter-linux-amd64", "porter-darwin-arm64"}
+
+	t.Run("complete", func(t *testing.T) {
+		require.NoError(t, verifyRelease("github.com/example/porter", "v1.2.3", expected, VerifyReleaseOptions{}))
+	})
+
+	t.Run("missing assets", func(t *testing.T) {
+		err := verifyRelease("github.com/example/porter", "v1.2.3", append(expected, "porter-windows-amd64.exe"), VerifyReleaseOptions{Signed: true})
+		require.Error(t, err)
+		assert.Equal(t, `the v1.2.3 release is incomplete:
+  - missing porter-darwin-arm64.sig
+  - missing porter-windows-amd64.exe
+  - missing porter-windows-amd64.exe.sig`, err.Error())
+	})
+
+	t.Run("checksum files", func(t *testing.T) {
+		err := verifyRelease("github.com/example/porter", "v1.2.3", expected[:1], VerifyReleaseOptions{ChecksumFiles: true})
+		require.ErrorContains(t, err, "missing porter-linux-amd64.sha256sum")
+	})
+
+	t.Run("verify checksums", func(t *testing.T) {
+		err := verifyRelease("github.com/example/porter", "v1.2.3", expected, VerifyReleaseOptions{VerifyChecksums: true})
+		require.Error(t, err)
+		assert.Equal(t, `the v1.2.3 release is incomplete:
+  - porter-darwin-arm64 is not listed in checksums.txt`, err.Error())
+	})
+}
+
+func TestIsReleaseComplete(t *testing.T) {
+	captureLogs(t)
+	tagged := GitMetadata{Permalink: "latest", Version: "v1.2.3", IsTaggedRelease: true}
+	expected := []string{"porter-linux-amd64", "porter-darwin-arm64"}
+
+	// fakeRelease reports whether the release is a draft, and its assets
+	fakeRelease := func(t *testing.T, isDraft string) {
+		useFakeCommand(t, "gh", `if [ "$7" = "isDraft" ]; then echo `+isDraft+`; exit 0; fi
+if [ "$2" = "view" ]; then printf "checksums.txt\nporter-linux-amd64\nporter-darwin-arm64\n"; fi`)
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		logs := captureLogs(t)
+		fakeRelease(t, "false")
+
+		assert.True(t, isReleaseComplete(tagged, "github.com/example/porter", expected, VerifyReleaseOptions{}))
+		assert.Contains(t, logs.String(), "v1.2.3 was already released to github.com/example/porter with every asset, skipping the release")
+	})
+
+	t.Run("missing assets", func(t *testing.T) {
+		fakeRelease(t, "false")
+		assert.False(t, isReleaseComplete(tagged, "github.com/example/porter", append(expected, "porter-windows-amd64.exe"), VerifyReleaseOptions{}))
+	})
+
+	t.Run("draft", func(t *testing.T) {
+		fakeRelease(t, "true")
+		assert.False(t, isReleaseComplete(tagged, "github.com/example/porter", expected, VerifyReleaseOptions{}), "a draft left by a failed release should be published again")
+	})
+
+	t.Run("not released", func(t *testing.T) {
+		useFakeCommand(t, "gh", "exit 1")
+		assert.False(t, isReleaseComplete(tagged, "github.com/example/porter", expected, VerifyReleaseOptions{}))
+	})
+
+	t.Run("permalink", func(t *testing.T) {
+		fakeRelease(t, "false")
+		canary := GitMetadata{Permalink: "canary", Version: "v1.2.3-4-g8252b6e"}
+		assert.False(t, isReleaseComplete(canary, "github.com/example/porter", expected, VerifyReleaseOptions{}), "permalinks should always be published")
+	})
+}
@@ -0,0 +1,335 @@
+package releases
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/carolynvs/magex/shx"
+)
+
+// describeSuffix matches the suffix that git describe adds when the commit isn't tagged,
+// e.g. the -32-gfe72ff73 in v0.30.1-32-gfe72ff73, along with any build metadata such as +dirty.
+var describeSuffix = regexp.MustCompile(`-\d+-g[0-9a-f]+(\+.*)?$`)
+
+// trimDescribeSuffix removes the commit count and hash suffix added by git describe, keeping any build metadata.
+func trimDescribeSuffix(version string) string {
+	return describeSuffix.ReplaceAllString(version, "$1")
+}
+
+// BaseVersion returns the tag that the build was described from, e.g. v0.30.1
+// for the canary build v0.30.1-32-gfe72ff73+dirty, which is useful for display.
+// Prerelease versions keep their prerelease, e.g. v1.0.0-rc.1.
+func (m GitMetadata) BaseVersion() string {
+	return describeSuffix.ReplaceAllString(m.Version, "")
+}
+
+// Semver parses the version of the build, ignoring the suffix added by
+// git describe for untagged commits.
+func (m GitMetadata) Semver() (*semver.Version, error) {
+	v, err := semver.NewVersion(trimDescribeSuffix(m.Version))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse version %s as a semantic version: %w", m.Version, err)
+	}
+	return v, nil
+}
+
+// isPrerelease determines if the version has a prerelease component, e.g. v1.2.0-rc.1
+func isPrerelease(version string) bool {
+	v, err := semver.NewVersion(trimDescribeSuffix(version))
+	if err != nil {
+		return false
+	}
+	return v.Prerelease() != ""
+}
+
+// MajorTag returns the major version of the build, e.g. v1, or 1 when
+// TagHasVPrefix is false, which is useful
+// for tagging docker images. An empty string is returned when the version
+// isn't a semantic version.
+func (m GitMetadata) MajorTag() string {
+	v, err := m.Semver()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%d", versionPrefix(), v.Major())
+}
+
+// MajorMinorTag returns the major and minor version of the build, e.g. v1.2,
+// or 1.2 when TagHasVPrefix is false, which is useful for tagging docker images. An empty string is returned when
+// the version isn't a semantic version.
+func (m GitMetadata) MajorMinorTag() string {
+	v, err := m.Semver()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s%d.%d", versionPrefix(), v.Major(), v.Minor())
+}
+
+// RequireSignedTags configures ValidateRelease to fail when the tag of a
+// release isn't signed, or its signature can't be verified. Defaults to false.
+var RequireSignedTags bool
+
+// ValidateRelease checks that a tagged release was tagged on the correct
+// branch, e.g. that v1.2.3 was tagged on release/v1 and not release/v2.
+// Releases tagged on main may use any version. The tag must point at the
+// current commit, so that artifacts built from another line of history aren't
+// published as the release. When RequireSignedTags is set, the signature of the
+// tag is verified as well.
+func ValidateRelease() error {
+	info := LoadMetadata()
+	if !info.IsTaggedRelease {
+		return nil
+	}
+	if err := validateRelease(info, info.Branch); err != nil {
+		return err
+	}
+	if RequireSignedTags {
+		if err := verifyTagSignature(TagPrefix + info.Version); err != nil {
+			return err
+		}
+	}
+	return verifyTagCommit(TagPrefix + info.Version)
+}
+
+// verifyTagCommit checks that the tag points at the current commit.
+func verifyTagCommit(tag string) error {
+	tagCommit, err := retryGit("rev-parse", tag+"^{commit}")
+	if err != nil {
+		return fmt.Errorf("error resolving the commit of the release tag %s: %w", tag, err)
+	}
+	head, err := retryGit("rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("error resolving the current commit: %w", err)
+	}
+	if tagCommit != head {
+		return fmt.Errorf("the release tag %s points at %s but the current commit is %s", tag, tagCommit, head)
+	}
+	return nil
+}
+
+// verifyTagSignature checks that the tag is signed with a trusted key, using git tag -v.
+// Lightweight tags and annotated tags without a signature fail verification.
+func verifyTagSignature(tag string) error {
+	if _, err := retryGit("tag", "-v", tag); err != nil {
+		return fmt.Errorf("the release tag %s does not have a verified signature: %w", tag, err)
+	}
+	return nil
+}
+
+// validateRelease checks that the major version of the release matches the
+// release branch, which is the short name returned by GetBranchName, e.g. v1.
+func validateRelease(info GitMetadata, branch string) error {
+	if !info.IsTaggedRelease || !strings.HasPrefix(branch, "v") {
+		return nil
+	}
+
+	v, err := info.Semver()
+	if err != nil {
+		return err
+	}
+
+	branchMajor, err := strconv.ParseUint(strings.TrimPrefix(branch, "v"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("could not parse the major version of the release branch release/%s: %w", branch, err)
+	}
+	if v.Major() != branchMajor {
+		return fmt.Errorf("the release %s was tagged on the release/%s branch but its major version should be %d", info.Version, branch, branchMajor)
+	}
+	return nil
+}
+
+// CommitsSinceTag returns the number of commits since the most recent version
+// tag, e.g. 32 for v0.30.1-32-gfe72ff73, or 0 when the current commit is tagged.
+// It's useful as a build number for package managers that require an
+// increasing integer. When the repository doesn't have any tags, every commit is counted.
+func CommitsSinceTag() (int, error) {
+	description, err := describeHead()
+	if err != nil {
+		return 0, err
+	}
+	return description.CommitsSinceTag, nil
+}
+
+// PreviousVersion returns the highest stable release that came before the current
+// version, e.g. v1.9.0 for v1.10.0, which is useful for changelogs and upgrade tests.
+// Prereleases are ignored. Untagged builds are newer than the tag they were built from,
+// so that tag is the previous version, e.g. v1.2.3 for v1.2.3-4-g8252b6e.
+func PreviousVersion() (string, error) {
+	tags, err := ListVersionTags(versionTagPattern())
+	if err != nil {
+		return "", err
+	}
+	return pickPreviousVersion(LoadMetadata(), tags)
+}
+
+func pickPreviousVersion(info GitMetadata, tags []semver.Version) (string, error) {
+	current, err := info.Semver()
+	if err != nil {
+		return "", err
+	}
+
+	for _, v := range sortStableVersions(tags) {
+		if v.LessThan(current) || (!info.IsTaggedRelease && v.Equal(current)) {
+			return v.Original(), nil
+		}
+	}
+	return "", fmt.Errorf("no release found before %s", info.Version)
+}
+
+// LastNMinorReleases returns the highest patch release of each of the last n
+// minor versions before the current version, highest first, e.g. v1.9.2, v1.8.5
+// and v1.7.0 for v1.10.0, which is useful for upgrade test matrices.
+// Prereleases are ignored. Untagged builds are newer than the tag they were
+// built from, so its minor version is included. Fewer than n releases are
+// returned when there aren't enough minor versions.
+func LastNMinorReleases(n int) ([]string, error) {
+	tags, err := ListVersionTags(versionTagPattern())
+	if err != nil {
+		return nil, err
+	}
+	return pickLastNMinorReleases(LoadMetadata(), tags, n)
+}
+
+func pickLastNMinorReleases(info GitMetadata, tags []semver.Version, n int) ([]string, error) {
+	current, err := info.Semver()
+	if err != nil {
+		return nil, err
+	}
+	currentMinor := semver.New(current.Major(), current.Minor(), 0, "", "")
+
+	var releases []string
+	var lastMinor *semver.Version
+	for _, v := range sortStableVersions(tags) {
+		if len(releases) >= n {
+			break
+		}
+
+		minor := semver.New(v.Major(), v.Minor(), 0, "", "")
+		if (info.IsTaggedRelease && !minor.LessThan(currentMinor)) || v.GreaterThan(current) {
+			continue
+		}
+		// The versions are sorted highest first, so the first of each minor version is its highest patch
+		if lastMinor != nil && minor.Equal(lastMinor) {
+			continue
+		}
+		lastMinor = minor
+		releases = append(releases, v.Original())
+	}
+	return releases, nil
+}
+
+// BumpOptions are the options for bumping the version with BumpVersionWith.
+type BumpOptions struct {
+	// Tag the current commit with the new version, e.g. v1.3.0.
+	Tag bool
+
+	// DryRun logs the commands that would be run, without executing them.
+	DryRun bool
+}
+
+// BumpVersion returns the next version after the highest stable version tag,
+// incrementing the part of the version: major, minor or patch. For example
+// bumping minor after v1.2.3 returns v1.3.0. Repositories without any tags
+// bump from v0.0.0. Bumping fails when the working tree has uncommitted changes.
+func BumpVersion(part string) (string, error) {
+	return BumpVersionWith(part, BumpOptions{})
+}
+
+// BumpVersionWith returns the next version, like BumpVersion, and tags the current commit with it when Tag is set.
+func BumpVersionWith(part string, opts BumpOptions) (string, error) {
+	status, err := retryGit("status", "--porcelain")
+	if err != nil {
+		return "", fmt.Errorf("could not check the status of the working tree: %w", err)
+	}
+	if strings.TrimSpace(status) != "" {
+		return "", fmt.Errorf("refusing to bump the version because the working tree has uncommitted changes")
+	}
+
+	tags, err := ListVersionTags(versionTagPattern())
+	if err != nil {
+		return "", err
+	}
+	next, err := bumpVersion(tags, part)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Tag {
+		if err := runOrLog(shx.Command("git", "tag", TagPrefix+next), opts.DryRun); err != nil {
+			return "", fmt.Errorf("error tagging the version %s: %w", next, err)
+		}
+	}
+	return next, nil
+}
+
+// bumpVersion increments the part of the highest stable version in the tags.
+func bumpVersion(tags []semver.Version, part string) (string, error) {
+	current := semver.New(0, 0, 0, "", "")
+	if versions := sortStableVersions(tags); len(versions) > 0 {
+		current = versions[0]
+	}
+
+	var next semver.Version
+	switch part {
+	case "major":
+		next = current.IncMajor()
+	case "minor":
+		next = current.IncMinor()
+	case "patch":
+		next = current.IncPatch()
+	default:
+		return "", fmt.Errorf("invalid version part %q, it must be major, minor or patch", part)
+	}
+	return versionPrefix() + next.String(), nil
+}
+
+// ListVersionTags returns the tags matching the pattern, e.g. v*, as semantic
+// versions sorted from lowest to highest, so that v1.9.0 comes before v1.10.0.
+// The TagPrefix is removed from the tags, and tags that aren't a semantic version
+// are skipped with a warning.
+func ListVersionTags(match string) ([]semver.Version, error) {
+	tags, err := retryGit("tag", "--list", match)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the version tags: %w", err)
+	}
+	return parseVersionTags(strings.Split(tags, "\n")), nil
+}
+
+// parseVersionTags parses the tags as semantic versions, sorted from lowest to highest.
+func parseVersionTags(tags []string) []semver.Version {
+	var versions []semver.Version
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), TagPrefix)
+		if tag == "" {
+			continue
+		}
+
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			log.Printf("WARNING: Skipping the tag %s because it isn't a semantic version: %s\n", tag, err)
+			continue
+		}
+		versions = append(versions, *v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].LessThan(&versions[j])
+	})
+	return versions
+}
+
+// sortStableVersions returns the versions that are stable, sorted highest first.
+// Prereleases are skipped.
+func sortStableVersions(versions []semver.Version) []*semver.Version {
+	var stable []*semver.Version
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].Prerelease() == "" {
+			stable = append(stable, &versions[i])
+		}
+	}
+	return stable
+}
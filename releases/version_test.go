@@ -0,0 +1,333 @@
+package releases
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitMetadata_Semver(t *testing.T) {
+	testcases := []struct {
+		version        string
+		wantVersion    string
+		wantPrerelease string
+		wantMetadata   string
+		wantMajor      string
+		wantMajorMinor string
+	}{
+		{version: "v1.2.3", wantVersion: "1.2.3", wantMajor: "v1", wantMajorMinor: "v1.2"},
+		{version: "v0.30.1-32-gfe72ff73", wantVersion: "0.30.1", wantMajor: "v0", wantMajorMinor: "v0.30"},
+		{version: "v0.30.1-32-gfe72ff73+dirty", wantVersion: "0.30.1+dirty", wantMetadata: "dirty", wantMajor: "v0", wantMajorMinor: "v0.30"},
+		{version: "v1.0.0-rc.1", wantVersion: "1.0.0-rc.1", wantPrerelease: "rc.1", wantMajor: "v1", wantMajorMinor: "v1.0"},
+		{version: "v1.0.0-rc.1-4-g8252b6e", wantVersion: "1.0.0-rc.1", wantPrerelease: "rc.1", wantMajor: "v1", wantMajorMinor: "v1.0"},
+		{version: "v0.0.0", wantVersion: "0.0.0", wantMajor: "v0", wantMajorMinor: "v0.0"},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.version, func(t *testing.T) {
+			m := GitMetadata{Version: tc.version}
+
+			v, err := m.Semver()
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantVersion, v.String())
+			assert.Equal(t, tc.wantPrerelease, v.Prerelease())
+			assert.Equal(t, tc.wantMetadata, v.Metadata())
+			assert.Equal(t, tc.wantMajor, m.MajorTag())
+			assert.Equal(t, tc.wantMajorMinor, m.MajorMinorTag())
+		})
+	}
+
+	t.Run("invalid version", func(t *testing.T) {
+		m := GitMetadata{Version: "canary"}
+
+		_, err := m.Semver()
+		require.ErrorContains(t, err, "could not parse version canary")
+		assert.Empty(t, m.MajorTag())
+		assert.Empty(t, m.MajorMinorTag())
+	})
+}
+
+func TestGitMetadata_BaseVersion(t *testing.T) {
+	testcases := map[string]string{
+		"v0.30.1-32-gfe72ff73":       "v0.30.1",
+		"v0.30.1-32-gfe72ff73+dirty": "v0.30.1",
+		"v1.2.3":                     "v1.2.3",
+		"v1.0.0-rc.1":                "v1.0.0-rc.1",
+		"v1.0.0-rc.1-4-g8252b6e":     "v1.0.0-rc.1",
+		"v1.0.0-beta-2":              "v1.0.0-beta-2",
+	}
+
+	for version, want := range testcases {
+		m := GitMetadata{Version: version}
+		assert.Equal(t, want, m.BaseVersion(), "unexpected base version for %s", version)
+	}
+}
+
+func TestValidateRelease(t *testing.T) {
+	testcases := []struct {
+		name    string
+		info    GitMetadata
+		branch  string
+		wantErr string
+	}{
+		{
+			name:   "matching release branch",
+			info:   GitMetadata{Permalink: "latest-v1", Version: "v1.2.3", IsTaggedRelease: true},
+			branch: "v1",
+		},
+		{
+			name:    "mismatched release branch",
+			info:    GitMetadata{Permalink: "latest-v1", Version: "v2.0.0", IsTaggedRelease: true},
+			branch:  "v1",
+			wantErr: "the release v2.0.0 was tagged on the release/v1 branch but its major version should be 1",
+		},
+		{
+			name:   "any version on main",
+			info:   GitMetadata{Permalink: "latest", Version: "v2.0.0", IsTaggedRelease: true},
+			branch: "main",
+		},
+		{
+			name:   "untagged builds are not validated",
+			info:   GitMetadata{Permalink: "canary-v1", Version: "v2.0.0-3-g8252b6e"},
+			branch: "v1",
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateRelease(tc.info, tc.branch)
+			if tc.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestPickPreviousVersion(t *testing.T) {
+	tags := []string{"v1.9.0", "v1.10.0-rc.1", "v1.2.0", "v1.10.0", "v0.38.1", "v1.11.0-beta.1", "v1.9.1", "canary", ""}
+
+	testcases := []struct {
+		name        string
+		info        GitMetadata
+		wantVersion string
+	}{
+		{name: "current is the highest tag", info: GitMetadata{Version: "v1.10.0", IsTaggedRelease: true}, wantVersion: "v1.9.1"},
+		{name: "patch release", info: GitMetadata{Version: "v1.9.1", IsTaggedRelease: true}, wantVersion: "v1.9.0"},
+		{name: "prerelease", info: GitMetadata{Version: "v1.11.0-beta.1", IsTaggedRelease: true, IsPrerelease: true}, wantVersion: "v1.10.0"},
+		{name: "canary", info: GitMetadata{Version: "v1.10.0-4-g8252b6e"}, wantVersion: "v1.10.0"},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			gotVersion, err := pickPreviousVersion(tc.info, parseVersionTags(tags))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantVersion, gotVersion)
+		})
+	}
+
+	t.Run("first release", func(t *testing.T) {
+		_, err := pickPreviousVersion(GitMetadata{Version: "v0.38.1", IsTaggedRelease: true}, parseVersionTags(tags))
+		require.ErrorContains(t, err, "no release found before v0.38.1")
+	})
+}
+
+func TestPickLastNMinorReleases(t *testing.T) {
+	tags := []string{"v1.7.0", "v1.8.0", "v1.8.5", "v1.8.10", "v1.9.0", "v1.9.2", "v1.10.0-rc.1", "v1.10.0", "v1.10.1", "v1.11.0-beta.1", "v0.38.1", "canary", ""}
+
+	testcases := []struct {
+		name         string
+		info         GitMetadata
+		n            int
+		wantReleases []string
+	}{
+		{name: "tagged release", info: GitMetadata{Version: "v1.10.1", IsTaggedRelease: true}, n: 3, wantReleases: []string{"v1.9.2", "v1.8.10", "v1.7.0"}},
+		{name: "prerelease", info: GitMetadata{Version: "v1.11.0-beta.1", IsTaggedRelease: true, IsPrerelease: true}, n: 2, wantReleases: []string{"v1.10.1", "v1.9.2"}},
+		{name: "canary", info: GitMetadata{Version: "v1.10.0-4-g8252b6e"}, n: 2, wantReleases: []string{"v1.10.0", "v1.9.2"}},
+		{name: "major version", info: GitMetadata{Version: "v1.7.0", IsTaggedRelease: true}, n: 3, wantReleases: []string{"v0.38.1"}},
+		{name: "first release", info: GitMetadata{Version: "v0.38.1", IsTaggedRelease: true}, n: 3, wantReleases: nil},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			gotReleases, err := pickLastNMinorReleases(tc.info, parseVersionTags(tags), tc.n)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantReleases, gotReleases)
+		})
+	}
+}
+
+func TestBumpVersion(t *testing.T) {
+	tags := []string{"v1.9.0", "v1.10.0", "v1.10.1", "v1.11.0-rc.1", "canary", ""}
+
+	testcases := []struct {
+		part        string
+		wantVersion string
+	}{
+		{part: "major", wantVersion: "v2.0.0"},
+		{part: "minor", wantVersion: "v1.11.0"},
+		{part: "patch", wantVersion: "v1.10.2"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.part, func(t *testing.T) {
+			gotVersion, err := bumpVersion(parseVersionTags(tags), tc.part)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantVersion, gotVersion)
+		})
+	}
+
+	t.Run("no tags", func(t *testing.T) {
+		gotVersion, err := bumpVersion(nil, "minor")
+		require.NoError(t, err)
+		assert.Equal(t, "v0.1.0", gotVersion)
+	})
+
+	t.Run("invalid part", func(t *testing.T) {
+		_, err := bumpVersion(parseVersionTags(tags), "build")
+		require.ErrorContains(t, err, `invalid version part "build"`)
+	})
+
+	t.Run("tag", func(t *testing.T) {
+		useTestRepo(t)
+		gitCommand(t, "tag", "v1.2.3")
+		gitCommit(t, "feat: add arm64")
+
+		gotVersion, err := BumpVersionWith("minor", BumpOptions{Tag: true})
+		require.NoError(t, err)
+		assert.Equal(t, "v1.3.0", gotVersion)
+		assert.Equal(t, gitCommand(t, "rev-parse", "HEAD"), gitCommand(t, "rev-parse", "v1.3.0^{commit}"), "the new version should be tagged")
+	})
+
+	t.Run("dirty working tree", func(t *testing.T) {
+		dir := useTestRepo(t)
+		gitCommand(t, "tag", "v1.2.3")
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "VERSION"), []byte("v1.2.3"), 0644))
+
+		_, err := BumpVersion("patch")
+		require.ErrorContains(t, err, "refusing to bump the version because the working tree has uncommitted changes")
+	})
+}
+
+func TestPreviousVersion(t *testing.T) {
+	unsetBuildEnvironment(t)
+	useTestRepo(t)
+	for _, tag := range []string{"v1.9.0", "v1.10.0"} {
+		gitCommit(t, "fix: "+tag)
+		gitCommand(t, "tag", tag)
+	}
+	useMetadata(t, GitMetadata{Version: "v1.10.0", IsTaggedRelease: true})
+
+	gotVersion, err := PreviousVersion()
+	require.NoError(t, err)
+	assert.Equal(t, "v1.9.0", gotVersion)
+}
+
+func TestValidateRelease_RequireSignedTags(t *testing.T) {
+	RequireSignedTags = true
+	t.Cleanup(func() { RequireSignedTags = false })
+	useMetadata(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Branch: "main", IsTaggedRelease: true})
+
+	t.Run("unsigned tag", func(t *testing.T) {
+		useFakeCommand(t, "git", `echo "error: no signature found" >&2; exit 1`)
+
+		err := ValidateRelease()
+		require.ErrorContains(t, err, "the release tag v1.2.3 does not have a verified signature")
+		assert.ErrorContains(t, err, "no signature found")
+	})
+
+	t.Run("verified signature", func(t *testing.T) {
+		useFakeCommand(t, "git", `[ "$1" = "rev-parse" ] && echo 8252b6e && exit 0
+[ "$1 $2 $3" = "tag -v v1.2.3" ] || exit 1
+echo 'gpg: Good signature from "Porter Bot <bot@porter.sh>"' >&2`)
+
+		require.NoError(t, ValidateRelease())
+	})
+}
+
+func TestValidateRelease_TagCommit(t *testing.T) {
+	useTestRepo(t)
+	gitCommit(t, "release v1.2.3")
+	gitCommand(t, "tag", "v1.2.3")
+	useMetadata(t, GitMetadata{Permalink: "latest", Version: "v1.2.3", Branch: "main", IsTaggedRelease: true})
+
+	t.Run("tag at HEAD", func(t *testing.T) {
+		require.NoError(t, ValidateRelease())
+	})
+
+	t.Run("tag on another commit", func(t *testing.T) {
+		tagCommit := gitCommand(t, "rev-parse", "HEAD")
+		gitCommit(t, "unreleased change")
+		head := gitCommand(t, "rev-parse", "HEAD")
+
+		err := ValidateRelease()
+		require.EqualError(t, err, fmt.Sprintf("the release tag v1.2.3 points at %s but the current commit is %s", tagCommit, head))
+	})
+}
+
+func TestCommitsSinceTag(t *testing.T) {
+	t.Run("untagged commits", func(t *testing.T) {
+		useFakeCommand(t, "git", `case "$*" in
+  "describe --tags --match=v* --long --dirty") echo v0.30.1-32-gfe72ff73 ;;
+  *) exit 1 ;;
+esac`)
+
+		n, err := CommitsSinceTag()
+		require.NoError(t, err)
+		assert.Equal(t, 32, n)
+	})
+
+	t.Run("clean tag", func(t *testing.T) {
+		useTestRepo(t)
+		gitCommit(t, "release v1.2.3")
+		gitCommand(t, "tag", "v1.2.3")
+
+		n, err := CommitsSinceTag()
+		require.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		gitCommit(t, "first change")
+		gitCommit(t, "second change")
+		n, err = CommitsSinceTag()
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		useTestRepo(t)
+		gitCommit(t, "second commit")
+
+		n, err := CommitsSinceTag()
+		require.NoError(t, err)
+		assert.Equal(t, 2, n, "every commit should be counted, including the initial commit of the test repository")
+	})
+}
+
+func TestListVersionTags(t *testing.T) {
+	logs := captureLogs(t)
+	useTestRepo(t)
+	gitCommit(t, "initial commit")
+	for _, tag := range []string{"v1.10.0", "v1.9.0", "v1.10.0-rc.1", "vNext", "v2", "very-old", "canary"} {
+		gitCommand(t, "tag", tag)
+	}
+
+	versions, err := ListVersionTags("v*")
+	require.NoError(t, err)
+	var got []string
+	for _, v := range versions {
+		got = append(got, v.Original())
+	}
+	assert.Equal(t, []string{"v1.9.0", "v1.10.0-rc.1", "v1.10.0", "v2"}, got, "the versions should be sorted by semver")
+	assert.Contains(t, logs.String(), "WARNING: Skipping the tag vNext because it isn't a semantic version")
+	assert.Contains(t, logs.String(), "WARNING: Skipping the tag very-old")
+	assert.NotContains(t, logs.String(), "canary", "tags that don't match should not be listed")
+}
@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/carolynvs/magex/shx"
+)
+
+// Tool is a command that is required by the build.
+type Tool struct {
+	// Name of the command, e.g. gh.
+	Name string
+
+	// VersionArgs are the arguments that print the version of the command.
+	// Defaults to --version. When they are set, the command must succeed even
+	// without a MinVersion, e.g. docker buildx version fails when the buildx plugin isn't installed.
+	VersionArgs []string
+
+	// MinVersion is the minimum supported version of the command, e.g. 2.0.0.
+	// When empty, any version is accepted.
+	MinVersion string
+
+	// InstallHint explains how to install the command, e.g. a link to its installation instructions.
+	InstallHint string
+}
+
+var (
+	// GitHubClientTool is the gh CLI, used to publish GitHub releases.
+	GitHubClientTool = Tool{Name: "gh", MinVersion: DefaultGitHubClientVersion, InstallHint: "Run mage EnsureGitHubClient or see https://cli.github.com"}
+
+	// CosignTool is used to sign release artifacts.
+	CosignTool = Tool{Name: "cosign", VersionArgs: []string{"version"}, InstallHint: "See https://docs.sigstore.dev/cosign/installation"}
+
+	// BuildxTool is the docker buildx plugin, used to publish multi-platform images.
+	BuildxTool = Tool{Name: "docker", VersionArgs: []string{"buildx", "version"}, InstallHint: "See https://docs.docker.com/build/install-buildx"}
+
+	// SyftTool is used to generate SBOMs for release artifacts.
+	SyftTool = Tool{Name: "syft", VersionArgs: []string{"version"}, InstallHint: "See https://github.com/anchore/syft#installation"}
+)
+
+// toolVersion matches the version printed by a tool, e.g. 2.27.0 in "gh version 2.27.0 (2023-04-07)"
+var toolVersion = regexp.MustCompile(`v?\d+\.\d+(\.\d+)?(-[0-9A-Za-z.\-]+)?`)
+
+// EnsureTools checks that the specified tools are installed and meet their
+// minimum version, so that a build fails fast instead of partway through.
+// The returned error lists every tool that is missing or too old.
+func EnsureTools(tools ...Tool) error {
+	var problems []string
+	for _, tool := range tools {
+		if err := checkTool(tool); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("required tools are missing or out of date:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func checkTool(tool Tool) error {
+	withHint := func(msg string) error {
+		if tool.InstallHint != "" {
+			msg += ". " + tool.InstallHint
+		}
+		return errors.New(msg)
+	}
+
+	if _, err := exec.LookPath(tool.Name); err != nil {
+		return withHint(fmt.Sprintf("%s is not installed", tool.Name))
+	}
+	if tool.MinVersion == "" && len(tool.VersionArgs) == 0 {
+		return nil
+	}
+
+	versionArgs := tool.VersionArgs
+	if len(versionArgs) == 0 {
+		versionArgs = []string{"--version"}
+	}
+	prettyCmd := strings.Join(append([]string{tool.Name}, versionArgs...), " ")
+	output, err := shx.Command(tool.Name, versionArgs...).OutputE()
+	if err != nil {
+		return withHint(fmt.Sprintf("could not determine the installed version of %s with '%s': %s", tool.Name, prettyCmd, err))
+	}
+	if tool.MinVersion == "" {
+		return nil
+	}
+
+	minVersion, err := semver.NewVersion(tool.MinVersion)
+	if err != nil {
+		return fmt.Errorf("invalid minimum version %q for %s: %w", tool.MinVersion, tool.Name, err)
+	}
+
+	version, err := semver.NewVersion(toolVersion.FindString(output))
+	if err != nil {
+		return withHint(fmt.Sprintf("could not parse the version of %s from the output of '%s': %s", tool.Name, prettyCmd, output))
+	}
+	if version.LessThan(minVersion) {
+		return withHint(fmt.Sprintf("%s %s is installed but version %s or higher is required", tool.Name, version, minVersion))
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package tools_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"get.porter.sh/magefiles/tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureTools(t *testing.T) {
+	// Only the fake commands are on the PATH
+	binDir := t.TempDir()
+	t.Setenv("PATH", binDir)
+	for name, script := range map[string]string{
+		"gh":     `echo "gh version 2.27.0 (2023-04-07)"`,
+		"docker": `[ "$1 $2" = "buildx version" ] && echo "github.com/docker/buildx v0.10.4 c513d34"`,
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755))
+	}
+
+	t.Run("installed", func(t *testing.T) {
+		err := tools.EnsureTools(
+			tools.Tool{Name: "gh", MinVersion: "2.20.0"},
+			tools.Tool{Name: "docker", VersionArgs: []string{"buildx", "version"}, MinVersion: "0.10.0"},
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("missing and too old", func(t *testing.T) {
+		err := tools.EnsureTools(
+			tools.Tool{Name: "gh", MinVersion: "2.30.0", InstallHint: "Run mage EnsureGitHubClient"},
+			tools.Tool{Name: "cosign", InstallHint: "See https://docs.sigstore.dev/cosign/installation"},
+			tools.Tool{Name: "docker", VersionArgs: []string{"buildx", "version"}},
+		)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "gh 2.27.0 is installed but version 2.30.0 or higher is required. Run mage EnsureGitHubClient")
+		assert.Contains(t, err.Error(), "cosign is not installed. See https://docs.sigstore.dev/cosign/installation")
+		assert.NotContains(t, err.Error(), "docker", "tools without a minimum version only need their version command to succeed")
+	})
+
+	t.Run("version command fails without a minimum version", func(t *testing.T) {
+		err := tools.EnsureTools(tools.Tool{Name: "docker", VersionArgs: []string{"compose", "version"}, InstallHint: "Install the compose plugin"})
+		require.ErrorContains(t, err, "could not determine the installed version of docker with 'docker compose version'")
+		assert.Contains(t, err.Error(), "Install the compose plugin")
+	})
+
+	t.Run("version command fails", func(t *testing.T) {
+		err := tools.EnsureTools(tools.Tool{Name: "docker", MinVersion: "20.0.0"})
+		require.ErrorContains(t, err, "could not determine the installed version of docker with 'docker --version'")
+	})
+}